@@ -0,0 +1,152 @@
+// Tideland GoREST - Handlers - API Key Authentication
+//
+// Copyright (C) 2009-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package handlers
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"github.com/tideland/gorest/rest"
+)
+
+//--------------------
+// API KEY AUTHENTICATION HANDLER
+//--------------------
+
+// APIKeyAuthConfig allows to control how the API key authentication
+// handler works. The verifier is needed, without it all requests are
+// denied. The key is read from the header "X-API-Key" by default. The
+// query parameter is only checked if it is set, because URLs are
+// often logged. The gatekeeper is optional and in case of a denial
+// a warning is written with the standard logger.
+type APIKeyAuthConfig struct {
+	Header     string
+	Parameter  string
+	Verifier   APIKeyVerifier
+	Gatekeeper func(job rest.Job, principal *Principal) error
+	Logger     func(job rest.Job, msg string)
+}
+
+// apiKeyAuthHandler checks the API key of a request and then runs
+// a gatekeeper function. If everything is fine the principal is stored
+// in the job context for the following handlers.
+type apiKeyAuthHandler struct {
+	id         string
+	header     string
+	parameter  string
+	verifier   APIKeyVerifier
+	gatekeeper func(job rest.Job, principal *Principal) error
+	logger     func(job rest.Job, msg string)
+}
+
+// NewAPIKeyAuthHandler creates a handler checking the API key
+// in each request.
+func NewAPIKeyAuthHandler(id string, config *APIKeyAuthConfig) rest.ResourceHandler {
+	h := &apiKeyAuthHandler{
+		id:     id,
+		header: "X-API-Key",
+		logger: defaultDenyLogger,
+	}
+	if config != nil {
+		if config.Header != "" {
+			h.header = config.Header
+		}
+		if config.Parameter != "" {
+			h.parameter = config.Parameter
+		}
+		if config.Verifier != nil {
+			h.verifier = config.Verifier
+		}
+		if config.Gatekeeper != nil {
+			h.gatekeeper = config.Gatekeeper
+		}
+		if config.Logger != nil {
+			h.logger = config.Logger
+		}
+	}
+	return h
+}
+
+// ID is specified on the ResourceHandler interface.
+func (h *apiKeyAuthHandler) ID() string {
+	return h.id
+}
+
+// Init is specified on the ResourceHandler interface.
+func (h *apiKeyAuthHandler) Init(env rest.Environment, domain, resource string) error {
+	return nil
+}
+
+// Get is specified on the GetResourceHandler interface.
+func (h *apiKeyAuthHandler) Get(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Head is specified on the HeadResourceHandler interface.
+func (h *apiKeyAuthHandler) Head(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Put is specified on the PutResourceHandler interface.
+func (h *apiKeyAuthHandler) Put(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Post is specified on the PostResourceHandler interface.
+func (h *apiKeyAuthHandler) Post(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Patch is specified on the PatchResourceHandler interface.
+func (h *apiKeyAuthHandler) Patch(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Delete is specified on the DeleteResourceHandler interface.
+func (h *apiKeyAuthHandler) Delete(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Options is specified on the OptionsResourceHandler interface.
+func (h *apiKeyAuthHandler) Options(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// check is used by all methods to check the API key.
+func (h *apiKeyAuthHandler) check(job rest.Job) (bool, error) {
+	apiKey := job.Request().Header.Get(h.header)
+	if apiKey == "" && h.parameter != "" {
+		apiKey = job.Request().URL.Query().Get(h.parameter)
+	}
+	if apiKey == "" {
+		return deny(job, h.logger, rest.StatusUnauthorized, "no API key")
+	}
+	if h.verifier == nil {
+		return deny(job, h.logger, rest.StatusUnauthorized, "no API key verifier")
+	}
+	principal, err := h.verifier.VerifyAPIKey(job, apiKey)
+	if err != nil {
+		return deny(job, h.logger, rest.StatusUnauthorized, "invalid API key: "+err.Error())
+	}
+	if principal == nil {
+		return deny(job, h.logger, rest.StatusUnauthorized, "invalid API key")
+	}
+	principal.Method = AuthenticationAPIKey
+	if h.gatekeeper != nil {
+		err := h.gatekeeper(job, principal)
+		if err != nil {
+			return deny(job, h.logger, rest.StatusUnauthorized, "access rejected by gatekeeper: "+err.Error())
+		}
+	}
+	// All fine, store principal in context.
+	authenticated(job, principal, nil)
+	return true, nil
+}
+
+// EOF
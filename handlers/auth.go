@@ -0,0 +1,140 @@
+// Tideland GoREST - Handlers - Authentication
+//
+// Copyright (C) 2009-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package handlers
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+
+	"github.com/tideland/golib/logger"
+
+	"github.com/tideland/gorest/rest"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// Authentication methods of a principal.
+const (
+	AuthenticationBasic  = "basic"
+	AuthenticationAPIKey = "api-key"
+	AuthenticationJWT    = "jwt"
+)
+
+//--------------------
+// CONTEXT
+//--------------------
+
+// key for the storage of values in a context.
+type key int
+
+const (
+	principalKey key = iota
+)
+
+// NewPrincipalContext returns a new context that carries a principal.
+func NewPrincipalContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// PrincipalFromContext returns the principal stored in ctx, if any.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey).(*Principal)
+	return principal, ok
+}
+
+//--------------------
+// PRINCIPAL
+//--------------------
+
+// Principal describes who has been authenticated by one of the
+// authentication handlers and how. Attributes can be set by
+// verifiers, the JWT authorization handler sets the claims.
+type Principal struct {
+	Subject    string
+	Method     string
+	Attributes map[string]interface{}
+}
+
+// CredentialVerifier checks user name and password and returns
+// the principal if they are valid.
+type CredentialVerifier interface {
+	VerifyCredentials(job rest.Job, username, password string) (*Principal, error)
+}
+
+// CredentialVerifierFunc allows to use a function as CredentialVerifier.
+type CredentialVerifierFunc func(job rest.Job, username, password string) (*Principal, error)
+
+// VerifyCredentials implements the CredentialVerifier interface.
+func (f CredentialVerifierFunc) VerifyCredentials(job rest.Job, username, password string) (*Principal, error) {
+	return f(job, username, password)
+}
+
+// APIKeyVerifier checks an API key and returns the principal
+// if it is valid.
+type APIKeyVerifier interface {
+	VerifyAPIKey(job rest.Job, apiKey string) (*Principal, error)
+}
+
+// APIKeyVerifierFunc allows to use a function as APIKeyVerifier.
+type APIKeyVerifierFunc func(job rest.Job, apiKey string) (*Principal, error)
+
+// VerifyAPIKey implements the APIKeyVerifier interface.
+func (f APIKeyVerifierFunc) VerifyAPIKey(job rest.Job, apiKey string) (*Principal, error) {
+	return f(job, apiKey)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// defaultDenyLogger is used by the authentication handlers if
+// no logger is configured.
+func defaultDenyLogger(job rest.Job, msg string) {
+	logger.Warningf("access denied for %v: %s", job, msg)
+}
+
+// authenticated stores the principal and its subject in the
+// job context.
+func authenticated(job rest.Job, principal *Principal, enhance func(ctx context.Context) context.Context) {
+	job.EnhanceContext(func(ctx context.Context) context.Context {
+		if principal.Subject != "" {
+			ctx = rest.NewSubjectContext(ctx, principal.Subject)
+		}
+		ctx = NewPrincipalContext(ctx, principal)
+		if enhance != nil {
+			ctx = enhance(ctx)
+		}
+		return ctx
+	})
+}
+
+// deny logs the denial and sends a negative feedback to the caller
+// in the accepted content type.
+func deny(job rest.Job, logger func(job rest.Job, msg string), statusCode int, msg string) (bool, error) {
+	if logger != nil {
+		logger(job, msg)
+	}
+	switch {
+	case job.AcceptsContentType(rest.ContentTypeJSON):
+		return rest.NegativeFeedback(job.JSON(false), statusCode, "%s", msg)
+	case job.AcceptsContentType(rest.ContentTypeXML):
+		return rest.NegativeFeedback(job.XML(), statusCode, "%s", msg)
+	default:
+		job.ResponseWriter().Header().Set("Content-Type", rest.ContentTypePlain)
+		job.ResponseWriter().WriteHeader(statusCode)
+		job.ResponseWriter().Write([]byte(msg))
+		return false, nil
+	}
+}
+
+// EOF
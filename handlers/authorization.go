@@ -0,0 +1,313 @@
+// Tideland GoREST - Handlers - Authorization
+//
+// Copyright (C) 2009-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package handlers
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/tideland/golib/logger"
+
+	"github.com/tideland/gorest/jwt"
+	"github.com/tideland/gorest/rest"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// Effects of authorization rules.
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+//--------------------
+// AUTHORIZATION RULE
+//--------------------
+
+// AuthorizationRule describes for which requests and which roles
+// and scopes access is allowed or denied. Empty domains, resources,
+// and methods as well as "*" match all. The resource ID is a pattern
+// like "users/*" as used by path.Match(), empty matches all. A rule
+// applies to a caller having any of the roles and all of the scopes,
+// empty roles and scopes apply to all callers.
+type AuthorizationRule struct {
+	Effect     string
+	Domain     string
+	Resource   string
+	Methods    []string
+	ResourceID string
+	Roles      []string
+	Scopes     []string
+}
+
+// matchesRequest checks if the rule is defined for the job.
+func (r *AuthorizationRule) matchesRequest(job rest.Job) bool {
+	if !matchesName(r.Domain, job.Path().Domain()) {
+		return false
+	}
+	if !matchesName(r.Resource, job.Path().Resource()) {
+		return false
+	}
+	if len(r.Methods) > 0 {
+		found := false
+		for _, method := range r.Methods {
+			if matchesName(method, job.Request().Method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.ResourceID != "" {
+		ok, err := path.Match(r.ResourceID, job.Path().JoinedResourceID())
+		if err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesCaller checks if the rule applies to the roles and scopes.
+func (r *AuthorizationRule) matchesCaller(roles, scopes []string) bool {
+	if len(r.Roles) > 0 {
+		found := false
+		for _, role := range r.Roles {
+			if contains(roles, role) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, scope := range r.Scopes {
+		if !contains(scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+//--------------------
+// AUTHORIZATION HANDLER
+//--------------------
+
+// AuthorizationConfig allows to control how the authorization handler
+// works. The roles are read from the claim "roles", the scopes from the
+// claim "scope" by default. Claims inside of objects can be addressed
+// with paths like "realm_access.roles". Values may be lists or space
+// separated strings. In dry-run mode denials are only logged. All
+// decisions are logged with the standard logger if no logger is set.
+type AuthorizationConfig struct {
+	RolesClaim  string
+	ScopesClaim string
+	Rules       []AuthorizationRule
+	DryRun      bool
+	Logger      func(job rest.Job, msg string)
+}
+
+// authorizationHandler checks the roles and scopes of the caller
+// against the rules.
+type authorizationHandler struct {
+	id          string
+	rolesClaim  string
+	scopesClaim string
+	rules       []AuthorizationRule
+	dryRun      bool
+	logger      func(job rest.Job, msg string)
+}
+
+// NewAuthorizationHandler creates a handler checking the roles and scopes
+// of the JSON Web Token stored in the job context, e.g. by the JWT
+// authorization handler, against the configured rules. Without a token
+// the claims of the principal are used. Matching deny rules win over
+// matching allow rules, requests without any matching allow rule are
+// denied.
+func NewAuthorizationHandler(id string, config *AuthorizationConfig) rest.ResourceHandler {
+	h := &authorizationHandler{
+		id:          id,
+		rolesClaim:  "roles",
+		scopesClaim: "scope",
+		logger: func(job rest.Job, msg string) {
+			logger.Infof("authorization of %v: %s", job, msg)
+		},
+	}
+	if config != nil {
+		if config.RolesClaim != "" {
+			h.rolesClaim = config.RolesClaim
+		}
+		if config.ScopesClaim != "" {
+			h.scopesClaim = config.ScopesClaim
+		}
+		h.rules = config.Rules
+		h.dryRun = config.DryRun
+		if config.Logger != nil {
+			h.logger = config.Logger
+		}
+	}
+	return h
+}
+
+// ID is specified on the ResourceHandler interface.
+func (h *authorizationHandler) ID() string {
+	return h.id
+}
+
+// Init is specified on the ResourceHandler interface.
+func (h *authorizationHandler) Init(env rest.Environment, domain, resource string) error {
+	return nil
+}
+
+// Get is specified on the GetResourceHandler interface.
+func (h *authorizationHandler) Get(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Head is specified on the HeadResourceHandler interface.
+func (h *authorizationHandler) Head(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Put is specified on the PutResourceHandler interface.
+func (h *authorizationHandler) Put(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Post is specified on the PostResourceHandler interface.
+func (h *authorizationHandler) Post(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Patch is specified on the PatchResourceHandler interface.
+func (h *authorizationHandler) Patch(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Delete is specified on the DeleteResourceHandler interface.
+func (h *authorizationHandler) Delete(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Options is specified on the OptionsResourceHandler interface.
+func (h *authorizationHandler) Options(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// check is used by all methods to check the rules.
+func (h *authorizationHandler) check(job rest.Job) (bool, error) {
+	claims, ok := h.claims(job)
+	if !ok {
+		return h.deny(job, rest.StatusUnauthorized, "no claims to authorize")
+	}
+	roles := claimValues(claims, h.rolesClaim)
+	scopes := claimValues(claims, h.scopesClaim)
+	allowedBy := -1
+	for i, rule := range h.rules {
+		if !rule.matchesRequest(job) || !rule.matchesCaller(roles, scopes) {
+			continue
+		}
+		if rule.Effect == EffectDeny {
+			return h.deny(job, rest.StatusForbidden, fmt.Sprintf("denied by rule #%d", i))
+		}
+		if allowedBy < 0 {
+			allowedBy = i
+		}
+	}
+	if allowedBy < 0 {
+		return h.deny(job, rest.StatusForbidden, "no rule allows access")
+	}
+	h.logger(job, fmt.Sprintf("allowed by rule #%d", allowedBy))
+	return true, nil
+}
+
+// claims returns the claims of the token or principal.
+func (h *authorizationHandler) claims(job rest.Job) (map[string]interface{}, bool) {
+	if token, ok := jwt.FromContext(job.Context()); ok {
+		return token.Claims(), true
+	}
+	if principal, ok := PrincipalFromContext(job.Context()); ok && principal.Attributes != nil {
+		return principal.Attributes, true
+	}
+	return nil, false
+}
+
+// deny sends a negative feedback to the caller. In dry-run
+// mode it only logs the denial.
+func (h *authorizationHandler) deny(job rest.Job, statusCode int, msg string) (bool, error) {
+	if h.dryRun {
+		h.logger(job, "dry-run, would be "+msg)
+		return true, nil
+	}
+	return deny(job, h.logger, statusCode, msg)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// claimValues returns the values of the claim addressed by the path.
+// A key containing dots is tried as a whole first.
+func claimValues(claims map[string]interface{}, claimPath string) []string {
+	value, ok := claims[claimPath]
+	if !ok {
+		var current interface{} = claims
+		for _, part := range strings.Split(claimPath, ".") {
+			object, ok := current.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			if current, ok = object[part]; !ok {
+				return nil
+			}
+		}
+		value = current
+	}
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []string:
+		return v
+	case []interface{}:
+		var values []string
+		for _, iv := range v {
+			if s, ok := iv.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// matchesName checks if the name matches the pattern, where
+// an empty pattern or "*" match all.
+func matchesName(pattern, name string) bool {
+	return pattern == "" || pattern == "*" || strings.EqualFold(pattern, name)
+}
+
+// contains checks if the list contains the value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// EOF
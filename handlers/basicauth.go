@@ -0,0 +1,155 @@
+// Tideland GoREST - Handlers - Basic Authentication
+//
+// Copyright (C) 2009-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package handlers
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"strconv"
+
+	"github.com/tideland/gorest/rest"
+)
+
+//--------------------
+// BASIC AUTHENTICATION HANDLER
+//--------------------
+
+// BasicAuthConfig allows to control how the basic authentication
+// handler works. The verifier is needed, without it all requests
+// are denied. The realm defaults to "Tideland GoREST". The gatekeeper
+// is optional and in case of a denial a warning is written with the
+// standard logger.
+type BasicAuthConfig struct {
+	Realm      string
+	Verifier   CredentialVerifier
+	Gatekeeper func(job rest.Job, principal *Principal) error
+	Logger     func(job rest.Job, msg string)
+}
+
+// basicAuthHandler checks the credentials of the HTTP basic
+// authentication and then runs a gatekeeper function. If everything
+// is fine the principal is stored in the job context for the
+// following handlers.
+type basicAuthHandler struct {
+	id         string
+	realm      string
+	verifier   CredentialVerifier
+	gatekeeper func(job rest.Job, principal *Principal) error
+	logger     func(job rest.Job, msg string)
+}
+
+// NewBasicAuthHandler creates a handler checking the credentials
+// of the HTTP basic authentication in each request.
+func NewBasicAuthHandler(id string, config *BasicAuthConfig) rest.ResourceHandler {
+	h := &basicAuthHandler{
+		id:     id,
+		realm:  "Tideland GoREST",
+		logger: defaultDenyLogger,
+	}
+	if config != nil {
+		if config.Realm != "" {
+			h.realm = config.Realm
+		}
+		if config.Verifier != nil {
+			h.verifier = config.Verifier
+		}
+		if config.Gatekeeper != nil {
+			h.gatekeeper = config.Gatekeeper
+		}
+		if config.Logger != nil {
+			h.logger = config.Logger
+		}
+	}
+	return h
+}
+
+// ID is specified on the ResourceHandler interface.
+func (h *basicAuthHandler) ID() string {
+	return h.id
+}
+
+// Init is specified on the ResourceHandler interface.
+func (h *basicAuthHandler) Init(env rest.Environment, domain, resource string) error {
+	return nil
+}
+
+// Get is specified on the GetResourceHandler interface.
+func (h *basicAuthHandler) Get(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Head is specified on the HeadResourceHandler interface.
+func (h *basicAuthHandler) Head(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Put is specified on the PutResourceHandler interface.
+func (h *basicAuthHandler) Put(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Post is specified on the PostResourceHandler interface.
+func (h *basicAuthHandler) Post(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Patch is specified on the PatchResourceHandler interface.
+func (h *basicAuthHandler) Patch(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Delete is specified on the DeleteResourceHandler interface.
+func (h *basicAuthHandler) Delete(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Options is specified on the OptionsResourceHandler interface.
+func (h *basicAuthHandler) Options(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// check is used by all methods to check the credentials.
+func (h *basicAuthHandler) check(job rest.Job) (bool, error) {
+	username, password, ok := job.Request().BasicAuth()
+	if !ok {
+		return h.deny(job, "no basic authentication credentials")
+	}
+	if h.verifier == nil {
+		return h.deny(job, "no credential verifier")
+	}
+	principal, err := h.verifier.VerifyCredentials(job, username, password)
+	if err != nil {
+		return h.deny(job, "invalid credentials: "+err.Error())
+	}
+	if principal == nil {
+		return h.deny(job, "invalid credentials")
+	}
+	if principal.Subject == "" {
+		principal.Subject = username
+	}
+	principal.Method = AuthenticationBasic
+	if h.gatekeeper != nil {
+		err := h.gatekeeper(job, principal)
+		if err != nil {
+			return h.deny(job, "access rejected by gatekeeper: "+err.Error())
+		}
+	}
+	// All fine, store principal in context.
+	authenticated(job, principal, nil)
+	return true, nil
+}
+
+// deny asks the caller for authentication and sends a negative feedback.
+func (h *basicAuthHandler) deny(job rest.Job, msg string) (bool, error) {
+	job.ResponseWriter().Header().Set("WWW-Authenticate", "Basic realm="+strconv.Quote(h.realm))
+	return deny(job, h.logger, rest.StatusUnauthorized, msg)
+}
+
+// EOF
@@ -23,11 +23,13 @@ import (
 const (
 	ErrUploadingFile = iota + 1
 	ErrDownloadingFile
+	ErrInvalidJWTConfig
 )
 
 var errorMessages = errors.Messages{
-	ErrUploadingFile:   "uploaded file cannot be handled by '%s'",
-	ErrDownloadingFile: "file '%s' cannot be downloaded",
+	ErrUploadingFile:    "uploaded file cannot be handled by '%s'",
+	ErrDownloadingFile:  "file '%s' cannot be downloaded",
+	ErrInvalidJWTConfig: "invalid JWT authorization configuration: %s",
 }
 
 // EOF
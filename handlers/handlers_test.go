@@ -14,12 +14,20 @@ package handlers_test
 import (
 	"bufio"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
 	"io/ioutil"
+	"math/big"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
@@ -117,9 +125,22 @@ func TestFileUploadHandler(t *testing.T) {
 func TestJWTAuthorizationHandler(t *testing.T) {
 	assert := audit.NewTestingAssertion(t, true)
 	key := []byte("secret")
+	encKey := []byte("0123456789abcdef0123456789abcdef")
+	revocations := jwt.NewMemoryRevocations()
+	err := revocations.RevokeIdentifier("revoked", time.Time{})
+	assert.Nil(err)
+	root, rootKey := newCertificate(assert, "Root CA", nil, nil)
+	leaf, leafKey := newCertificate(assert, "Signer", root, rootKey)
+	otherRoot, _ := newCertificate(assert, "Other CA", nil, nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(otherRoot)
 	tests := []struct {
 		id      string
+		method  string
 		tokener func() jwt.JWT
+		adder   func(req *http.Request, token jwt.JWT) *http.Request
 		config  *handlers.JWTAuthorizationConfig
 		runs    int
 		status  int
@@ -128,6 +149,7 @@ func TestJWTAuthorizationHandler(t *testing.T) {
 	}{
 		{
 			id:     "no-token",
+			config: &handlers.JWTAuthorizationConfig{Key: key},
 			status: 401,
 		}, {
 			id: "token-decode-no-gatekeeper",
@@ -138,6 +160,7 @@ func TestJWTAuthorizationHandler(t *testing.T) {
 				assert.Nil(err)
 				return out
 			},
+			config: &handlers.JWTAuthorizationConfig{AllowUnsigned: true},
 			status: 200,
 			auditf: func(assert audit.Assertion, job rest.Job) (bool, error) {
 				token, ok := jwt.FromContext(job.Context())
@@ -146,8 +169,26 @@ func TestJWTAuthorizationHandler(t *testing.T) {
 				subject, ok := token.Claims().Subject()
 				assert.True(ok)
 				assert.Equal(subject, "test")
+				subject, ok = rest.SubjectFromContext(job.Context())
+				assert.True(ok)
+				assert.Equal(subject, "test")
+				principal, ok := handlers.PrincipalFromContext(job.Context())
+				assert.True(ok)
+				assert.Equal(principal.Subject, "test")
+				assert.Equal(principal.Method, handlers.AuthenticationJWT)
 				return true, nil
 			},
+		}, {
+			id: "token-decode-none-rejected",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				out, err := jwt.Encode(claims, "", jwt.NONE)
+				assert.Nil(err)
+				return out
+			},
+			config: &handlers.JWTAuthorizationConfig{AllowUnsigned: true},
+			status: 401,
 		}, {
 			id: "token-verify-no-gatekeeper",
 			tokener: func() jwt.JWT {
@@ -161,6 +202,222 @@ func TestJWTAuthorizationHandler(t *testing.T) {
 				Key: key,
 			},
 			status: 200,
+		}, {
+			id: "token-verify-key-resolver",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				out, err := jwt.EncodeWithKeyID(claims, key, jwt.HS512, "key-1")
+				assert.Nil(err)
+				return out
+			},
+			config: &handlers.JWTAuthorizationConfig{
+				Key: []byte("wrong"),
+				KeyResolver: &jwt.JWKS{
+					Keys: []*jwt.JWK{{KeyType: jwt.KeyTypeOct, KeyID: "key-1", K: "c2VjcmV0"}},
+				},
+			},
+			status: 200,
+		}, {
+			id: "token-verify-key-resolver-unknown-key",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				out, err := jwt.EncodeWithKeyID(claims, key, jwt.HS512, "key-2")
+				assert.Nil(err)
+				return out
+			},
+			config: &handlers.JWTAuthorizationConfig{
+				KeyResolver: &jwt.JWKS{
+					Keys: []*jwt.JWK{{KeyType: jwt.KeyTypeOct, KeyID: "key-1", K: "c2VjcmV0"}},
+				},
+			},
+			status: 401,
+		}, {
+			id: "token-verify-none-rejected",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				out, err := jwt.Encode(claims, "", jwt.NONE)
+				assert.Nil(err)
+				return out
+			},
+			config: &handlers.JWTAuthorizationConfig{
+				Key: "",
+			},
+			status: 401,
+		}, {
+			id: "token-verify-algorithm-not-allowed",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				out, err := jwt.Encode(claims, key, jwt.HS512)
+				assert.Nil(err)
+				return out
+			},
+			config: &handlers.JWTAuthorizationConfig{
+				Key:        key,
+				Algorithms: []jwt.Algorithm{jwt.HS256},
+			},
+			status: 401,
+		}, {
+			id: "encrypted-token-decrypt",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				out, err := jwt.EncodeEncrypted(claims, encKey, jwt.A256KW, jwt.A256GCM)
+				assert.Nil(err)
+				return out
+			},
+			config: &handlers.JWTAuthorizationConfig{
+				DecryptionKey: encKey,
+				AllowUnsigned: true,
+			},
+			status: 200,
+		}, {
+			id: "encrypted-token-verify-nested",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				signed, err := jwt.Encode(claims, key, jwt.HS512)
+				assert.Nil(err)
+				out, err := jwt.EncodeNested(signed, encKey, jwt.A256KW, jwt.A256GCM)
+				assert.Nil(err)
+				return out
+			},
+			config: &handlers.JWTAuthorizationConfig{
+				Key:           key,
+				DecryptionKey: encKey,
+			},
+			status: 200,
+		}, {
+			id: "encrypted-token-verify-not-nested",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				out, err := jwt.EncodeEncrypted(claims, encKey, jwt.A256KW, jwt.A256GCM)
+				assert.Nil(err)
+				return out
+			},
+			config: &handlers.JWTAuthorizationConfig{
+				Key:           key,
+				DecryptionKey: encKey,
+			},
+			status: 401,
+		}, {
+			id: "token-source-cookie",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				out, err := jwt.Encode(claims, key, jwt.HS512)
+				assert.Nil(err)
+				return out
+			},
+			adder: func(req *http.Request, token jwt.JWT) *http.Request {
+				return jwt.AddToRequestWith(req, token, jwt.CookieSource{Name: "token"})
+			},
+			config: &handlers.JWTAuthorizationConfig{
+				Key:          key,
+				TokenSources: []jwt.TokenSource{jwt.HeaderSource{}, jwt.CookieSource{Name: "token"}},
+			},
+			status: 200,
+		}, {
+			id:     "token-source-cookie-csrf",
+			method: "POST",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				out, err := jwt.Encode(claims, key, jwt.HS512)
+				assert.Nil(err)
+				return out
+			},
+			adder: func(req *http.Request, token jwt.JWT) *http.Request {
+				return jwt.AddToRequestWith(req, token, jwt.CookieSource{Name: "token"})
+			},
+			config: &handlers.JWTAuthorizationConfig{
+				Key:          key,
+				TokenSources: []jwt.TokenSource{jwt.CookieSource{Name: "token"}},
+			},
+			status: 200,
+		}, {
+			id:     "token-source-cookie-no-csrf",
+			method: "POST",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				out, err := jwt.Encode(claims, key, jwt.HS512)
+				assert.Nil(err)
+				return out
+			},
+			adder: func(req *http.Request, token jwt.JWT) *http.Request {
+				req.AddCookie(&http.Cookie{Name: "token", Value: token.String()})
+				return req
+			},
+			config: &handlers.JWTAuthorizationConfig{
+				Key:          key,
+				TokenSources: []jwt.TokenSource{jwt.CookieSource{Name: "token"}},
+			},
+			status: 401,
+		}, {
+			id: "token-source-query",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				out, err := jwt.Encode(claims, key, jwt.HS512)
+				assert.Nil(err)
+				return out
+			},
+			adder: func(req *http.Request, token jwt.JWT) *http.Request {
+				return jwt.AddToRequestWith(req, token, jwt.QuerySource{Name: "access_token"})
+			},
+			config: &handlers.JWTAuthorizationConfig{
+				Key:          key,
+				TokenSources: []jwt.TokenSource{jwt.HeaderSource{}, jwt.QuerySource{Name: "access_token"}},
+			},
+			status: 200,
+		}, {
+			id: "token-source-missing",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				out, err := jwt.Encode(claims, key, jwt.HS512)
+				assert.Nil(err)
+				return out
+			},
+			adder: func(req *http.Request, token jwt.JWT) *http.Request {
+				return jwt.AddToRequestWith(req, token, jwt.QuerySource{Name: "other"})
+			},
+			config: &handlers.JWTAuthorizationConfig{
+				Key:          key,
+				TokenSources: []jwt.TokenSource{jwt.QuerySource{Name: "access_token"}},
+			},
+			status: 401,
+		}, {
+			id: "certificate-chain",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				out, err := jwt.EncodeWithCertificates(claims, leafKey, jwt.ES256, []*x509.Certificate{leaf})
+				assert.Nil(err)
+				return out
+			},
+			config: &handlers.JWTAuthorizationConfig{
+				Certificates: &jwt.CertificatePolicy{Roots: roots},
+			},
+			status: 200,
+		}, {
+			id: "certificate-chain-untrusted",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				out, err := jwt.EncodeWithCertificates(claims, leafKey, jwt.ES256, []*x509.Certificate{leaf})
+				assert.Nil(err)
+				return out
+			},
+			config: &handlers.JWTAuthorizationConfig{
+				Certificates: &jwt.CertificatePolicy{Roots: otherRoots},
+			},
+			status: 401,
 		}, {
 			id: "cached-token-verify-no-gatekeeper",
 			tokener: func() jwt.JWT {
@@ -227,7 +484,78 @@ func TestJWTAuthorizationHandler(t *testing.T) {
 				assert.Nil(err)
 				return out
 			},
+			config: &handlers.JWTAuthorizationConfig{Key: key},
+			status: 403,
+		}, {
+			id: "token-validation-policy",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				claims.SetIssuer("issuer")
+				claims.SetAudience("service")
+				out, err := jwt.Encode(claims, key, jwt.HS512)
+				assert.Nil(err)
+				return out
+			},
+			config: &handlers.JWTAuthorizationConfig{
+				Key: key,
+				Validation: &jwt.ValidationPolicy{
+					Issuers:   []string{"issuer"},
+					Audiences: []string{"service"},
+				},
+			},
+			status: 200,
+		}, {
+			id: "token-validation-policy-wrong-audience",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				claims.SetIssuer("issuer")
+				claims.SetAudience("other")
+				out, err := jwt.Encode(claims, key, jwt.HS512)
+				assert.Nil(err)
+				return out
+			},
+			config: &handlers.JWTAuthorizationConfig{
+				Key: key,
+				Validation: &jwt.ValidationPolicy{
+					Issuers:   []string{"issuer"},
+					Audiences: []string{"service"},
+				},
+			},
 			status: 403,
+		}, {
+			id: "token-revoked",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				claims.SetIdentifier("revoked")
+				out, err := jwt.Encode(claims, key, jwt.HS512)
+				assert.Nil(err)
+				return out
+			},
+			config: &handlers.JWTAuthorizationConfig{
+				Key:         key,
+				Revocations: revocations,
+			},
+			status: 401,
+		}, {
+			id: "token-not-revoked",
+			tokener: func() jwt.JWT {
+				claims := jwt.NewClaims()
+				claims.SetSubject("test")
+				claims.SetIdentifier("not-revoked")
+				out, err := jwt.Encode(claims, key, jwt.HS512)
+				assert.Nil(err)
+				return out
+			},
+			config: &handlers.JWTAuthorizationConfig{
+				Cache:       jwt.NewRevocationCheckingCache(time.Minute, time.Minute, time.Minute, 10, revocations),
+				Key:         key,
+				Revocations: revocations,
+			},
+			runs:   2,
+			status: 200,
 		},
 	}
 	// Run defined tests.
@@ -244,10 +572,18 @@ func TestJWTAuthorizationHandler(t *testing.T) {
 			assert.Nil(err)
 		}
 		// Create request.
-		req := restaudit.NewRequest("GET", "/jwt/"+test.id+"/1234567890")
+		method := "GET"
+		if test.method != "" {
+			method = test.method
+		}
+		req := restaudit.NewRequest(method, "/jwt/"+test.id+"/1234567890")
 		if test.tokener != nil {
+			adder := jwt.AddToRequest
+			if test.adder != nil {
+				adder = test.adder
+			}
 			req.SetRequestProcessor(func(req *http.Request) *http.Request {
-				return jwt.AddToRequest(req, test.tokener())
+				return adder(req, test.tokener())
 			})
 		}
 		// Make request(s).
@@ -260,6 +596,413 @@ func TestJWTAuthorizationHandler(t *testing.T) {
 			resp.AssertStatusEquals(test.status)
 		}
 	}
+	// Algorithms need something to verify with.
+	err = mux.Register("jwt", "algorithms-without-key", handlers.NewJWTAuthorizationHandler("algorithms-without-key", &handlers.JWTAuthorizationConfig{
+		Algorithms: []jwt.Algorithm{jwt.HS512},
+	}))
+	assert.ErrorMatch(err, ".*algorithms need a key, key resolver, or certificates.*")
+	// Unsigned tokens need an explicit permission, encrypted ones
+	// never with a public encryption key.
+	err = mux.Register("jwt", "decode-without-permission", handlers.NewJWTAuthorizationHandler("decode-without-permission", nil))
+	assert.ErrorMatch(err, ".*a key, key resolver, or certificates are needed unless unsigned tokens are allowed.*")
+	err = mux.Register("jwt", "decryption-without-key", handlers.NewJWTAuthorizationHandler("decryption-without-key", &handlers.JWTAuthorizationConfig{
+		DecryptionKey: encKey,
+	}))
+	assert.ErrorMatch(err, ".*a key, key resolver, or certificates are needed unless unsigned tokens are allowed.*")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+	err = mux.Register("jwt", "decryption-with-public-key", handlers.NewJWTAuthorizationHandler("decryption-with-public-key", &handlers.JWTAuthorizationConfig{
+		DecryptionKey: rsaKey,
+		AllowUnsigned: true,
+	}))
+	assert.ErrorMatch(err, ".*unsigned tokens encrypted with a public key are not allowed.*")
+}
+
+// TestBasicAuthHandler tests the authentication with user name
+// and password.
+func TestBasicAuthHandler(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	verifier := handlers.CredentialVerifierFunc(func(job rest.Job, username, password string) (*handlers.Principal, error) {
+		if username != "alice" || password != "secret" {
+			return nil, errors.New("wrong user name or password")
+		}
+		return &handlers.Principal{
+			Attributes: map[string]interface{}{"team": "ops"},
+		}, nil
+	})
+	auditf := func(assert audit.Assertion, job rest.Job) (bool, error) {
+		principal, ok := handlers.PrincipalFromContext(job.Context())
+		assert.True(ok)
+		assert.Equal(principal.Subject, "alice")
+		assert.Equal(principal.Method, handlers.AuthenticationBasic)
+		assert.Equal(principal.Attributes["team"], "ops")
+		subject, ok := rest.SubjectFromContext(job.Context())
+		assert.True(ok)
+		assert.Equal(subject, "alice")
+		return true, nil
+	}
+	// Setup the test server.
+	mux := newMultiplexer(assert)
+	ts := restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	err := mux.RegisterAll(rest.Registrations{
+		{Domain: "basic", Resource: "open", Handler: handlers.NewBasicAuthHandler("basic", &handlers.BasicAuthConfig{
+			Realm:    "test",
+			Verifier: verifier,
+		})},
+		{Domain: "basic", Resource: "open", Handler: handlers.NewAuditHandler("audit", assert, auditf)},
+		{Domain: "basic", Resource: "closed", Handler: handlers.NewBasicAuthHandler("basic", &handlers.BasicAuthConfig{
+			Verifier: verifier,
+			Gatekeeper: func(job rest.Job, principal *handlers.Principal) error {
+				return errors.New("closed")
+			},
+		})},
+		{Domain: "basic", Resource: "none", Handler: handlers.NewBasicAuthHandler("basic", nil)},
+	})
+	assert.Nil(err)
+	tests := []struct {
+		resource string
+		username string
+		password string
+		status   int
+	}{
+		{"open", "", "", 401},
+		{"open", "alice", "wrong", 401},
+		{"open", "alice", "secret", 200},
+		{"closed", "alice", "secret", 401},
+		{"none", "alice", "secret", 401},
+	}
+	for i, test := range tests {
+		assert.Logf("basic auth test #%d: %s %s", i, test.resource, test.username)
+		req := restaudit.NewRequest("GET", "/basic/"+test.resource)
+		req.AddHeader(restaudit.HeaderAccept, restaudit.ApplicationJSON)
+		if test.username != "" {
+			username, password := test.username, test.password
+			req.SetRequestProcessor(func(req *http.Request) *http.Request {
+				req.SetBasicAuth(username, password)
+				return req
+			})
+		}
+		resp := ts.DoRequest(req)
+		resp.AssertStatusEquals(test.status)
+		if test.status == 401 {
+			fb := resp.AssertUnmarshalledFeedback()
+			assert.Equal(fb.StatusCode, 401)
+			resp.AssertHeader("Www-Authenticate")
+		}
+	}
+	req := restaudit.NewRequest("GET", "/basic/open")
+	resp := ts.DoRequest(req)
+	resp.AssertHeaderEquals("Www-Authenticate", `Basic realm="test"`)
+}
+
+// TestAPIKeyAuthHandler tests the authentication with API keys
+// in headers or query parameters.
+func TestAPIKeyAuthHandler(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	verifier := handlers.APIKeyVerifierFunc(func(job rest.Job, apiKey string) (*handlers.Principal, error) {
+		if apiKey != "12345" {
+			return nil, errors.New("unknown")
+		}
+		return &handlers.Principal{
+			Subject: "webhook",
+		}, nil
+	})
+	auditf := func(assert audit.Assertion, job rest.Job) (bool, error) {
+		principal, ok := handlers.PrincipalFromContext(job.Context())
+		assert.True(ok)
+		assert.Equal(principal.Subject, "webhook")
+		assert.Equal(principal.Method, handlers.AuthenticationAPIKey)
+		return true, nil
+	}
+	// Setup the test server.
+	mux := newMultiplexer(assert)
+	ts := restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	err := mux.RegisterAll(rest.Registrations{
+		{Domain: "apikey", Resource: "header", Handler: handlers.NewAPIKeyAuthHandler("apikey", &handlers.APIKeyAuthConfig{
+			Verifier: verifier,
+		})},
+		{Domain: "apikey", Resource: "header", Handler: handlers.NewAuditHandler("audit", assert, auditf)},
+		{Domain: "apikey", Resource: "param", Handler: handlers.NewAPIKeyAuthHandler("apikey", &handlers.APIKeyAuthConfig{
+			Header:    "X-Hook-Key",
+			Parameter: "key",
+			Verifier:  verifier,
+		})},
+		{Domain: "apikey", Resource: "param", Handler: handlers.NewAuditHandler("audit", assert, auditf)},
+	})
+	assert.Nil(err)
+	tests := []struct {
+		path   string
+		header string
+		key    string
+		status int
+	}{
+		{"/apikey/header", "", "", 401},
+		{"/apikey/header", "X-API-Key", "wrong", 401},
+		{"/apikey/header", "X-API-Key", "12345", 200},
+		{"/apikey/header?key=12345", "", "", 401},
+		{"/apikey/param", "X-Hook-Key", "12345", 200},
+		{"/apikey/param?key=12345", "", "", 200},
+		{"/apikey/param?key=wrong", "", "", 401},
+	}
+	for i, test := range tests {
+		assert.Logf("API key test #%d: %s %s", i, test.path, test.header)
+		req := restaudit.NewRequest("GET", test.path)
+		if test.header != "" {
+			req.AddHeader(test.header, test.key)
+		}
+		resp := ts.DoRequest(req)
+		resp.AssertStatusEquals(test.status)
+	}
+}
+
+// TestAuthorizationHandler tests the authorization based on roles
+// and scopes in the claims.
+func TestAuthorizationHandler(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	key := []byte("secret")
+	rules := []handlers.AuthorizationRule{
+		{
+			Effect:  handlers.EffectAllow,
+			Methods: []string{"GET"},
+			Roles:   []string{"reader", "writer"},
+		}, {
+			Effect:  handlers.EffectAllow,
+			Methods: []string{"PUT", "DELETE"},
+			Roles:   []string{"writer"},
+			Scopes:  []string{"docs:write"},
+		}, {
+			Effect:     handlers.EffectDeny,
+			Methods:    []string{"DELETE"},
+			ResourceID: "locked*",
+		},
+	}
+	var decisions []string
+	// Setup the test server.
+	mux := newMultiplexer(assert)
+	ts := restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	err := mux.RegisterAll(rest.Registrations{
+		{Domain: "authz", Resource: "docs", Handler: handlers.NewJWTAuthorizationHandler("jwt", &handlers.JWTAuthorizationConfig{AllowUnsigned: true})},
+		{Domain: "authz", Resource: "docs", Handler: handlers.NewAuthorizationHandler("authz", &handlers.AuthorizationConfig{
+			Rules: rules,
+		})},
+		{Domain: "authz", Resource: "nested", Handler: handlers.NewJWTAuthorizationHandler("jwt", &handlers.JWTAuthorizationConfig{AllowUnsigned: true})},
+		{Domain: "authz", Resource: "nested", Handler: handlers.NewAuthorizationHandler("authz", &handlers.AuthorizationConfig{
+			RolesClaim: "realm_access.roles",
+			Rules:      rules,
+		})},
+		{Domain: "authz", Resource: "dryrun", Handler: handlers.NewJWTAuthorizationHandler("jwt", &handlers.JWTAuthorizationConfig{AllowUnsigned: true})},
+		{Domain: "authz", Resource: "dryrun", Handler: handlers.NewAuthorizationHandler("authz", &handlers.AuthorizationConfig{
+			Rules:  rules,
+			DryRun: true,
+			Logger: func(job rest.Job, msg string) {
+				decisions = append(decisions, msg)
+			},
+		})},
+		{Domain: "authz", Resource: "notoken", Handler: handlers.NewAuthorizationHandler("authz", nil)},
+	})
+	assert.Nil(err)
+	tests := []struct {
+		method string
+		path   string
+		claims map[string]interface{}
+		status int
+	}{
+		{"GET", "/authz/notoken", nil, 401},
+		{"GET", "/authz/docs/1", map[string]interface{}{"roles": []string{"reader"}}, 200},
+		{"GET", "/authz/docs/1", map[string]interface{}{"roles": []string{"guest"}}, 403},
+		{"PUT", "/authz/docs/1", map[string]interface{}{"roles": []string{"reader"}}, 403},
+		{"PUT", "/authz/docs/1", map[string]interface{}{"roles": []string{"writer"}}, 403},
+		{"PUT", "/authz/docs/1", map[string]interface{}{"roles": []string{"writer"}, "scope": "docs:read docs:write"}, 200},
+		{"DELETE", "/authz/docs/1", map[string]interface{}{"roles": []string{"writer"}, "scope": "docs:write"}, 200},
+		{"DELETE", "/authz/docs/locked", map[string]interface{}{"roles": []string{"writer"}, "scope": "docs:write"}, 403},
+		{"GET", "/authz/nested/1", map[string]interface{}{"realm_access": map[string]interface{}{"roles": []string{"reader"}}}, 200},
+		{"GET", "/authz/nested/1", map[string]interface{}{"roles": []string{"reader"}}, 403},
+		{"GET", "/authz/dryrun/1", map[string]interface{}{"roles": []string{"guest"}}, 200},
+	}
+	for i, test := range tests {
+		assert.Logf("authorization test #%d: %s %s", i, test.method, test.path)
+		req := restaudit.NewRequest(test.method, test.path)
+		if test.claims != nil {
+			claims := jwt.NewClaims()
+			for k, v := range test.claims {
+				claims.Set(k, v)
+			}
+			token, err := jwt.Encode(claims, key, jwt.HS512)
+			assert.Nil(err)
+			req.SetRequestProcessor(func(req *http.Request) *http.Request {
+				return jwt.AddToRequest(req, token)
+			})
+		}
+		resp := ts.DoRequest(req)
+		resp.AssertStatusEquals(test.status)
+	}
+	assert.Equal(decisions, []string{"dry-run, would be no rule allows access"})
+}
+
+// TestLoginHandler tests the issuing of tokens.
+func TestLoginHandler(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	key := []byte("secret")
+	verifier := handlers.CredentialVerifierFunc(func(job rest.Job, username, password string) (*handlers.Principal, error) {
+		if username != "alice" || password != "secret" {
+			return nil, errors.New("wrong user name or password")
+		}
+		return &handlers.Principal{
+			Attributes: map[string]interface{}{"role": "admin"},
+		}, nil
+	})
+	// Setup the test server.
+	mux := newMultiplexer(assert)
+	ts := restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	err := mux.Register("auth", "login", handlers.NewLoginHandler("login", &handlers.LoginConfig{
+		Verifier:   verifier,
+		Key:        key,
+		Issuer:     "test",
+		Audience:   []string{"api"},
+		Expiration: 5 * time.Minute,
+		Claims: func(job rest.Job, principal *handlers.Principal) (jwt.Claims, error) {
+			claims := jwt.NewClaims()
+			claims.Set("role", principal.Attributes["role"])
+			return claims, nil
+		},
+		RefreshStore: handlers.NewMemoryRefreshTokenStore(),
+	}))
+	assert.Nil(err)
+	login := func(lr handlers.LoginRequest, status int) handlers.LoginResponse {
+		req := restaudit.NewRequest("POST", "/auth/login")
+		req.MarshalBody(assert, restaudit.ApplicationJSON, lr)
+		resp := ts.DoRequest(req)
+		resp.AssertStatusEquals(status)
+		var out handlers.LoginResponse
+		if status == 200 {
+			resp.AssertHeaderEquals("Cache-Control", "no-store")
+			resp.AssertUnmarshalledBody(&out)
+		}
+		return out
+	}
+	// Invalid requests.
+	login(handlers.LoginRequest{GrantType: "magic"}, 400)
+	login(handlers.LoginRequest{GrantType: handlers.GrantPassword}, 400)
+	login(handlers.LoginRequest{GrantType: handlers.GrantPassword, Username: "alice", Password: "wrong"}, 401)
+	login(handlers.LoginRequest{GrantType: handlers.GrantRefreshToken, RefreshToken: "unknown"}, 401)
+	// Login with password.
+	out := login(handlers.LoginRequest{GrantType: handlers.GrantPassword, Username: "alice", Password: "secret"}, 200)
+	assert.Equal(out.TokenType, "Bearer")
+	assert.Equal(out.ExpiresIn, 300)
+	assert.NotEmpty(out.RefreshToken)
+	token, err := jwt.Verify(out.AccessToken, key)
+	assert.Nil(err)
+	subject, _ := token.Claims().Subject()
+	assert.Equal(subject, "alice")
+	issuer, _ := token.Claims().Issuer()
+	assert.Equal(issuer, "test")
+	audience, _ := token.Claims().Audience()
+	assert.Equal(audience, []string{"api"})
+	role, _ := token.Claims().GetString("role")
+	assert.Equal(role, "admin")
+	jti, ok := token.Claims().Identifier()
+	assert.True(ok)
+	assert.NotEmpty(jti)
+	assert.True(token.IsValid(time.Second))
+	// Refresh with form values, rotation prevents reuse.
+	req := restaudit.NewRequest("POST", "/auth/login")
+	req.AddHeader(restaudit.HeaderContentType, "application/x-www-form-urlencoded")
+	req.AddHeader(restaudit.HeaderAccept, restaudit.ApplicationJSON)
+	req.Body = []byte("grant_type=refresh_token&refresh_token=" + out.RefreshToken)
+	resp := ts.DoRequest(req)
+	resp.AssertStatusEquals(200)
+	var refreshed handlers.LoginResponse
+	resp.AssertUnmarshalledBody(&refreshed)
+	assert.Different(refreshed.RefreshToken, out.RefreshToken)
+	token, err = jwt.Verify(refreshed.AccessToken, key)
+	assert.Nil(err)
+	role, _ = token.Claims().GetString("role")
+	assert.Equal(role, "admin")
+	refreshedJTI, _ := token.Claims().Identifier()
+	assert.Different(refreshedJTI, jti)
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(401)
+	// Revoke the refresh token.
+	req = restaudit.NewRequest("DELETE", "/auth/login")
+	req.MarshalBody(assert, restaudit.ApplicationJSON, handlers.LoginRequest{RefreshToken: refreshed.RefreshToken})
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(204)
+	login(handlers.LoginRequest{GrantType: handlers.GrantRefreshToken, RefreshToken: refreshed.RefreshToken}, 401)
+}
+
+// TestRateLimitHandler tests the limiting of requests per client.
+func TestRateLimitHandler(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	ok := func(assert audit.Assertion, job rest.Job) (bool, error) {
+		return true, nil
+	}
+	// Setup the test server.
+	mux := newMultiplexer(assert)
+	ts := restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	err := mux.RegisterAll(rest.Registrations{
+		{Domain: "limit", Resource: "bucket", Handler: handlers.NewRateLimitHandler("bucket", &handlers.RateLimitConfig{
+			Limit: 3,
+		})},
+		{Domain: "limit", Resource: "bucket", Handler: handlers.NewAuditHandler("audit", assert, ok)},
+		{Domain: "limit", Resource: "window", Handler: handlers.NewRateLimitHandler("window", &handlers.RateLimitConfig{
+			Algorithm: handlers.SlidingWindow,
+			Limit:     2,
+			Period:    time.Hour,
+		})},
+		{Domain: "limit", Resource: "window", Handler: handlers.NewAuditHandler("audit", assert, ok)},
+		{Domain: "limit", Resource: "apikey", Handler: handlers.NewRateLimitHandler("apikey", &handlers.RateLimitConfig{
+			Limit: 1,
+			Key:   handlers.KeyByHeader("X-API-Key"),
+			Store: handlers.NewMemoryRateLimitStore(),
+		})},
+		{Domain: "limit", Resource: "apikey", Handler: handlers.NewAuditHandler("audit", assert, ok)},
+	})
+	assert.Nil(err)
+	// Token bucket allows the burst, then denies.
+	req := restaudit.NewRequest("GET", "/limit/bucket")
+	req.AddHeader(restaudit.HeaderAccept, restaudit.ApplicationJSON)
+	for i := 2; i >= 0; i-- {
+		resp := ts.DoRequest(req)
+		resp.AssertStatusEquals(200)
+		resp.AssertHeaderEquals(http.CanonicalHeaderKey(handlers.HeaderRateLimitLimit), "3")
+		resp.AssertHeaderEquals(http.CanonicalHeaderKey(handlers.HeaderRateLimitRemaining), strconv.Itoa(i))
+	}
+	resp := ts.DoRequest(req)
+	resp.AssertStatusEquals(429)
+	resp.AssertHeaderEquals(http.CanonicalHeaderKey(handlers.HeaderRateLimitRemaining), "0")
+	resp.AssertHeaderEquals(http.CanonicalHeaderKey(handlers.HeaderRetryAfter), "20")
+	fb := resp.AssertUnmarshalledFeedback()
+	assert.Equal(fb.StatusCode, 429)
+	assert.Equal(fb.Message, "rate limit exceeded")
+	// Sliding window.
+	req = restaudit.NewRequest("POST", "/limit/window")
+	for i := 1; i >= 0; i-- {
+		resp = ts.DoRequest(req)
+		resp.AssertStatusEquals(200)
+		resp.AssertHeaderEquals(http.CanonicalHeaderKey(handlers.HeaderRateLimitLimit), "2")
+		resp.AssertHeaderEquals(http.CanonicalHeaderKey(handlers.HeaderRateLimitRemaining), strconv.Itoa(i))
+	}
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(429)
+	resp.AssertHeader(http.CanonicalHeaderKey(handlers.HeaderRetryAfter))
+	// API keys are limited independently, jobs without are not.
+	for _, key := range []string{"one", "two"} {
+		req = restaudit.NewRequest("GET", "/limit/apikey").AddHeader("X-API-Key", key)
+		resp = ts.DoRequest(req)
+		resp.AssertStatusEquals(200)
+		resp = ts.DoRequest(req)
+		resp.AssertStatusEquals(429)
+	}
+	req = restaudit.NewRequest("GET", "/limit/apikey")
+	for i := 0; i < 3; i++ {
+		resp = ts.DoRequest(req)
+		resp.AssertStatusEquals(200)
+	}
 }
 
 //--------------------
@@ -275,4 +1018,29 @@ func newMultiplexer(assert audit.Assertion) rest.Multiplexer {
 	return rest.NewMultiplexer(context.Background(), cfg)
 }
 
+// newCertificate creates a certificate valid for one day. Without
+// parent it is a self-signed CA.
+func newCertificate(assert audit.Assertion, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	assert.Nil(err)
+	certificate, err := x509.ParseCertificate(der)
+	assert.Nil(err)
+	return certificate, key
+}
+
 // EOF
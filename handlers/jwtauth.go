@@ -13,8 +13,10 @@ package handlers
 
 import (
 	"context"
+	"crypto/rsa"
 	"time"
 
+	"github.com/tideland/golib/errors"
 	"github.com/tideland/golib/logger"
 
 	"github.com/tideland/gorest/jwt"
@@ -26,29 +28,80 @@ import (
 //--------------------
 
 // JWTAuthorizationConfig allows to control how the JWT authorization
-// handler works. All values are optional. In this case tokens are only
-// decoded without using a cache, validated for the current time plus/minus
-// a minute leeway, and there's no user defined gatekeeper function
-// running afterwards. In case of a denial a warning is written with
-// the standard logger.
+// handler works. A key, key resolver, or certificate policy is needed
+// to verify the tokens, all other values are optional. Tokens with the
+// algorithm "none" are always rejected. In case of a denial a warning
+// is written with the standard logger.
 type JWTAuthorizationConfig struct {
-	Cache      jwt.Cache
-	Key        jwt.Key
-	Leeway     time.Duration
+	// Cache stores verified tokens, by default none is used.
+	Cache jwt.Cache
+
+	// Key verifies the token signatures.
+	Key jwt.Key
+
+	// KeyResolver, e.g. for a JSON Web Key Set, is used instead of
+	// the key if both are set.
+	KeyResolver jwt.KeyResolver
+
+	// Certificates verifies the certificate chain in the token header
+	// and uses the key of the leaf certificate instead of both others.
+	Certificates *jwt.CertificatePolicy
+
+	// Algorithms lists the allowed algorithms. By default all except
+	// "none" are allowed. Without key, key resolver, or certificates
+	// the configuration is invalid and registering the handler fails.
+	Algorithms []jwt.Algorithm
+
+	// DecryptionKey decrypts encrypted tokens first. They have to
+	// contain a signed token which is verified.
+	DecryptionKey jwt.Key
+
+	// AllowUnsigned lets the handler only decode the tokens without
+	// key, key resolver, or certificates. Also encrypted claims
+	// without signature are accepted then. As everybody knowing the
+	// encryption key can create them an RSA decryption key, whose
+	// public key is used for encrypting, is always rejected.
+	AllowUnsigned bool
+
+	// TokenSources are tried to find the token, by default the
+	// authorization header. Cookie sources are protected against
+	// CSRF.
+	TokenSources []jwt.TokenSource
+
+	// Leeway is used when validating "nbf" and "exp", by default it
+	// is one minute.
+	Leeway time.Duration
+
+	// Validation replaces the check of "nbf" and "exp" with the
+	// leeway.
+	Validation *jwt.ValidationPolicy
+
+	// Revocations are checked for revoked tokens if set.
+	Revocations jwt.Revocations
+
+	// Gatekeeper is a user defined function running after all other
+	// checks.
 	Gatekeeper func(job rest.Job, claims jwt.Claims) error
-	Logger     func(job rest.Job, msg string)
+
+	// Logger replaces the standard logger for denials.
+	Logger func(job rest.Job, msg string)
 }
 
 // jwtAuthorizationHandler checks for a valid token and then runs
 // a gatekeeper function. If everythinh is fine the token is stored
-// in the job context for the following handlers.
+// in the job context for the following handlers together with
+// the principal.
 type jwtAuthorizationHandler struct {
-	id         string
-	cache      jwt.Cache
-	key        jwt.Key
-	leeway     time.Duration
-	gatekeeper func(job rest.Job, claims jwt.Claims) error
-	logger     func(job rest.Job, msg string)
+	id          string
+	cache       jwt.Cache
+	verify      bool
+	options     []jwt.VerifyOption
+	leeway      time.Duration
+	validation  *jwt.ValidationPolicy
+	revocations jwt.Revocations
+	gatekeeper  func(job rest.Job, claims jwt.Claims) error
+	logger      func(job rest.Job, msg string)
+	err         error
 }
 
 // NewJWTAuthorizationHandler creates a handler checking for a valid JSON
@@ -57,20 +110,47 @@ func NewJWTAuthorizationHandler(id string, config *JWTAuthorizationConfig) rest.
 	h := &jwtAuthorizationHandler{
 		id:     id,
 		leeway: time.Minute,
-		logger: func(job rest.Job, msg string) {
-			logger.Warningf("access denied for %v: %s", job, msg)
-		},
+		logger: defaultDenyLogger,
 	}
 	if config != nil {
 		if config.Cache != nil {
 			h.cache = config.Cache
 		}
-		if config.Key != nil {
-			h.key = config.Key
+		switch {
+		case config.Certificates != nil:
+			h.verify = true
+			h.options = append(h.options, jwt.WithCertificatePolicy(config.Certificates))
+		case config.KeyResolver != nil:
+			h.verify = true
+			h.options = append(h.options, jwt.WithKeyResolver(config.KeyResolver))
+		case config.Key != nil:
+			h.verify = true
+			h.options = append(h.options, jwt.WithKey(config.Key))
+		}
+		if len(config.Algorithms) > 0 {
+			if !h.verify {
+				h.err = errors.New(ErrInvalidJWTConfig, errorMessages, "algorithms need a key, key resolver, or certificates")
+			}
+			h.options = append(h.options, jwt.WithAlgorithms(config.Algorithms...))
+		}
+		if config.DecryptionKey != nil {
+			if _, ok := config.DecryptionKey.(*rsa.PrivateKey); ok && !h.verify {
+				h.err = errors.New(ErrInvalidJWTConfig, errorMessages, "unsigned tokens encrypted with a public key are not allowed")
+			}
+			h.options = append(h.options, jwt.WithDecryptionKey(config.DecryptionKey))
+		}
+		if len(config.TokenSources) > 0 {
+			h.options = append(h.options, jwt.WithTokenSources(config.TokenSources...))
 		}
 		if config.Leeway != 0 {
 			h.leeway = config.Leeway
 		}
+		if config.Validation != nil {
+			h.validation = config.Validation
+		}
+		if config.Revocations != nil {
+			h.revocations = config.Revocations
+		}
 		if config.Gatekeeper != nil {
 			h.gatekeeper = config.Gatekeeper
 		}
@@ -78,6 +158,13 @@ func NewJWTAuthorizationHandler(id string, config *JWTAuthorizationConfig) rest.
 			h.logger = config.Logger
 		}
 	}
+	if !h.verify && h.err == nil {
+		if config == nil || !config.AllowUnsigned {
+			h.err = errors.New(ErrInvalidJWTConfig, errorMessages, "a key, key resolver, or certificates are needed unless unsigned tokens are allowed")
+		} else {
+			logger.Warningf("JWT authorization handler %q does not verify the token signatures", id)
+		}
+	}
 	return h
 }
 
@@ -88,7 +175,7 @@ func (h *jwtAuthorizationHandler) ID() string {
 
 // Init is specified on the ResourceHandler interface.
 func (h *jwtAuthorizationHandler) Init(env rest.Environment, domain, resource string) error {
-	return nil
+	return h.err
 }
 
 // Get is specified on the GetResourceHandler interface.
@@ -130,53 +217,54 @@ func (h *jwtAuthorizationHandler) Options(job rest.Job) (bool, error) {
 func (h *jwtAuthorizationHandler) check(job rest.Job) (bool, error) {
 	var token jwt.JWT
 	var err error
-	switch {
-	case h.cache != nil && h.key != nil:
-		token, err = jwt.VerifyCachedFromJob(job, h.cache, h.key)
-	case h.cache != nil && h.key == nil:
-		token, err = jwt.DecodeCachedFromJob(job, h.cache)
-	case h.cache == nil && h.key != nil:
-		token, err = jwt.VerifyFromJob(job, h.key)
-	default:
-		token, err = jwt.DecodeFromJob(job)
+	if h.verify {
+		token, err = jwt.VerifyCachedFromJobWith(job, h.cache, h.options...)
+	} else {
+		token, err = jwt.DecodeFromJobWith(job, h.cache, h.options...)
 	}
 	// Now do the checks.
 	if err != nil {
-		return h.deny(job, rest.StatusUnauthorized, err.Error())
+		return deny(job, h.logger, rest.StatusUnauthorized, err.Error())
 	}
 	if token == nil {
-		return h.deny(job, rest.StatusUnauthorized, "no JSON Web Token")
+		return deny(job, h.logger, rest.StatusUnauthorized, "no JSON Web Token")
+	}
+	if token.Algorithm() == jwt.NONE {
+		return deny(job, h.logger, rest.StatusUnauthorized, "JSON Web Token algorithm \"none\" is not allowed")
+	}
+	if h.validation != nil {
+		if err := h.validation.Validate(token.Claims()); err != nil {
+			return deny(job, h.logger, rest.StatusForbidden, "JSON Web Token claims are not valid: "+err.Error())
+		}
+	} else if !token.IsValid(h.leeway) {
+		return deny(job, h.logger, rest.StatusForbidden, "JSON Web Token claims 'nbf' and/or 'exp' are not valid")
 	}
-	if !token.IsValid(h.leeway) {
-		return h.deny(job, rest.StatusForbidden, "JSON Web Token claims 'nbf' and/or 'exp' are not valid")
+	if h.revocations != nil {
+		revoked, err := h.revocations.IsRevoked(token.Claims())
+		if err != nil {
+			return false, err
+		}
+		if revoked {
+			return deny(job, h.logger, rest.StatusUnauthorized, "JSON Web Token has been revoked")
+		}
 	}
 	if h.gatekeeper != nil {
 		err := h.gatekeeper(job, token.Claims())
 		if err != nil {
-			return h.deny(job, rest.StatusUnauthorized, "access rejected by gatekeeper: "+err.Error())
+			return deny(job, h.logger, rest.StatusUnauthorized, "access rejected by gatekeeper: "+err.Error())
 		}
 	}
-	// All fine, store token in context.
-	job.EnhanceContext(func(ctx context.Context) context.Context {
+	// All fine, store token and principal in context.
+	subject, _ := token.Claims().Subject()
+	principal := &Principal{
+		Subject:    subject,
+		Method:     AuthenticationJWT,
+		Attributes: token.Claims(),
+	}
+	authenticated(job, principal, func(ctx context.Context) context.Context {
 		return jwt.NewContext(ctx, token)
 	})
 	return true, nil
 }
 
-// deny sends a negative feedback to the caller.
-func (h *jwtAuthorizationHandler) deny(job rest.Job, statusCode int, msg string) (bool, error) {
-	h.logger(job, msg)
-	switch {
-	case job.AcceptsContentType(rest.ContentTypeJSON):
-		return rest.NegativeFeedback(job.JSON(false), statusCode, msg)
-	case job.AcceptsContentType(rest.ContentTypeXML):
-		return rest.NegativeFeedback(job.XML(), statusCode, msg)
-	default:
-		job.ResponseWriter().WriteHeader(statusCode)
-		job.ResponseWriter().Header().Set("Content-Type", rest.ContentTypePlain)
-		job.ResponseWriter().Write([]byte(msg))
-		return false, nil
-	}
-}
-
 // EOF
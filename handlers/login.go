@@ -0,0 +1,364 @@
+// Tideland GoREST - Handlers - Login
+//
+// Copyright (C) 2009-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package handlers
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/tideland/gorest/jwt"
+	"github.com/tideland/gorest/rest"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// Grant types of the login handler.
+const (
+	GrantPassword     = "password"
+	GrantRefreshToken = "refresh_token"
+)
+
+//--------------------
+// REFRESH TOKEN STORE
+//--------------------
+
+// RefreshToken contains the principal a refresh token has been
+// issued for.
+type RefreshToken struct {
+	ID         string
+	Subject    string
+	Attributes map[string]interface{}
+	Expires    time.Time
+}
+
+// RefreshTokenStore keeps the issued refresh tokens.
+type RefreshTokenStore interface {
+	// Add stores a new refresh token.
+	Add(token *RefreshToken) error
+
+	// Take returns the refresh token with the ID and removes it,
+	// so that it can only be used once. Unknown IDs return nil.
+	Take(id string) (*RefreshToken, error)
+
+	// Revoke removes the refresh token with the ID.
+	Revoke(id string) error
+}
+
+// memoryRefreshTokenStore implements RefreshTokenStore.
+type memoryRefreshTokenStore struct {
+	mutex  sync.Mutex
+	tokens map[string]*RefreshToken
+}
+
+// NewMemoryRefreshTokenStore creates a refresh token store keeping
+// the tokens in memory.
+func NewMemoryRefreshTokenStore() RefreshTokenStore {
+	return &memoryRefreshTokenStore{
+		tokens: make(map[string]*RefreshToken),
+	}
+}
+
+// Add implements the RefreshTokenStore interface.
+func (s *memoryRefreshTokenStore) Add(token *RefreshToken) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	for id, t := range s.tokens {
+		if t.Expires.Before(now) {
+			delete(s.tokens, id)
+		}
+	}
+	s.tokens[token.ID] = token
+	return nil
+}
+
+// Take implements the RefreshTokenStore interface.
+func (s *memoryRefreshTokenStore) Take(id string) (*RefreshToken, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	token, ok := s.tokens[id]
+	if !ok {
+		return nil, nil
+	}
+	delete(s.tokens, id)
+	return token, nil
+}
+
+// Revoke implements the RefreshTokenStore interface.
+func (s *memoryRefreshTokenStore) Revoke(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.tokens, id)
+	return nil
+}
+
+//--------------------
+// LOGIN HANDLER
+//--------------------
+
+// LoginConfig allows to control how the login handler works. The
+// verifier and the key are needed. Tokens are signed with HS512
+// and expire after 15 minutes by default. The claims function allows
+// to set custom claims for the principal. Refresh tokens are only
+// issued if a store is set, they expire after 24 hours by default.
+// In case of a denial a warning is written with the standard logger.
+type LoginConfig struct {
+	Verifier          CredentialVerifier
+	Key               jwt.Key
+	Algorithm         jwt.Algorithm
+	Issuer            string
+	Audience          []string
+	Expiration        time.Duration
+	Claims            func(job rest.Job, principal *Principal) (jwt.Claims, error)
+	RefreshStore      RefreshTokenStore
+	RefreshExpiration time.Duration
+	Logger            func(job rest.Job, msg string)
+}
+
+// LoginRequest contains the data posted to the login handler,
+// either as JSON or as form values.
+type LoginRequest struct {
+	GrantType    string `json:"grant_type"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// LoginResponse contains the issued tokens.
+type LoginResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// loginHandler issues tokens.
+type loginHandler struct {
+	id                string
+	verifier          CredentialVerifier
+	key               jwt.Key
+	algorithm         jwt.Algorithm
+	issuer            string
+	audience          []string
+	expiration        time.Duration
+	claims            func(job rest.Job, principal *Principal) (jwt.Claims, error)
+	refreshStore      RefreshTokenStore
+	refreshExpiration time.Duration
+	logger            func(job rest.Job, msg string)
+}
+
+// NewLoginHandler creates a handler issuing JSON Web Tokens. POST
+// requests with the grant type "password" verify user name and password,
+// those with the grant type "refresh_token" exchange a refresh token
+// against new tokens. DELETE requests revoke the passed refresh token.
+func NewLoginHandler(id string, config *LoginConfig) rest.ResourceHandler {
+	h := &loginHandler{
+		id:                id,
+		algorithm:         jwt.HS512,
+		expiration:        15 * time.Minute,
+		refreshExpiration: 24 * time.Hour,
+		logger:            defaultDenyLogger,
+	}
+	if config != nil {
+		h.verifier = config.Verifier
+		h.key = config.Key
+		if config.Algorithm != "" {
+			h.algorithm = config.Algorithm
+		}
+		h.issuer = config.Issuer
+		h.audience = config.Audience
+		if config.Expiration > 0 {
+			h.expiration = config.Expiration
+		}
+		h.claims = config.Claims
+		h.refreshStore = config.RefreshStore
+		if config.RefreshExpiration > 0 {
+			h.refreshExpiration = config.RefreshExpiration
+		}
+		if config.Logger != nil {
+			h.logger = config.Logger
+		}
+	}
+	return h
+}
+
+// ID is specified on the ResourceHandler interface.
+func (h *loginHandler) ID() string {
+	return h.id
+}
+
+// Init is specified on the ResourceHandler interface.
+func (h *loginHandler) Init(env rest.Environment, domain, resource string) error {
+	return nil
+}
+
+// Post is specified on the PostResourceHandler interface.
+func (h *loginHandler) Post(job rest.Job) (bool, error) {
+	lr, err := h.readRequest(job)
+	if err != nil {
+		return deny(job, h.logger, rest.StatusBadRequest, "invalid login request: "+err.Error())
+	}
+	var principal *Principal
+	switch lr.GrantType {
+	case GrantPassword:
+		if lr.Username == "" {
+			return deny(job, h.logger, rest.StatusBadRequest, "no user name")
+		}
+		if h.verifier == nil {
+			return deny(job, h.logger, rest.StatusUnauthorized, "no credential verifier")
+		}
+		principal, err = h.verifier.VerifyCredentials(job, lr.Username, lr.Password)
+		if err != nil {
+			return deny(job, h.logger, rest.StatusUnauthorized, "invalid credentials: "+err.Error())
+		}
+		if principal == nil {
+			return deny(job, h.logger, rest.StatusUnauthorized, "invalid credentials")
+		}
+		if principal.Subject == "" {
+			principal.Subject = lr.Username
+		}
+	case GrantRefreshToken:
+		if h.refreshStore == nil {
+			return deny(job, h.logger, rest.StatusBadRequest, "refresh tokens are not supported")
+		}
+		if lr.RefreshToken == "" {
+			return deny(job, h.logger, rest.StatusBadRequest, "no refresh token")
+		}
+		rt, err := h.refreshStore.Take(lr.RefreshToken)
+		if err != nil {
+			return false, err
+		}
+		if rt == nil || rt.Expires.Before(time.Now()) {
+			return deny(job, h.logger, rest.StatusUnauthorized, "invalid refresh token")
+		}
+		principal = &Principal{
+			Subject:    rt.Subject,
+			Attributes: rt.Attributes,
+		}
+	default:
+		return deny(job, h.logger, rest.StatusBadRequest, "unsupported grant type '"+lr.GrantType+"'")
+	}
+	return h.issue(job, principal)
+}
+
+// Delete is specified on the DeleteResourceHandler interface.
+func (h *loginHandler) Delete(job rest.Job) (bool, error) {
+	lr, err := h.readRequest(job)
+	if err != nil {
+		return deny(job, h.logger, rest.StatusBadRequest, "invalid logout request: "+err.Error())
+	}
+	if h.refreshStore == nil || lr.RefreshToken == "" {
+		return deny(job, h.logger, rest.StatusBadRequest, "no refresh token")
+	}
+	if err := h.refreshStore.Revoke(lr.RefreshToken); err != nil {
+		return false, err
+	}
+	job.ResponseWriter().WriteHeader(rest.StatusNoContent)
+	return false, nil
+}
+
+// readRequest reads the login request out of the JSON body
+// or the form values.
+func (h *loginHandler) readRequest(job rest.Job) (*LoginRequest, error) {
+	lr := &LoginRequest{}
+	if job.HasContentType(rest.ContentTypeJSON) {
+		if err := job.JSON(false).Read(lr); err != nil {
+			return nil, err
+		}
+		return lr, nil
+	}
+	r := job.Request()
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	lr.GrantType = r.PostFormValue("grant_type")
+	lr.Username = r.PostFormValue("username")
+	lr.Password = r.PostFormValue("password")
+	lr.RefreshToken = r.PostFormValue("refresh_token")
+	return lr, nil
+}
+
+// issue creates the tokens for the principal and writes them.
+func (h *loginHandler) issue(job rest.Job, principal *Principal) (bool, error) {
+	claims := jwt.NewClaims()
+	if h.claims != nil {
+		custom, err := h.claims(job, principal)
+		if err != nil {
+			return deny(job, h.logger, rest.StatusUnauthorized, "claims rejected: "+err.Error())
+		}
+		for key, value := range custom {
+			claims.Set(key, value)
+		}
+	}
+	jti, err := randomID()
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	claims.SetSubject(principal.Subject)
+	claims.SetIdentifier(jti)
+	claims.SetIssuedAt(now)
+	claims.SetNotBefore(now)
+	claims.SetExpiration(now.Add(h.expiration))
+	if h.issuer != "" {
+		claims.SetIssuer(h.issuer)
+	}
+	if len(h.audience) > 0 {
+		claims.SetAudience(h.audience...)
+	}
+	token, err := jwt.Encode(claims, h.key, h.algorithm)
+	if err != nil {
+		return false, err
+	}
+	resp := LoginResponse{
+		AccessToken: token.String(),
+		TokenType:   "Bearer",
+		ExpiresIn:   int(h.expiration / time.Second),
+	}
+	if h.refreshStore != nil {
+		rtid, err := randomID()
+		if err != nil {
+			return false, err
+		}
+		rt := &RefreshToken{
+			ID:         rtid,
+			Subject:    principal.Subject,
+			Attributes: principal.Attributes,
+			Expires:    now.Add(h.refreshExpiration),
+		}
+		if err := h.refreshStore.Add(rt); err != nil {
+			return false, err
+		}
+		resp.RefreshToken = rt.ID
+	}
+	job.ResponseWriter().Header().Set("Cache-Control", "no-store")
+	return false, job.JSON(false).Write(rest.StatusOK, resp)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// randomID creates a random ID for tokens.
+func randomID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// EOF
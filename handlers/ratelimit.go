@@ -0,0 +1,343 @@
+// Tideland GoREST - Handlers - Rate Limit
+//
+// Copyright (C) 2009-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package handlers
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tideland/gorest/rest"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// Algorithms of the rate limit handler.
+const (
+	TokenBucket   = "token-bucket"
+	SlidingWindow = "sliding-window"
+)
+
+// Header names of the rate limiting.
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	HeaderRetryAfter         = "Retry-After"
+)
+
+//--------------------
+// RATE LIMIT KEYS
+//--------------------
+
+// RateLimitKeyFunc returns the key identifying the client of a
+// job. Jobs with an empty key are not limited.
+type RateLimitKeyFunc func(job rest.Job) string
+
+// KeyByClientIP uses the IP address of the remote client as key.
+func KeyByClientIP(job rest.Job) string {
+	host, _, err := net.SplitHostPort(job.Request().RemoteAddr)
+	if err != nil {
+		return job.Request().RemoteAddr
+	}
+	return host
+}
+
+// KeyBySubject uses the subject stored in the job context by one
+// of the authentication handlers as key.
+func KeyBySubject(job rest.Job) string {
+	subject, _ := rest.SubjectFromContext(job.Context())
+	return subject
+}
+
+// KeyByHeader returns a key function using the value of the named
+// header, e.g. containing an API key.
+func KeyByHeader(name string) RateLimitKeyFunc {
+	return func(job rest.Job) string {
+		return job.Request().Header.Get(name)
+	}
+}
+
+//--------------------
+// RATE LIMIT STORE
+//--------------------
+
+// RateLimitState contains the state of one key. The token bucket
+// uses tokens and last update, the sliding window the counters and
+// the start of the current window.
+type RateLimitState struct {
+	Tokens      float64
+	Last        time.Time
+	Current     int
+	Previous    int
+	WindowStart time.Time
+}
+
+// RateLimitStore stores the states of the rate limiter keys.
+type RateLimitStore interface {
+	// Update passes the state of the key to the function and stores
+	// the returned one. New keys start with an empty state. Updates
+	// of the same key have to be serialized. The state may be
+	// dropped after the TTL without updates.
+	Update(key string, ttl time.Duration, f func(state RateLimitState) RateLimitState) error
+}
+
+// memoryRateLimitStoreEntry contains a state and its expiration.
+type memoryRateLimitStoreEntry struct {
+	state   RateLimitState
+	expires time.Time
+}
+
+// memoryRateLimitStore implements RateLimitStore.
+type memoryRateLimitStore struct {
+	mutex   sync.Mutex
+	entries map[string]*memoryRateLimitStoreEntry
+	cleaned time.Time
+}
+
+// NewMemoryRateLimitStore creates a rate limit store keeping
+// the states in memory.
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{
+		entries: make(map[string]*memoryRateLimitStoreEntry),
+		cleaned: time.Now(),
+	}
+}
+
+// Update implements the RateLimitStore interface.
+func (s *memoryRateLimitStore) Update(key string, ttl time.Duration, f func(state RateLimitState) RateLimitState) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(s.cleaned) > time.Minute {
+		for k, entry := range s.entries {
+			if entry.expires.Before(now) {
+				delete(s.entries, k)
+			}
+		}
+		s.cleaned = now
+	}
+	entry, ok := s.entries[key]
+	if !ok || entry.expires.Before(now) {
+		entry = &memoryRateLimitStoreEntry{}
+		s.entries[key] = entry
+	}
+	entry.state = f(entry.state)
+	entry.expires = now.Add(ttl)
+	return nil
+}
+
+//--------------------
+// RATE LIMIT HANDLER
+//--------------------
+
+// RateLimitConfig allows to control the rate limit handler. All
+// values are optional. By default a token bucket allows 60 requests
+// per minute per client IP address, the burst is the limit, and the
+// states are stored in memory.
+type RateLimitConfig struct {
+	Algorithm string
+	Limit     int
+	Period    time.Duration
+	Burst     int
+	Key       RateLimitKeyFunc
+	Store     RateLimitStore
+}
+
+// rateLimitHandler limits the number of requests of a client
+// in a period.
+type rateLimitHandler struct {
+	id        string
+	algorithm string
+	limit     int
+	period    time.Duration
+	burst     int
+	key       RateLimitKeyFunc
+	store     RateLimitStore
+}
+
+// NewRateLimitHandler creates a handler limiting the rate of requests
+// per client. Allowed requests get the RateLimit-Limit, -Remaining, and
+// -Reset headers and are passed to the following handlers. Others are
+// answered with status 429, a Retry-After header, and a negative
+// feedback.
+func NewRateLimitHandler(id string, config *RateLimitConfig) rest.ResourceHandler {
+	h := &rateLimitHandler{
+		id:        id,
+		algorithm: TokenBucket,
+		limit:     60,
+		period:    time.Minute,
+		key:       KeyByClientIP,
+	}
+	if config != nil {
+		if config.Algorithm != "" {
+			h.algorithm = config.Algorithm
+		}
+		if config.Limit > 0 {
+			h.limit = config.Limit
+		}
+		if config.Period > 0 {
+			h.period = config.Period
+		}
+		if config.Burst > 0 {
+			h.burst = config.Burst
+		}
+		if config.Key != nil {
+			h.key = config.Key
+		}
+		if config.Store != nil {
+			h.store = config.Store
+		}
+	}
+	if h.burst == 0 {
+		h.burst = h.limit
+	}
+	if h.store == nil {
+		h.store = NewMemoryRateLimitStore()
+	}
+	return h
+}
+
+// ID is specified on the ResourceHandler interface.
+func (h *rateLimitHandler) ID() string {
+	return h.id
+}
+
+// Init is specified on the ResourceHandler interface.
+func (h *rateLimitHandler) Init(env rest.Environment, domain, resource string) error {
+	return nil
+}
+
+// Get is specified on the GetResourceHandler interface.
+func (h *rateLimitHandler) Get(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Head is specified on the HeadResourceHandler interface.
+func (h *rateLimitHandler) Head(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Put is specified on the PutResourceHandler interface.
+func (h *rateLimitHandler) Put(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Post is specified on the PostResourceHandler interface.
+func (h *rateLimitHandler) Post(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Patch is specified on the PatchResourceHandler interface.
+func (h *rateLimitHandler) Patch(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Delete is specified on the DeleteResourceHandler interface.
+func (h *rateLimitHandler) Delete(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// Options is specified on the OptionsResourceHandler interface.
+func (h *rateLimitHandler) Options(job rest.Job) (bool, error) {
+	return h.check(job)
+}
+
+// check is used by all methods to check the rate.
+func (h *rateLimitHandler) check(job rest.Job) (bool, error) {
+	key := h.key(job)
+	if key == "" {
+		return true, nil
+	}
+	var allowed bool
+	var limit, remaining int
+	var reset, retry time.Duration
+	now := time.Now()
+	err := h.store.Update(h.id+":"+key, 2*h.period, func(state RateLimitState) RateLimitState {
+		switch h.algorithm {
+		case SlidingWindow:
+			limit = h.limit
+			state, allowed, remaining, reset, retry = h.slidingWindow(state, now)
+		default:
+			limit = h.burst
+			state, allowed, remaining, reset, retry = h.tokenBucket(state, now)
+		}
+		return state
+	})
+	if err != nil {
+		return false, err
+	}
+	header := job.ResponseWriter().Header()
+	header.Set(HeaderRateLimitLimit, strconv.Itoa(limit))
+	header.Set(HeaderRateLimitRemaining, strconv.Itoa(remaining))
+	header.Set(HeaderRateLimitReset, strconv.Itoa(seconds(reset)))
+	if allowed {
+		return true, nil
+	}
+	header.Set(HeaderRetryAfter, strconv.Itoa(seconds(retry)))
+	return deny(job, nil, rest.StatusTooManyRequests, "rate limit exceeded")
+}
+
+// tokenBucket refills the bucket with limit tokens per period up
+// to the burst and takes one token.
+func (h *rateLimitHandler) tokenBucket(state RateLimitState, now time.Time) (RateLimitState, bool, int, time.Duration, time.Duration) {
+	rate := float64(h.limit) / h.period.Seconds()
+	if state.Last.IsZero() {
+		state.Tokens = float64(h.burst)
+	} else {
+		state.Tokens = math.Min(float64(h.burst), state.Tokens+now.Sub(state.Last).Seconds()*rate)
+	}
+	state.Last = now
+	allowed := state.Tokens >= 1.0
+	if allowed {
+		state.Tokens--
+	}
+	reset := time.Duration((float64(h.burst) - state.Tokens) / rate * float64(time.Second))
+	retry := time.Duration((1.0 - state.Tokens) / rate * float64(time.Second))
+	return state, allowed, int(state.Tokens), reset, retry
+}
+
+// slidingWindow weights the count of the previous window by its
+// overlap with the sliding window and adds the current count.
+func (h *rateLimitHandler) slidingWindow(state RateLimitState, now time.Time) (RateLimitState, bool, int, time.Duration, time.Duration) {
+	start := now.Truncate(h.period)
+	switch {
+	case state.WindowStart.Equal(start):
+	case state.WindowStart.Add(h.period).Equal(start):
+		state.Previous = state.Current
+		state.Current = 0
+	default:
+		state.Previous = 0
+		state.Current = 0
+	}
+	state.WindowStart = start
+	weight := 1.0 - float64(now.Sub(start))/float64(h.period)
+	count := float64(state.Previous)*weight + float64(state.Current)
+	reset := start.Add(h.period).Sub(now)
+	if count+1.0 > float64(h.limit) {
+		return state, false, 0, reset, reset
+	}
+	state.Current++
+	remaining := h.limit - int(math.Ceil(count+1.0))
+	return state, true, remaining, reset, 0
+}
+
+// seconds returns the duration in full seconds, rounded up.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// EOF
@@ -14,6 +14,7 @@ package jwt
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
@@ -47,6 +48,7 @@ const (
 	ES256 Algorithm = "ES256"
 	ES384 Algorithm = "ES384"
 	ES512 Algorithm = "ES512"
+	EdDSA Algorithm = "EdDSA"
 	HS256 Algorithm = "HS256"
 	HS384 Algorithm = "HS384"
 	HS512 Algorithm = "HS512"
@@ -75,7 +77,7 @@ func (a Algorithm) Sign(data []byte, key Key) (Signature, error) {
 		return a.sign(data, key, crypto.SHA384)
 	case ES512, HS512, PS512, RS512:
 		return a.sign(data, key, crypto.SHA512)
-	case NONE:
+	case EdDSA, NONE:
 		return a.sign(data, key, 0)
 	default:
 		return nil, errors.New(ErrInvalidAlgorithm, errorMessages, a)
@@ -92,7 +94,7 @@ func (a Algorithm) Verify(data []byte, sig Signature, key Key) error {
 		return a.verify(data, sig, key, crypto.SHA384)
 	case ES512, HS512, PS512, RS512:
 		return a.verify(data, sig, key, crypto.SHA512)
-	case NONE:
+	case EdDSA, NONE:
 		return a.verify(data, sig, key, 0)
 	default:
 		return errors.New(ErrInvalidAlgorithm, errorMessages, a)
@@ -117,6 +119,9 @@ func (a Algorithm) sign(data []byte, k Key, h crypto.Hash) (Signature, error) {
 	case *rsa.PrivateKey:
 		// RSA and RSAPSS algorithms.
 		return a.signRSA(data, key, h)
+	case ed25519.PrivateKey:
+		// EdDSA algorithm.
+		return a.signEdDSA(data, key)
 	case string:
 		// None algorithm.
 		if a != "none" {
@@ -131,7 +136,7 @@ func (a Algorithm) sign(data []byte, k Key, h crypto.Hash) (Signature, error) {
 
 // signECDSA signs the data using the ECDSA algorithm.
 func (a Algorithm) signECDSA(data []byte, key *ecdsa.PrivateKey, h crypto.Hash) (Signature, error) {
-	if a[0] != 'E' {
+	if a[0] != 'E' || a == EdDSA {
 		return nil, errors.New(ErrInvalidCombination, errorMessages, a, "ECDSA")
 	}
 	r, s, err := ecdsa.Sign(rand.Reader, key, hashSum(data, h))
@@ -181,6 +186,17 @@ func (a Algorithm) signRSA(data []byte, key *rsa.PrivateKey, h crypto.Hash) (Sig
 	return Signature(sig), nil
 }
 
+// signEdDSA signs the data using the EdDSA algorithm.
+func (a Algorithm) signEdDSA(data []byte, key ed25519.PrivateKey) (Signature, error) {
+	if a != EdDSA {
+		return nil, errors.New(ErrInvalidCombination, errorMessages, a, "EdDSA")
+	}
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New(ErrInvalidKeyType, errorMessages, key)
+	}
+	return Signature(ed25519.Sign(key, data)), nil
+}
+
 // verify checks if the signature is correct for the passed data
 // based on the key and the passed hash.
 func (a Algorithm) verify(data []byte, sig Signature, k Key, h crypto.Hash) error {
@@ -194,6 +210,9 @@ func (a Algorithm) verify(data []byte, sig Signature, k Key, h crypto.Hash) erro
 	case *rsa.PublicKey:
 		// RSA and RSAPSS algorithms.
 		return a.verifyRSA(data, sig, key, h)
+	case ed25519.PublicKey:
+		// EdDSA algorithm.
+		return a.verifyEdDSA(data, sig, key)
 	case string:
 		// None algorithm.
 		if a != "none" {
@@ -211,7 +230,7 @@ func (a Algorithm) verify(data []byte, sig Signature, k Key, h crypto.Hash) erro
 
 // verifyECDSA verifies the data using the ECDSA algorithm.
 func (a Algorithm) verifyECDSA(data []byte, sig Signature, key *ecdsa.PublicKey, h crypto.Hash) error {
-	if a[0] != 'E' {
+	if a[0] != 'E' || a == EdDSA {
 		return errors.New(ErrInvalidCombination, errorMessages, a, "ECDSA")
 	}
 	var ecp ecPoint
@@ -262,6 +281,17 @@ func (a Algorithm) verifyRSA(data []byte, sig Signature, key *rsa.PublicKey, h c
 	return nil
 }
 
+// verifyEdDSA verifies the data using the EdDSA algorithm.
+func (a Algorithm) verifyEdDSA(data []byte, sig Signature, key ed25519.PublicKey) error {
+	if a != EdDSA {
+		return errors.New(ErrInvalidCombination, errorMessages, a, "EdDSA")
+	}
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, data, sig) {
+		return errors.New(ErrInvalidSignature, errorMessages)
+	}
+	return nil
+}
+
 //--------------------
 // HELPERS
 //--------------------
@@ -12,7 +12,10 @@ package jwt
 //--------------------
 
 import (
+	"container/heap"
+	"container/list"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tideland/golib/loop"
@@ -38,22 +41,51 @@ type Cache interface {
 	Stop() error
 }
 
-// cacheEntry manages a token and its access time.
-type cacheEntry struct {
-	jwt      JWT
-	accessed time.Time
+// StatisticsProvider is implemented by caches providing statistics
+// like the ones created with NewCache() or NewCacheWithConfig().
+type StatisticsProvider interface {
+	// Statistics returns the statistics of the cache.
+	Statistics() CacheStatistics
+}
+
+// CacheStatistics contains the number of hits, misses, and
+// evictions of a cache as well as the number of entries. Evictions
+// count tokens removed due to the size limit, expirations the ones
+// removed as expired, unused, or revoked.
+type CacheStatistics struct {
+	Hits        int64
+	Misses      int64
+	Evictions   int64
+	Expirations int64
+	Entries     int
+}
+
+// CacheConfig allows to control the cache. The TTL is the time a cached
+// token may be unused, without a TTL unused tokens stay cached until
+// they expire or are evicted. The leeway is used for the time
+// validation of the token itself. The interval controls how often the
+// background cleanup is running, without a positive interval it only
+// runs when calling Cleanup(). The cache holds at most MaxEntries
+// tokens, the least recently used ones are evicted. With more than one
+// shard the tokens are distributed over shards with own locks and
+// MaxEntries divided between them, there are never more shards than
+// entries. Revoked tokens are only detected if revocations are set.
+type CacheConfig struct {
+	TTL         time.Duration
+	Leeway      time.Duration
+	Interval    time.Duration
+	MaxEntries  int
+	Shards      int
+	Revocations Revocations
 }
 
 // cache implements Cache.
 type cache struct {
-	mutex      sync.Mutex
-	entries    map[string]*cacheEntry
-	ttl        time.Duration
-	leeway     time.Duration
-	interval   time.Duration
-	maxEntries int
-	cleanupc   chan time.Duration
-	loop       loop.Loop
+	shards   []*cacheShard
+	size     int64
+	interval time.Duration
+	cleanupc chan struct{}
+	loop     loop.Loop
 }
 
 // NewCache creates a new JWT caching. The ttl value controls
@@ -61,16 +93,52 @@ type cache struct {
 // leeway is used for the time validation of the token itself.
 // The duration of the interval controls how often the background
 // cleanup is running. Final configuration parameter is the maximum
-// number of entries inside the cache. If these grow too fast the
-// ttl will be temporarily reduced for cleanup.
+// number of entries inside the cache. If it is reached the least
+// recently used tokens are evicted.
 func NewCache(ttl, leeway, interval time.Duration, maxEntries int) Cache {
+	return NewRevocationCheckingCache(ttl, leeway, interval, maxEntries, nil)
+}
+
+// NewRevocationCheckingCache creates a JWT caching like NewCache but
+// also checks the revocations. Revoked tokens are not cached and are
+// evicted when they are accessed or during the cleanup.
+func NewRevocationCheckingCache(ttl, leeway, interval time.Duration, maxEntries int, revocations Revocations) Cache {
+	return NewCacheWithConfig(&CacheConfig{
+		TTL:         ttl,
+		Leeway:      leeway,
+		Interval:    interval,
+		MaxEntries:  maxEntries,
+		Revocations: revocations,
+	})
+}
+
+// NewCacheWithConfig creates a JWT caching based on the
+// configuration.
+func NewCacheWithConfig(config *CacheConfig) Cache {
+	maxEntries := config.MaxEntries
+	if maxEntries < 1 {
+		maxEntries = 1
+	}
+	shards := config.Shards
+	if shards < 1 {
+		shards = 1
+	}
+	if shards > maxEntries {
+		shards = maxEntries
+	}
 	c := &cache{
-		entries:    map[string]*cacheEntry{},
-		ttl:        ttl,
-		leeway:     leeway,
-		interval:   interval,
-		maxEntries: maxEntries,
-		cleanupc:   make(chan time.Duration, 5),
+		shards:   make([]*cacheShard, shards),
+		interval: config.Interval,
+		cleanupc: make(chan struct{}, 1),
+	}
+	// Distribute the remainder so that the shards together hold
+	// exactly MaxEntries tokens.
+	for i := range c.shards {
+		shardEntries := maxEntries / shards
+		if i < maxEntries%shards {
+			shardEntries++
+		}
+		c.shards[i] = newCacheShard(config, shardEntries, &c.size)
 	}
 	c.loop = loop.Go(c.backendLoop, "jwt", "cache")
 	return c
@@ -78,39 +146,36 @@ func NewCache(ttl, leeway, interval time.Duration, maxEntries int) Cache {
 
 // Get implements the Cache interface.
 func (c *cache) Get(token string) (JWT, bool) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	entry, ok := c.entries[token]
-	if !ok {
-		return nil, false
-	}
-	if entry.jwt.IsValid(c.leeway) {
-		entry.accessed = time.Now()
-		return entry.jwt, true
-	}
-	// Remove invalid token.
-	delete(c.entries, token)
-	return nil, false
+	return c.shard(token).get(token, time.Now())
 }
 
 // Put implements the Cache interface.
 func (c *cache) Put(jwt JWT) int {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	if jwt.IsValid(c.leeway) {
-		c.entries[jwt.String()] = &cacheEntry{jwt, time.Now()}
-		lenEntries := len(c.entries)
-		if lenEntries > c.maxEntries {
-			ttl := int64(c.ttl) / int64(lenEntries) * int64(c.maxEntries)
-			c.cleanupc <- time.Duration(ttl)
-		}
-	}
-	return len(c.entries)
+	c.shard(jwt.String()).put(jwt, time.Now())
+	return int(atomic.LoadInt64(&c.size))
 }
 
 // Cleanup implements the Cache interface.
 func (c *cache) Cleanup() {
-	c.cleanupc <- c.ttl
+	select {
+	case c.cleanupc <- struct{}{}:
+	default:
+	}
+}
+
+// Statistics implements the StatisticsProvider interface.
+func (c *cache) Statistics() CacheStatistics {
+	var stats CacheStatistics
+	for _, s := range c.shards {
+		s.mutex.Lock()
+		stats.Hits += s.stats.Hits
+		stats.Misses += s.stats.Misses
+		stats.Evictions += s.stats.Evictions
+		stats.Expirations += s.stats.Expirations
+		stats.Entries += s.lru.Len()
+		s.mutex.Unlock()
+	}
+	return stats
 }
 
 // Stop implements the Cache interface.
@@ -118,40 +183,258 @@ func (c *cache) Stop() error {
 	return c.loop.Stop()
 }
 
-// backendLoop runs a cleaning session every five minutes.
+// backendLoop runs the cleaning sessions.
 func (c *cache) backendLoop(l loop.Loop) error {
 	defer func() {
-		// Cleanup entries map after stop or error.
-		c.entries = nil
+		// Cleanup entries after stop or error.
+		for _, s := range c.shards {
+			s.clear()
+		}
 	}()
-	ticker := time.NewTicker(c.interval)
+	var tickc <-chan time.Time
+	if c.interval > 0 {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		tickc = ticker.C
+	}
 	for {
 		select {
 		case <-l.ShallStop():
 			return nil
-		case ttl := <-c.cleanupc:
-			c.cleanup(ttl)
-		case <-ticker.C:
-			c.cleanup(c.ttl)
+		case <-c.cleanupc:
+			c.cleanup()
+		case <-tickc:
+			c.cleanup()
 		}
 	}
 }
 
-// cleanup checks for invalid or unused tokens.
-func (c *cache) cleanup(ttl time.Duration) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	valids := map[string]*cacheEntry{}
+// cleanup removes expired, unused, or revoked tokens.
+func (c *cache) cleanup() {
 	now := time.Now()
-	for token, entry := range c.entries {
-		if entry.jwt.IsValid(c.leeway) {
-			if entry.accessed.Add(ttl).After(now) {
-				// Everything fine.
-				valids[token] = entry
+	for _, s := range c.shards {
+		s.cleanup(now)
+	}
+}
+
+// shard returns the shard responsible for the token.
+func (c *cache) shard(token string) *cacheShard {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	// FNV-1a hash of the token.
+	h := uint32(2166136261)
+	for i := 0; i < len(token); i++ {
+		h ^= uint32(token[i])
+		h *= 16777619
+	}
+	return c.shards[h%uint32(len(c.shards))]
+}
+
+//--------------------
+// CACHE SHARD
+//--------------------
+
+// cacheEntry manages a token, its access time, and its expiration.
+type cacheEntry struct {
+	token    string
+	jwt      JWT
+	accessed time.Time
+	expires  time.Time
+	element  *list.Element
+	index    int
+}
+
+// cacheShard is one LRU part of the cache. The list contains the
+// entries in order of their usage, the heap the ones with an
+// expiration in order of it.
+type cacheShard struct {
+	mutex      sync.Mutex
+	entries    map[string]*cacheEntry
+	lru        *list.List
+	expiries   expiryHeap
+	ttl        time.Duration
+	leeway     time.Duration
+	maxEntries int
+	revoked    Revocations
+	size       *int64
+	stats      CacheStatistics
+}
+
+// newCacheShard creates a shard.
+func newCacheShard(config *CacheConfig, maxEntries int, size *int64) *cacheShard {
+	return &cacheShard{
+		entries:    map[string]*cacheEntry{},
+		lru:        list.New(),
+		ttl:        config.TTL,
+		leeway:     config.Leeway,
+		maxEntries: maxEntries,
+		revoked:    config.Revocations,
+		size:       size,
+	}
+}
+
+// get retrieves a token and marks it as used.
+func (s *cacheShard) get(token string, now time.Time) (JWT, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.entries[token]
+	if !ok {
+		s.stats.Misses++
+		return nil, false
+	}
+	if s.isExpired(entry, now) || s.isRevoked(entry.jwt) {
+		// Remove invalid, unused, or revoked token.
+		s.remove(entry)
+		s.stats.Expirations++
+		s.stats.Misses++
+		return nil, false
+	}
+	entry.accessed = now
+	s.lru.MoveToFront(entry.element)
+	s.stats.Hits++
+	return entry.jwt, true
+}
+
+// put adds a valid token and evicts the least recently used
+// ones if the shard is full.
+func (s *cacheShard) put(jwt JWT, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	token := jwt.String()
+	if entry, ok := s.entries[token]; ok {
+		s.remove(entry)
+	}
+	if !jwt.IsValid(s.leeway) || s.isRevoked(jwt) {
+		return
+	}
+	entry := &cacheEntry{
+		token:    token,
+		jwt:      jwt,
+		accessed: now,
+		index:    -1,
+	}
+	if exp, ok := jwt.Claims().Expiration(); ok {
+		entry.expires = exp.Add(s.leeway)
+		heap.Push(&s.expiries, entry)
+	}
+	entry.element = s.lru.PushFront(entry)
+	s.entries[token] = entry
+	atomic.AddInt64(s.size, 1)
+	for s.lru.Len() > s.maxEntries {
+		s.remove(s.lru.Back().Value.(*cacheEntry))
+		s.stats.Evictions++
+	}
+}
+
+// cleanup removes expired, unused, and revoked tokens. Expired ones
+// are taken from the heap, unused ones from the end of the list.
+// Only revocations need a check of all tokens.
+func (s *cacheShard) cleanup(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for len(s.expiries) > 0 && !now.Before(s.expiries[0].expires) {
+		s.remove(s.expiries[0])
+		s.stats.Expirations++
+	}
+	for s.ttl > 0 && s.lru.Len() > 0 {
+		entry := s.lru.Back().Value.(*cacheEntry)
+		if entry.accessed.Add(s.ttl).After(now) {
+			break
+		}
+		s.remove(entry)
+		s.stats.Expirations++
+	}
+	if s.revoked != nil {
+		for _, entry := range s.entries {
+			if s.isRevoked(entry.jwt) {
+				s.remove(entry)
+				s.stats.Expirations++
 			}
 		}
 	}
-	c.entries = valids
+}
+
+// clear removes all tokens.
+func (s *cacheShard) clear() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	atomic.AddInt64(s.size, -int64(len(s.entries)))
+	s.entries = map[string]*cacheEntry{}
+	s.lru.Init()
+	s.expiries = nil
+}
+
+// remove deletes the entry out of map, list, and heap.
+func (s *cacheShard) remove(entry *cacheEntry) {
+	delete(s.entries, entry.token)
+	s.lru.Remove(entry.element)
+	if entry.index >= 0 {
+		heap.Remove(&s.expiries, entry.index)
+	}
+	atomic.AddInt64(s.size, -1)
+}
+
+// isExpired checks if the token is expired or has been unused
+// for too long.
+func (s *cacheShard) isExpired(entry *cacheEntry, now time.Time) bool {
+	if !entry.expires.IsZero() && !now.Before(entry.expires) {
+		return true
+	}
+	return s.ttl > 0 && !entry.accessed.Add(s.ttl).After(now)
+}
+
+// isRevoked checks if the token is revoked. Errors are handled
+// like revocations, so the token will be checked again outside
+// of the cache.
+func (s *cacheShard) isRevoked(jwt JWT) bool {
+	if s.revoked == nil {
+		return false
+	}
+	revoked, err := s.revoked.IsRevoked(jwt.Claims())
+	return revoked || err != nil
+}
+
+//--------------------
+// EXPIRY HEAP
+//--------------------
+
+// expiryHeap orders the cache entries by their expiration.
+type expiryHeap []*cacheEntry
+
+// Len implements the heap.Interface.
+func (h expiryHeap) Len() int {
+	return len(h)
+}
+
+// Less implements the heap.Interface.
+func (h expiryHeap) Less(i, j int) bool {
+	return h[i].expires.Before(h[j].expires)
+}
+
+// Swap implements the heap.Interface.
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+// Push implements the heap.Interface.
+func (h *expiryHeap) Push(x interface{}) {
+	entry := x.(*cacheEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+// Pop implements the heap.Interface.
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.index = -1
+	*h = old[:n-1]
+	return entry
 }
 
 // EOF
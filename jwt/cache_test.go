@@ -123,4 +123,173 @@ func TestCacheLoad(t *testing.T) {
 	}
 }
 
+// TestCacheLRU tests the eviction of the least recently used
+// tokens and the statistics.
+func TestCacheLRU(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing cache LRU eviction")
+	cache := jwt.NewCache(time.Minute, time.Minute, time.Minute, 3)
+	defer cache.Stop()
+	claims := initClaims()
+	tokens := make([]jwt.JWT, 5)
+	for i := range tokens {
+		key := []byte(fmt.Sprintf("secret-%d", i))
+		jwtIn, err := jwt.Encode(claims, key, jwt.HS512)
+		assert.Nil(err)
+		tokens[i] = jwtIn
+	}
+	for i := 0; i < 3; i++ {
+		assert.Equal(cache.Put(tokens[i]), i+1)
+	}
+	// Use the first token, so the second one is evicted.
+	_, ok := cache.Get(tokens[0].String())
+	assert.True(ok)
+	assert.Equal(cache.Put(tokens[3]), 3)
+	_, ok = cache.Get(tokens[1].String())
+	assert.False(ok)
+	assert.Equal(cache.Put(tokens[4]), 3)
+	_, ok = cache.Get(tokens[2].String())
+	assert.False(ok)
+	for _, i := range []int{0, 3, 4} {
+		_, ok = cache.Get(tokens[i].String())
+		assert.True(ok)
+	}
+	stats := statistics(cache)
+	assert.Equal(stats, jwt.CacheStatistics{
+		Hits:      4,
+		Misses:    2,
+		Evictions: 2,
+		Entries:   3,
+	})
+}
+
+// TestCacheExpiration tests the expiration based on the
+// tokens expiration time.
+func TestCacheExpiration(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing cache expiration")
+	cache := jwt.NewCache(time.Minute, 0, time.Minute, 10)
+	defer cache.Stop()
+	key := []byte("secret")
+	claims := initClaims()
+	claims.SetExpiration(time.Now().Add(time.Second))
+	expiring, err := jwt.Encode(claims, key, jwt.HS512)
+	assert.Nil(err)
+	lasting, err := jwt.Encode(initClaims(), key, jwt.HS512)
+	assert.Nil(err)
+	cache.Put(expiring)
+	assert.Equal(cache.Put(lasting), 2)
+	time.Sleep(1500 * time.Millisecond)
+	cache.Cleanup()
+	for i := 0; i < 10 && statistics(cache).Entries > 1; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(statistics(cache).Entries, 1)
+	_, ok := cache.Get(expiring.String())
+	assert.False(ok)
+	_, ok = cache.Get(lasting.String())
+	assert.True(ok)
+	assert.Equal(statistics(cache).Expirations, int64(1))
+}
+
+// TestCacheWithoutTTL tests that tokens stay cached without a TTL.
+func TestCacheWithoutTTL(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing cache without TTL")
+	cache := jwt.NewCacheWithConfig(&jwt.CacheConfig{
+		MaxEntries: 10,
+	})
+	defer cache.Stop()
+	jwtIn, err := jwt.Encode(initClaims(), []byte("secret"), jwt.HS512)
+	assert.Nil(err)
+	cache.Put(jwtIn)
+	cache.Cleanup()
+	_, ok := cache.Get(jwtIn.String())
+	assert.True(ok)
+	stats := statistics(cache)
+	assert.Equal(stats.Hits, int64(1))
+	assert.Equal(stats.Misses, int64(0))
+	assert.Equal(stats.Expirations, int64(0))
+	assert.Equal(stats.Entries, 1)
+}
+
+// TestCacheShards tests the cache with multiple shards.
+func TestCacheShards(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing sharded cache")
+	cache := jwt.NewCacheWithConfig(&jwt.CacheConfig{
+		TTL:        time.Minute,
+		Leeway:     time.Minute,
+		Interval:   time.Minute,
+		MaxEntries: 64,
+		Shards:     4,
+	})
+	defer cache.Stop()
+	claims := initClaims()
+	var tokens []jwt.JWT
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("secret-%d", i))
+		jwtIn, err := jwt.Encode(claims, key, jwt.HS512)
+		assert.Nil(err)
+		tokens = append(tokens, jwtIn)
+		assert.True(cache.Put(jwtIn) <= 64)
+	}
+	hits := 0
+	for _, token := range tokens {
+		if _, ok := cache.Get(token.String()); ok {
+			hits++
+		}
+	}
+	stats := statistics(cache)
+	assert.Equal(stats.Hits, int64(hits))
+	assert.Equal(stats.Misses, int64(100-hits))
+	assert.Equal(stats.Evictions, int64(100-stats.Entries))
+	assert.True(stats.Entries <= 64)
+}
+
+// TestCacheShardsBound tests that the sharded cache holds exactly
+// the maximum number of entries, also if it cannot be divided
+// evenly or there are more shards than entries.
+func TestCacheShardsBound(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	tests := []struct {
+		maxEntries int
+		shards     int
+	}{
+		{10, 4},
+		{3, 8},
+		{7, 7},
+	}
+	for _, test := range tests {
+		assert.Logf("testing %d entries in %d shards", test.maxEntries, test.shards)
+		cache := jwt.NewCacheWithConfig(&jwt.CacheConfig{
+			TTL:        time.Minute,
+			Leeway:     time.Minute,
+			MaxEntries: test.maxEntries,
+			Shards:     test.shards,
+		})
+		claims := initClaims()
+		for i := 0; i < 200; i++ {
+			jwtIn, err := jwt.Encode(claims, []byte(fmt.Sprintf("secret-%d", i)), jwt.HS512)
+			assert.Nil(err)
+			assert.True(cache.Put(jwtIn) <= test.maxEntries)
+		}
+		stats := statistics(cache)
+		assert.Equal(stats.Entries, test.maxEntries)
+		assert.Equal(stats.Evictions, int64(200-test.maxEntries))
+		// Without interval only manual cleanups are running.
+		cache.Cleanup()
+		assert.Nil(cache.Stop())
+	}
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// statistics returns the statistics of the cache.
+func statistics(cache jwt.Cache) jwt.CacheStatistics {
+	return cache.(jwt.StatisticsProvider).Statistics()
+}
+
 // EOF
@@ -0,0 +1,128 @@
+// Tideland GoREST - JSON Web Token - Certificate Chains
+//
+// Copyright (C) 2016-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package jwt
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+
+	"github.com/tideland/golib/errors"
+)
+
+//--------------------
+// CERTIFICATE POLICY
+//--------------------
+
+// CertificatePolicy controls the verification of the certificate chain
+// in the "x5c" header of a token. The chain has to lead to one of the
+// roots, they are needed as the system roots are never used. Further
+// intermediates are optional. The certificates have to be valid at the
+// time of the clock, by default the system time. The leaf has to allow
+// digital signatures if it restricts its key usage, and one of the
+// extended key usages, by default client authentication. If DNSName is
+// set the leaf has to be valid for it. Name constraints of the CAs are
+// always checked.
+type CertificatePolicy struct {
+	Roots         *x509.CertPool
+	Intermediates *x509.CertPool
+	KeyUsages     []x509.ExtKeyUsage
+	DNSName       string
+	Clock         Clock
+}
+
+// VerifyChain verifies the passed chain starting with the leaf and
+// returns the verified chain.
+func (p *CertificatePolicy) VerifyChain(chain []*x509.Certificate) ([]*x509.Certificate, error) {
+	if len(chain) == 0 {
+		return nil, errors.New(ErrNoCertificateChain, errorMessages)
+	}
+	if p.Roots == nil {
+		return nil, errors.New(ErrInvalidCertificateChain, errorMessages, "no roots")
+	}
+	leaf := chain[0]
+	if leaf.KeyUsage != 0 && leaf.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return nil, errors.New(ErrInvalidCertificateChain, errorMessages, "leaf does not allow digital signatures")
+	}
+	intermediates := x509.NewCertPool()
+	if p.Intermediates != nil {
+		intermediates = p.Intermediates.Clone()
+	}
+	for _, certificate := range chain[1:] {
+		intermediates.AddCert(certificate)
+	}
+	keyUsages := p.KeyUsages
+	if len(keyUsages) == 0 {
+		keyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	clock := p.Clock
+	if clock == nil {
+		clock = systemClock{}
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         p.Roots,
+		Intermediates: intermediates,
+		KeyUsages:     keyUsages,
+		DNSName:       p.DNSName,
+		CurrentTime:   clock.Now(),
+	})
+	if err != nil {
+		return nil, errors.Annotate(err, ErrInvalidCertificateChain, errorMessages, "verification failed")
+	}
+	return chains[0], nil
+}
+
+// leafKey verifies the encoded chain of a token header and returns
+// the public key of the leaf.
+func (p *CertificatePolicy) leafKey(encoded []string) (Key, error) {
+	chain, err := parseCertificateChain(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = p.VerifyChain(chain); err != nil {
+		return nil, err
+	}
+	return chain[0].PublicKey, nil
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// encodeCertificateChain encodes the chain for the "x5c" header.
+func encodeCertificateChain(chain []*x509.Certificate) []string {
+	encoded := make([]string, len(chain))
+	for i, certificate := range chain {
+		encoded[i] = base64.StdEncoding.EncodeToString(certificate.Raw)
+	}
+	return encoded
+}
+
+// parseCertificateChain parses the chain of the "x5c" header.
+func parseCertificateChain(encoded []string) ([]*x509.Certificate, error) {
+	if len(encoded) == 0 {
+		return nil, errors.New(ErrNoCertificateChain, errorMessages)
+	}
+	chain := make([]*x509.Certificate, len(encoded))
+	for i, value := range encoded {
+		der, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, errors.Annotate(err, ErrInvalidCertificateChain, errorMessages, "invalid encoding")
+		}
+		chain[i], err = x509.ParseCertificate(der)
+		if err != nil {
+			return nil, errors.Annotate(err, ErrInvalidCertificateChain, errorMessages, "invalid certificate")
+		}
+	}
+	return chain, nil
+}
+
+// EOF
@@ -0,0 +1,233 @@
+// Tideland GoREST - JSON Web Token - Unit Tests
+//
+// Copyright (C) 2016-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package jwt_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+
+	"github.com/tideland/gorest/jwt"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestCertificateChain tests the verification of tokens with
+// a certificate chain in the header.
+func TestCertificateChain(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing certificate chains")
+	root, rootKey := newCertificate(assert, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Root CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	intermediate, intermediateKey := newCertificate(assert, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Partner CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		PermittedDNSDomains:   []string{"partner.example.com"},
+	}, root, rootKey)
+	leaf, leafKey := newCertificate(assert, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "Signer"},
+		DNSNames:    []string{"signer.partner.example.com"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, intermediate, intermediateKey)
+	noSigningLeaf, noSigningKey := newCertificate(assert, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "Encipherer"},
+		DNSNames: []string{"encipherer.partner.example.com"},
+		KeyUsage: x509.KeyUsageKeyEncipherment,
+	}, intermediate, intermediateKey)
+	serverLeaf, serverKey := newCertificate(assert, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "Server"},
+		DNSNames:    []string{"server.partner.example.com"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, intermediate, intermediateKey)
+	outsideLeaf, outsideKey := newCertificate(assert, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "Outsider"},
+		DNSNames: []string{"signer.other.example.com"},
+		KeyUsage: x509.KeyUsageDigitalSignature,
+	}, intermediate, intermediateKey)
+	otherRoot, _ := newCertificate(assert, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Other CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(otherRoot)
+	tests := []struct {
+		description string
+		key         jwt.Key
+		chain       []*x509.Certificate
+		policy      *jwt.CertificatePolicy
+		err         string
+	}{
+		{
+			"valid chain",
+			leafKey, []*x509.Certificate{leaf, intermediate},
+			&jwt.CertificatePolicy{Roots: roots},
+			"",
+		}, {
+			"valid chain with extended key usage and name",
+			leafKey, []*x509.Certificate{leaf, intermediate},
+			&jwt.CertificatePolicy{
+				Roots:     roots,
+				KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+				DNSName:   "signer.partner.example.com",
+			},
+			"",
+		}, {
+			"intermediate in pool",
+			leafKey, []*x509.Certificate{leaf},
+			&jwt.CertificatePolicy{Roots: roots, Intermediates: newPool(intermediate)},
+			"",
+		}, {
+			"missing intermediate",
+			leafKey, []*x509.Certificate{leaf},
+			&jwt.CertificatePolicy{Roots: roots},
+			".*invalid certificate chain.*unknown authority.*",
+		}, {
+			"untrusted root",
+			leafKey, []*x509.Certificate{leaf, intermediate},
+			&jwt.CertificatePolicy{Roots: otherRoots},
+			".*invalid certificate chain.*unknown authority.*",
+		}, {
+			"expired",
+			leafKey, []*x509.Certificate{leaf, intermediate},
+			&jwt.CertificatePolicy{Roots: roots, Clock: jwt.ClockFunc(func() time.Time {
+				return time.Now().Add(48 * time.Hour)
+			})},
+			".*invalid certificate chain.*expired.*",
+		}, {
+			"wrong extended key usage",
+			leafKey, []*x509.Certificate{leaf, intermediate},
+			&jwt.CertificatePolicy{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}},
+			".*invalid certificate chain.*key usage.*",
+		}, {
+			"wrong name",
+			leafKey, []*x509.Certificate{leaf, intermediate},
+			&jwt.CertificatePolicy{Roots: roots, DNSName: "other.partner.example.com"},
+			".*invalid certificate chain.*other.partner.example.com.*",
+		}, {
+			"no digital signature",
+			noSigningKey, []*x509.Certificate{noSigningLeaf, intermediate},
+			&jwt.CertificatePolicy{Roots: roots},
+			".*leaf does not allow digital signatures.*",
+		}, {
+			"name constraints",
+			outsideKey, []*x509.Certificate{outsideLeaf, intermediate},
+			&jwt.CertificatePolicy{Roots: roots},
+			".*invalid certificate chain.*constraint.*",
+		}, {
+			"no client authentication by default",
+			serverKey, []*x509.Certificate{serverLeaf, intermediate},
+			&jwt.CertificatePolicy{Roots: roots},
+			".*invalid certificate chain.*key usage.*",
+		}, {
+			"explicit server authentication",
+			serverKey, []*x509.Certificate{serverLeaf, intermediate},
+			&jwt.CertificatePolicy{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}},
+			"",
+		}, {
+			"no roots",
+			leafKey, []*x509.Certificate{leaf, intermediate},
+			&jwt.CertificatePolicy{Intermediates: newPool(intermediate)},
+			".*invalid certificate chain: no roots.*",
+		},
+	}
+	for _, test := range tests {
+		assert.Logf("testing certificate chain: %s", test.description)
+		jwtEnc, err := jwt.EncodeWithCertificates(initClaims(), test.key, jwt.ES256, test.chain)
+		assert.Nil(err)
+		jwtVer, err := jwt.VerifyWith(jwtEnc.String(), jwt.WithCertificatePolicy(test.policy))
+		if test.err != "" {
+			assert.ErrorMatch(err, test.err)
+			continue
+		}
+		assert.Nil(err)
+		testClaims(assert, jwtVer.Claims())
+		verKey, err := jwtVer.Key()
+		assert.Nil(err)
+		assert.Equal(verKey, test.key.(*ecdsa.PrivateKey).Public())
+	}
+	// Roots are needed, system roots are not used.
+	jwtEnc, err := jwt.EncodeWithCertificates(initClaims(), leafKey, jwt.ES256, []*x509.Certificate{leaf, intermediate})
+	assert.Nil(err)
+	_, err = jwt.VerifyWith(jwtEnc.String(), jwt.WithCertificates(nil))
+	assert.ErrorMatch(err, ".*invalid certificate chain: no roots.*")
+	// Key has to match the leaf.
+	_, err = jwt.EncodeWithCertificates(initClaims(), intermediateKey, jwt.ES256, []*x509.Certificate{leaf, intermediate})
+	assert.ErrorMatch(err, ".*invalid certificate chain: key does not match leaf.*")
+	// Tokens without chain.
+	jwtEnc, err = jwt.EncodeWithKeyID(initClaims(), leafKey, jwt.ES256, "leaf")
+	assert.Nil(err)
+	_, err = jwt.VerifyWith(jwtEnc.String(), jwt.WithCertificates(roots))
+	assert.ErrorMatch(err, ".*token contains no certificate chain.*")
+	_, err = jwt.EncodeWithCertificates(initClaims(), leafKey, jwt.ES256, nil)
+	assert.ErrorMatch(err, ".*token contains no certificate chain.*")
+	// Allowed algorithms are still checked.
+	jwtEnc, err = jwt.EncodeWithCertificates(initClaims(), leafKey, jwt.ES256, []*x509.Certificate{leaf, intermediate})
+	assert.Nil(err)
+	_, err = jwt.VerifyWith(jwtEnc.String(), jwt.WithCertificates(newPool(root, intermediate)), jwt.WithAlgorithms(jwt.RS256))
+	assert.ErrorMatch(err, ".*algorithm \"ES256\" is not allowed.*")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// newCertificate creates a certificate based on the template valid
+// for one day. It is signed by the parent or self-signed.
+func newCertificate(assert audit.Assertion, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.Nil(err)
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(24 * time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	assert.Nil(err)
+	certificate, err := x509.ParseCertificate(der)
+	assert.Nil(err)
+	return certificate, key
+}
+
+// newPool creates a certificate pool with the certificates.
+func newPool(certificates ...*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, certificate := range certificates {
+		pool.AddCert(certificate)
+	}
+	return pool
+}
+
+// EOF
@@ -41,6 +41,34 @@ const (
 	ErrNoRSAKey
 	ErrNoAuthorizationHeader
 	ErrInvalidAuthorizationHeader
+	ErrNoIdentifier
+	ErrInvalidJWK
+	ErrNoMatchingKey
+	ErrCannotFetchJWKS
+	ErrAlgorithmNotAllowed
+	ErrKeyAlgorithmMismatch
+	ErrCannotParseEd25519
+	ErrNoEd25519Key
+	ErrCannotEncrypt
+	ErrCannotDecrypt
+	ErrInvalidEncryption
+	ErrNoNestedToken
+	ErrTokenExpired
+	ErrTokenNotYetValid
+	ErrTokenIssuedInFuture
+	ErrTokenTooOld
+	ErrInvalidIssuer
+	ErrInvalidAudience
+	ErrMissingClaim
+	ErrNoToken
+	ErrInvalidCSRFToken
+	ErrCannotGenerateKey
+	ErrInvalidKeySize
+	ErrCannotWritePEM
+	ErrCannotDecryptPEM
+	ErrNoCertificateChain
+	ErrInvalidCertificateChain
+	ErrInvalidClaim
 )
 
 var errorMessages = errors.Messages{
@@ -64,6 +92,34 @@ var errorMessages = errors.Messages{
 	ErrNoRSAKey:                   "passed key is no RSA key",
 	ErrNoAuthorizationHeader:      "request contains no authorization header",
 	ErrInvalidAuthorizationHeader: "invalid authorization header: '%s'",
+	ErrNoIdentifier:               "token contains no identifier",
+	ErrInvalidJWK:                 "invalid JSON Web Key: %s",
+	ErrNoMatchingKey:              "no matching key for key ID %q and algorithm %q",
+	ErrCannotFetchJWKS:            "cannot fetch JSON Web Key Set from %q",
+	ErrAlgorithmNotAllowed:        "algorithm %q is not allowed",
+	ErrKeyAlgorithmMismatch:       "key is bound to algorithm %q, not %q",
+	ErrCannotParseEd25519:         "cannot parse the Ed25519",
+	ErrNoEd25519Key:               "passed key is no Ed25519 key",
+	ErrCannotEncrypt:              "cannot encrypt the %s",
+	ErrCannotDecrypt:              "cannot decrypt the %s",
+	ErrInvalidEncryption:          "content encryption %q is invalid",
+	ErrNoNestedToken:              "encrypted token contains no signed token",
+	ErrTokenExpired:               "token is expired",
+	ErrTokenNotYetValid:           "token is not yet valid",
+	ErrTokenIssuedInFuture:        "token is issued in the future",
+	ErrTokenTooOld:                "token is older than %v",
+	ErrInvalidIssuer:              "issuer %q is not accepted",
+	ErrInvalidAudience:            "audience %v is not accepted",
+	ErrMissingClaim:               "required claim %q is missing",
+	ErrNoToken:                    "request contains no token",
+	ErrInvalidCSRFToken:           "missing or invalid CSRF token",
+	ErrCannotGenerateKey:          "cannot generate the %s key",
+	ErrInvalidKeySize:             "invalid key size %d",
+	ErrCannotWritePEM:             "cannot write the PEM",
+	ErrCannotDecryptPEM:           "cannot decrypt the PEM: %s",
+	ErrNoCertificateChain:         "token contains no certificate chain",
+	ErrInvalidCertificateChain:    "invalid certificate chain: %s",
+	ErrInvalidClaim:               "claim %q is invalid",
 }
 
 // EOF
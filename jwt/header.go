@@ -13,7 +13,6 @@ package jwt
 
 import (
 	"net/http"
-	"strings"
 
 	"github.com/tideland/golib/errors"
 
@@ -42,33 +41,92 @@ func AddToRequest(req *http.Request, jwt JWT) *http.Request {
 // DecodeFromRequest tries to retrieve a token from a request
 // header.
 func DecodeFromRequest(req *http.Request) (JWT, error) {
-	return decodeFromRequest(req, nil, nil)
+	return decodeFromRequest(req, nil, nil, nil)
 }
 
 // DecodeFromJob retrieves a possible JWT from
 // the request inside a REST job. The JWT is only decoded.
 func DecodeFromJob(job rest.Job) (JWT, error) {
-	return decodeFromRequest(job.Request(), nil, nil)
+	return decodeFromRequest(job.Request(), nil, nil, nil)
 }
 
 // DecodeCachedFromJob retrieves a possible JWT from the request
 // inside a REST job and checks if it already is cached. The JWT is
 // only decoded. In case of no error the token is added to the cache.
 func DecodeCachedFromJob(job rest.Job, cache Cache) (JWT, error) {
-	return decodeFromRequest(job.Request(), cache, nil)
+	return decodeFromRequest(job.Request(), cache, nil, nil)
+}
+
+// DecryptFromJob retrieves a possible encrypted JWT from the request
+// inside a REST job. The JWT is decrypted with the key but a nested
+// signed token is not verified. Unencrypted tokens are only decoded.
+func DecryptFromJob(job rest.Job, key Key) (JWT, error) {
+	return decodeFromRequest(job.Request(), nil, nil, &verifyOptions{decryptionKey: key})
+}
+
+// DecryptCachedFromJob retrieves a possible encrypted JWT from the
+// request inside a REST job and checks if it already is cached. The
+// JWT is decrypted like in DecryptFromJob(). In case of no error the
+// token is added to the cache.
+func DecryptCachedFromJob(job rest.Job, cache Cache, key Key) (JWT, error) {
+	return decodeFromRequest(job.Request(), cache, nil, &verifyOptions{decryptionKey: key})
+}
+
+// DecodeFromJobWith retrieves a possible JWT from the request inside
+// a REST job and checks if it already is cached. The cache is optional.
+// The JWT is only decoded, or decrypted with WithDecryptionKey(). Only
+// the options for decryption, validation, and token sources are used.
+// In case of no error the token is added to the cache.
+func DecodeFromJobWith(job rest.Job, cache Cache, options ...VerifyOption) (JWT, error) {
+	return decodeFromRequest(job.Request(), cache, nil, newVerifyOptions(options))
 }
 
 // VerifyFromJob retrieves a possible JWT from
 // the request inside a REST job. The JWT is verified.
 func VerifyFromJob(job rest.Job, key Key) (JWT, error) {
-	return decodeFromRequest(job.Request(), nil, key)
+	return VerifyFromJobWith(job, WithKey(key))
 }
 
 // VerifyCachedFromJob retrieves a possible JWT from the request
 // inside a REST job and checks if it already is cached. The JWT is
 // verified. In case of no error the token is added to the cache.
 func VerifyCachedFromJob(job rest.Job, cache Cache, key Key) (JWT, error) {
-	return decodeFromRequest(job.Request(), cache, key)
+	return VerifyCachedFromJobWith(job, cache, WithKey(key))
+}
+
+// VerifyResolvedFromJob retrieves a possible JWT from the request
+// inside a REST job. The JWT is verified with the key returned by
+// the resolver.
+func VerifyResolvedFromJob(job rest.Job, resolver KeyResolver) (JWT, error) {
+	return VerifyFromJobWith(job, WithKeyResolver(resolver))
+}
+
+// VerifyResolvedCachedFromJob retrieves a possible JWT from the request
+// inside a REST job and checks if it already is cached. The JWT is
+// verified with the key returned by the resolver. In case of no error
+// the token is added to the cache.
+func VerifyResolvedCachedFromJob(job rest.Job, cache Cache, resolver KeyResolver) (JWT, error) {
+	return VerifyCachedFromJobWith(job, cache, WithKeyResolver(resolver))
+}
+
+// VerifyFromJobWith retrieves a possible JWT from the request inside
+// a REST job. The JWT is verified based on the options like in
+// VerifyWith().
+func VerifyFromJobWith(job rest.Job, options ...VerifyOption) (JWT, error) {
+	return VerifyCachedFromJobWith(job, nil, options...)
+}
+
+// VerifyCachedFromJobWith retrieves a possible JWT from the request
+// inside a REST job and checks if it already is cached. The JWT is
+// verified based on the options like in VerifyWith(). Also the
+// algorithm of cached tokens is checked. In case of no error the
+// token is added to the cache.
+func VerifyCachedFromJobWith(job rest.Job, cache Cache, options ...VerifyOption) (JWT, error) {
+	vo := newVerifyOptions(options)
+	if vo.resolver == nil && vo.certificates == nil {
+		return nil, errors.New(ErrNoKey, errorMessages)
+	}
+	return decodeFromRequest(job.Request(), cache, vo.resolver, vo)
 }
 
 //--------------------
@@ -77,34 +135,48 @@ func VerifyCachedFromJob(job rest.Job, cache Cache, key Key) (JWT, error) {
 
 // decodeFromRequest is the generic decoder with possible
 // caching and verification.
-func decodeFromRequest(req *http.Request, cache Cache, key Key) (JWT, error) {
-	// Retrieve token from header.
-	authorization := req.Header.Get("Authorization")
-	if authorization == "" {
-		return nil, errors.New(ErrNoAuthorizationHeader, errorMessages)
+func decodeFromRequest(req *http.Request, cache Cache, resolver KeyResolver, vo *verifyOptions) (JWT, error) {
+	// Retrieve token from the sources.
+	var sources []TokenSource
+	if vo != nil {
+		sources = vo.sources
 	}
-	fields := strings.Fields(authorization)
-	if len(fields) != 2 || fields[0] != "Bearer" {
-		return nil, errors.New(ErrInvalidAuthorizationHeader, errorMessages, authorization)
+	token, err := tokenFromRequest(req, sources)
+	if err != nil {
+		return nil, err
 	}
+	verifying := resolver != nil || (vo != nil && vo.certificates != nil)
 	// Check cache.
 	if cache != nil {
-		jwt, ok := cache.Get(fields[1])
+		jwt, ok := cache.Get(token)
 		if ok {
+			if verifying && vo != nil && !vo.allows(jwt.Algorithm()) {
+				return nil, errors.New(ErrAlgorithmNotAllowed, errorMessages, jwt.Algorithm())
+			}
+			if err := vo.validate(jwt.Claims()); err != nil {
+				return nil, err
+			}
 			return jwt, nil
 		}
 	}
 	// Decode or verify.
 	var jwt JWT
-	var err error
-	if key == nil {
-		jwt, err = Decode(fields[1])
-	} else {
-		jwt, err = Verify(fields[1], key)
+	switch {
+	case verifying:
+		jwt, err = verify(token, resolver, vo)
+	case vo != nil && vo.decryptionKey != nil && isEncrypted(token):
+		jwt, err = Decrypt(token, vo.decryptionKey)
+	default:
+		jwt, err = Decode(token)
 	}
 	if err != nil {
 		return nil, err
 	}
+	if !verifying {
+		if err = vo.validate(jwt.Claims()); err != nil {
+			return nil, err
+		}
+	}
 	// Add to cache and return.
 	if cache != nil {
 		cache.Put(jwt)
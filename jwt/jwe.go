@@ -0,0 +1,483 @@
+// Tideland GoREST - JSON Web Token - Encryption
+//
+// Copyright (C) 2016-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package jwt
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/tideland/golib/errors"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// Key management algorithms for encrypted tokens. RSA keys are
+// used as *rsa.PublicKey for encryption and *rsa.PrivateKey for
+// decryption, the others need a []byte with a matching size.
+const (
+	RSAOAEP    Algorithm = "RSA-OAEP"
+	RSAOAEP256 Algorithm = "RSA-OAEP-256"
+	A128KW     Algorithm = "A128KW"
+	A256KW     Algorithm = "A256KW"
+	DIR        Algorithm = "dir"
+)
+
+// Encryption describes the content encryption of encrypted tokens.
+type Encryption string
+
+// Content encryption algorithms for encrypted tokens.
+const (
+	A128GCM      Encryption = "A128GCM"
+	A256GCM      Encryption = "A256GCM"
+	A128CBCHS256 Encryption = "A128CBC-HS256"
+)
+
+// keySize returns the size of the content encryption key.
+func (e Encryption) keySize() (int, error) {
+	switch e {
+	case A128GCM:
+		return 16, nil
+	case A256GCM, A128CBCHS256:
+		return 32, nil
+	}
+	return 0, errors.New(ErrInvalidEncryption, errorMessages, e)
+}
+
+//--------------------
+// ENCODING
+//--------------------
+
+// jweHeader is the protected header of an encrypted token.
+type jweHeader struct {
+	Algorithm   string `json:"alg"`
+	Encryption  string `json:"enc"`
+	Type        string `json:"typ,omitempty"`
+	ContentType string `json:"cty,omitempty"`
+}
+
+// EncodeEncrypted creates an encrypted JSON Web Token for the given
+// claims. The claims are not signed, only the key management algorithm
+// and the content encryption protect them.
+func EncodeEncrypted(claims Claims, key Key, algorithm Algorithm, encryption Encryption) (JWT, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return nil, errors.Annotate(err, ErrCannotEncrypt, errorMessages, "claims")
+	}
+	token, err := encrypt(payload, "", key, algorithm, encryption)
+	if err != nil {
+		return nil, err
+	}
+	return &jwt{
+		claims:    claims,
+		algorithm: algorithm,
+		token:     token,
+	}, nil
+}
+
+// EncodeNested encrypts an already signed JSON Web Token. So the
+// result is signed and encrypted. Claims, key, algorithm, and key ID
+// of the returned token are the ones of the signed token.
+func EncodeNested(signed JWT, key Key, algorithm Algorithm, encryption Encryption) (JWT, error) {
+	token, err := encrypt([]byte(signed.String()), "JWT", key, algorithm, encryption)
+	if err != nil {
+		return nil, err
+	}
+	signedKey, _ := signed.Key()
+	return &jwt{
+		claims:    signed.Claims(),
+		key:       signedKey,
+		algorithm: signed.Algorithm(),
+		keyID:     signed.KeyID(),
+		token:     token,
+	}, nil
+}
+
+// Decrypt creates a token out of an encrypted string without verifying
+// a nested signed token. For nested tokens the algorithm and key ID are
+// the ones of the signed token, otherwise the algorithm is the key
+// management algorithm.
+func Decrypt(token string, key Key) (JWT, error) {
+	header, payload, err := decrypt(token, key)
+	if err != nil {
+		return nil, err
+	}
+	if header.ContentType == "JWT" {
+		nested, err := Decode(string(payload))
+		if err != nil {
+			return nil, errors.Annotate(err, ErrCannotDecrypt, errorMessages, "nested token")
+		}
+		return &jwt{
+			claims:    nested.Claims(),
+			algorithm: nested.Algorithm(),
+			keyID:     nested.KeyID(),
+			token:     token,
+		}, nil
+	}
+	var claims Claims
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.Annotate(err, ErrCannotDecrypt, errorMessages, "claims")
+	}
+	return &jwt{
+		claims:    claims,
+		algorithm: Algorithm(header.Algorithm),
+		token:     token,
+	}, nil
+}
+
+// isEncrypted checks if the token uses the compact serialization
+// of encrypted tokens.
+func isEncrypted(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+//--------------------
+// PRIVATE HELPERS
+//--------------------
+
+// encrypt creates the compact serialization of the encrypted payload.
+func encrypt(payload []byte, contentType string, key Key, algorithm Algorithm, encryption Encryption) (string, error) {
+	size, err := encryption.keySize()
+	if err != nil {
+		return "", errors.Annotate(err, ErrCannotEncrypt, errorMessages, "content")
+	}
+	// Create and encrypt the content encryption key.
+	var cek, encryptedKey []byte
+	switch algorithm {
+	case DIR:
+		k, ok := key.([]byte)
+		if !ok {
+			return "", errors.New(ErrInvalidCombination, errorMessages, algorithm, "non-[]byte")
+		}
+		if len(k) != size {
+			return "", errors.New(ErrInvalidKeyType, errorMessages, key)
+		}
+		cek = k
+	case RSAOAEP, RSAOAEP256, A128KW, A256KW:
+		cek = make([]byte, size)
+		if _, err = io.ReadFull(rand.Reader, cek); err != nil {
+			return "", errors.Annotate(err, ErrCannotEncrypt, errorMessages, "key")
+		}
+		encryptedKey, err = wrapKey(cek, key, algorithm)
+		if err != nil {
+			return "", errors.Annotate(err, ErrCannotEncrypt, errorMessages, "key")
+		}
+	default:
+		return "", errors.New(ErrInvalidAlgorithm, errorMessages, algorithm)
+	}
+	// Encrypt the content.
+	typ := "JWT"
+	if contentType != "" {
+		typ = ""
+	}
+	headerPart, err := marshallAndEncode(jweHeader{string(algorithm), string(encryption), typ, contentType})
+	if err != nil {
+		return "", errors.Annotate(err, ErrCannotEncrypt, errorMessages, "header")
+	}
+	iv, ciphertext, tag, err := encryptContent(payload, []byte(headerPart), cek, encryption)
+	if err != nil {
+		return "", errors.Annotate(err, ErrCannotEncrypt, errorMessages, "content")
+	}
+	enc := base64.RawURLEncoding.EncodeToString
+	return strings.Join([]string{headerPart, enc(encryptedKey), enc(iv), enc(ciphertext), enc(tag)}, "."), nil
+}
+
+// decrypt returns header and payload of an encrypted token.
+func decrypt(token string, key Key) (*jweHeader, []byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return nil, nil, errors.New(ErrCannotDecrypt, errorMessages, "parts")
+	}
+	var header jweHeader
+	err := decodeAndUnmarshall(parts[0], &header)
+	if err != nil {
+		return nil, nil, errors.Annotate(err, ErrCannotDecrypt, errorMessages, "header")
+	}
+	var decoded [4][]byte
+	for i, part := range parts[1:] {
+		decoded[i], err = base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return nil, nil, errors.Annotate(err, ErrInvalidTokenPart, errorMessages)
+		}
+	}
+	encryption := Encryption(header.Encryption)
+	size, err := encryption.keySize()
+	if err != nil {
+		return nil, nil, errors.Annotate(err, ErrCannotDecrypt, errorMessages, "content")
+	}
+	// Retrieve the content encryption key.
+	var cek []byte
+	algorithm := Algorithm(header.Algorithm)
+	switch algorithm {
+	case DIR:
+		k, ok := key.([]byte)
+		if !ok {
+			return nil, nil, errors.New(ErrInvalidCombination, errorMessages, algorithm, "non-[]byte")
+		}
+		if len(decoded[0]) != 0 || len(k) != size {
+			return nil, nil, errors.New(ErrCannotDecrypt, errorMessages, "key")
+		}
+		cek = k
+	case RSAOAEP, RSAOAEP256, A128KW, A256KW:
+		cek, err = unwrapKey(decoded[0], key, algorithm)
+		if err != nil {
+			return nil, nil, errors.Annotate(err, ErrCannotDecrypt, errorMessages, "key")
+		}
+		// A failed unwrapping continues with a random key. So it
+		// cannot be distinguished from a failed decryption of the
+		// content (RFC 7516 section 11.5).
+		if len(cek) != size {
+			cek = make([]byte, size)
+			if _, err = io.ReadFull(rand.Reader, cek); err != nil {
+				return nil, nil, errors.Annotate(err, ErrCannotDecrypt, errorMessages, "key")
+			}
+		}
+	default:
+		return nil, nil, errors.New(ErrInvalidAlgorithm, errorMessages, algorithm)
+	}
+	// Decrypt the content.
+	payload, err := decryptContent(decoded[2], []byte(parts[0]), decoded[1], decoded[3], cek, encryption)
+	if err != nil {
+		return nil, nil, errors.Annotate(err, ErrCannotDecrypt, errorMessages, "content")
+	}
+	return &header, payload, nil
+}
+
+// wrapKey encrypts the content encryption key.
+func wrapKey(cek []byte, key Key, algorithm Algorithm) ([]byte, error) {
+	switch algorithm {
+	case RSAOAEP, RSAOAEP256:
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New(ErrInvalidCombination, errorMessages, algorithm, "non-RSA public")
+		}
+		return rsa.EncryptOAEP(oaepHash(algorithm), rand.Reader, publicKey, cek, nil)
+	default:
+		kek, err := keyWrapKey(key, algorithm)
+		if err != nil {
+			return nil, err
+		}
+		return aesKeyWrap(kek, cek)
+	}
+}
+
+// unwrapKey decrypts the content encryption key. Only invalid keys
+// lead to an error, a failed unwrapping returns no key.
+func unwrapKey(encryptedKey []byte, key Key, algorithm Algorithm) ([]byte, error) {
+	var cek []byte
+	var err error
+	switch algorithm {
+	case RSAOAEP, RSAOAEP256:
+		privateKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New(ErrInvalidCombination, errorMessages, algorithm, "non-RSA private")
+		}
+		cek, err = rsa.DecryptOAEP(oaepHash(algorithm), nil, privateKey, encryptedKey, nil)
+	default:
+		kek, kerr := keyWrapKey(key, algorithm)
+		if kerr != nil {
+			return nil, kerr
+		}
+		cek, err = aesKeyUnwrap(kek, encryptedKey)
+	}
+	if err != nil {
+		return nil, nil
+	}
+	return cek, nil
+}
+
+// oaepHash returns the hash for the RSA-OAEP algorithms.
+func oaepHash(algorithm Algorithm) hash.Hash {
+	if algorithm == RSAOAEP256 {
+		return sha256.New()
+	}
+	return sha1.New()
+}
+
+// keyWrapKey checks the key for the AES key wrap algorithms.
+func keyWrapKey(key Key, algorithm Algorithm) ([]byte, error) {
+	kek, ok := key.([]byte)
+	if !ok {
+		return nil, errors.New(ErrInvalidCombination, errorMessages, algorithm, "non-[]byte")
+	}
+	if (algorithm == A128KW && len(kek) != 16) || (algorithm == A256KW && len(kek) != 32) {
+		return nil, errors.New(ErrInvalidKeyType, errorMessages, key)
+	}
+	return kek, nil
+}
+
+// aesKeyWrapIV is the default initial value of RFC 3394.
+var aesKeyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+// aesKeyWrap wraps the key as defined in RFC 3394.
+func aesKeyWrap(kek, cek []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(cek) / 8
+	out := make([]byte, 8+len(cek))
+	copy(out, aesKeyWrapIV)
+	copy(out[8:], cek)
+	b := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b, out[:8])
+			copy(b[8:], out[i*8:i*8+8])
+			block.Encrypt(b, b)
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(out[:8], binary.BigEndian.Uint64(b[:8])^t)
+			copy(out[i*8:], b[8:])
+		}
+	}
+	return out, nil
+}
+
+// aesKeyUnwrap unwraps the key as defined in RFC 3394.
+func aesKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, errors.New(ErrInvalidTokenPart, errorMessages)
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(wrapped)/8 - 1
+	out := make([]byte, len(wrapped))
+	copy(out, wrapped)
+	b := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(out[:8])^t)
+			copy(b[8:], out[i*8:i*8+8])
+			block.Decrypt(b, b)
+			copy(out[:8], b[:8])
+			copy(out[i*8:], b[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(out[:8], aesKeyWrapIV) != 1 {
+		return nil, errors.New(ErrInvalidTokenPart, errorMessages)
+	}
+	return out[8:], nil
+}
+
+// encryptContent encrypts the payload and returns initialization
+// vector, ciphertext, and authentication tag.
+func encryptContent(payload, aad, cek []byte, encryption Encryption) ([]byte, []byte, []byte, error) {
+	switch encryption {
+	case A128GCM, A256GCM:
+		block, err := aes.NewCipher(cek)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		iv := make([]byte, gcm.NonceSize())
+		if _, err = io.ReadFull(rand.Reader, iv); err != nil {
+			return nil, nil, nil, err
+		}
+		sealed := gcm.Seal(nil, iv, payload, aad)
+		split := len(sealed) - gcm.Overhead()
+		return iv, sealed[:split], sealed[split:], nil
+	default:
+		block, err := aes.NewCipher(cek[16:])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		iv := make([]byte, aes.BlockSize)
+		if _, err = io.ReadFull(rand.Reader, iv); err != nil {
+			return nil, nil, nil, err
+		}
+		padding := aes.BlockSize - len(payload)%aes.BlockSize
+		ciphertext := make([]byte, len(payload)+padding)
+		copy(ciphertext, payload)
+		for i := len(payload); i < len(ciphertext); i++ {
+			ciphertext[i] = byte(padding)
+		}
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+		return iv, ciphertext, cbcTag(cek[:16], aad, iv, ciphertext), nil
+	}
+}
+
+// decryptContent checks the authentication tag and decrypts
+// the ciphertext.
+func decryptContent(ciphertext, aad, iv, tag, cek []byte, encryption Encryption) ([]byte, error) {
+	switch encryption {
+	case A128GCM, A256GCM:
+		block, err := aes.NewCipher(cek)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		if len(iv) != gcm.NonceSize() || len(tag) != gcm.Overhead() {
+			return nil, errors.New(ErrInvalidTokenPart, errorMessages)
+		}
+		return gcm.Open(nil, iv, append(ciphertext[:len(ciphertext):len(ciphertext)], tag...), aad)
+	default:
+		if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+			return nil, errors.New(ErrInvalidTokenPart, errorMessages)
+		}
+		if !hmac.Equal(tag, cbcTag(cek[:16], aad, iv, ciphertext)) {
+			return nil, errors.New(ErrInvalidSignature, errorMessages)
+		}
+		block, err := aes.NewCipher(cek[16:])
+		if err != nil {
+			return nil, err
+		}
+		payload := make([]byte, len(ciphertext))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(payload, ciphertext)
+		padding := int(payload[len(payload)-1])
+		if padding == 0 || padding > aes.BlockSize {
+			return nil, errors.New(ErrInvalidTokenPart, errorMessages)
+		}
+		for _, b := range payload[len(payload)-padding:] {
+			if int(b) != padding {
+				return nil, errors.New(ErrInvalidTokenPart, errorMessages)
+			}
+		}
+		return payload[:len(payload)-padding], nil
+	}
+}
+
+// cbcTag calculates the authentication tag of AES-CBC with
+// HMAC-SHA-256.
+func cbcTag(macKey, aad, iv, ciphertext []byte) []byte {
+	al := make([]byte, 8)
+	binary.BigEndian.PutUint64(al, uint64(len(aad))*8)
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(aad)
+	mac.Write(iv)
+	mac.Write(ciphertext)
+	mac.Write(al)
+	return mac.Sum(nil)[:16]
+}
+
+// EOF
@@ -0,0 +1,158 @@
+// Tideland GoREST - JSON Web Token - Unit Tests
+//
+// Copyright (C) 2016-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package jwt_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+
+	"github.com/tideland/golib/audit"
+
+	"github.com/tideland/gorest/jwt"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestEncryption tests the combinations of key management and
+// content encryption algorithms.
+func TestEncryption(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+	key16 := []byte("0123456789abcdef")
+	key32 := []byte("0123456789abcdef0123456789abcdef")
+	tests := []struct {
+		algorithm  jwt.Algorithm
+		encryption jwt.Encryption
+		encryptKey jwt.Key
+		decryptKey jwt.Key
+	}{
+		{jwt.RSAOAEP, jwt.A128GCM, rsaKey.Public(), rsaKey},
+		{jwt.RSAOAEP256, jwt.A256GCM, rsaKey.Public(), rsaKey},
+		{jwt.RSAOAEP256, jwt.A128CBCHS256, rsaKey.Public(), rsaKey},
+		{jwt.A128KW, jwt.A128GCM, key16, key16},
+		{jwt.A256KW, jwt.A256GCM, key32, key32},
+		{jwt.A256KW, jwt.A128CBCHS256, key32, key32},
+		{jwt.DIR, jwt.A128GCM, key16, key16},
+		{jwt.DIR, jwt.A128CBCHS256, key32, key32},
+	}
+	for _, test := range tests {
+		assert.Logf("testing encryption %q with %q", test.algorithm, test.encryption)
+		jwtEnc, err := jwt.EncodeEncrypted(initClaims(), test.encryptKey, test.algorithm, test.encryption)
+		assert.Nil(err)
+		assert.Length(strings.Split(jwtEnc.String(), "."), 5)
+		assert.Equal(jwtEnc.Algorithm(), test.algorithm)
+		jwtDec, err := jwt.Decrypt(jwtEnc.String(), test.decryptKey)
+		assert.Nil(err)
+		assert.Equal(jwtDec.String(), jwtEnc.String())
+		assert.Equal(jwtDec.Algorithm(), test.algorithm)
+		testClaims(assert, jwtDec.Claims())
+		// Manipulated tokens are rejected.
+		parts := strings.Split(jwtEnc.String(), ".")
+		ciphertext := []byte(parts[3])
+		if ciphertext[0] == 'A' {
+			ciphertext[0] = 'B'
+		} else {
+			ciphertext[0] = 'A'
+		}
+		parts[3] = string(ciphertext)
+		_, err = jwt.Decrypt(strings.Join(parts, "."), test.decryptKey)
+		assert.ErrorMatch(err, ".*cannot decrypt the .*")
+	}
+}
+
+// TestEncryptionErrors tests invalid keys and algorithms.
+func TestEncryptionErrors(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing encryption errors")
+	key16 := []byte("0123456789abcdef")
+	key32 := []byte("0123456789abcdef0123456789abcdef")
+	_, err := jwt.EncodeEncrypted(initClaims(), key32, jwt.A128KW, jwt.A128GCM)
+	assert.ErrorMatch(err, ".*key type .* is invalid.*")
+	_, err = jwt.EncodeEncrypted(initClaims(), key16, jwt.DIR, jwt.A256GCM)
+	assert.ErrorMatch(err, ".*key type .* is invalid.*")
+	_, err = jwt.EncodeEncrypted(initClaims(), "key", jwt.RSAOAEP, jwt.A256GCM)
+	assert.ErrorMatch(err, ".*invalid combination of algorithm .*")
+	_, err = jwt.EncodeEncrypted(initClaims(), key16, jwt.A128KW, jwt.Encryption("A192GCM"))
+	assert.ErrorMatch(err, ".*content encryption \"A192GCM\" is invalid.*")
+	_, err = jwt.EncodeEncrypted(initClaims(), key16, jwt.HS256, jwt.A128GCM)
+	assert.ErrorMatch(err, ".*signature algorithm \"HS256\" is invalid.*")
+	// Decrypt with a wrong key.
+	jwtEnc, err := jwt.EncodeEncrypted(initClaims(), key16, jwt.A128KW, jwt.A128GCM)
+	assert.Nil(err)
+	_, err = jwt.Decrypt(jwtEnc.String(), []byte("fedcba9876543210"))
+	assert.ErrorMatch(err, ".*cannot decrypt the content.*")
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+	jwtEnc, err = jwt.EncodeEncrypted(initClaims(), rsaKey.Public(), jwt.RSAOAEP256, jwt.A256GCM)
+	assert.Nil(err)
+	_, err = jwt.Decrypt(jwtEnc.String(), otherKey)
+	assert.ErrorMatch(err, ".*cannot decrypt the content.*")
+	// Manipulated encrypted keys fail like the content.
+	parts := strings.Split(jwtEnc.String(), ".")
+	parts[1] = parts[1][:len(parts[1])-8]
+	_, err = jwt.Decrypt(strings.Join(parts, "."), rsaKey)
+	assert.ErrorMatch(err, ".*cannot decrypt the content.*")
+	_, err = jwt.Decrypt("a.b.c", key16)
+	assert.ErrorMatch(err, ".*cannot decrypt the parts.*")
+}
+
+// TestNestedEncryption tests signed and then encrypted tokens.
+func TestNestedEncryption(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing nested encryption")
+	signKey := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+	signed, err := jwt.EncodeWithKeyID(initClaims(), signKey, jwt.HS512, "key-1")
+	assert.Nil(err)
+	jwtEnc, err := jwt.EncodeNested(signed, rsaKey.Public(), jwt.RSAOAEP256, jwt.A256GCM)
+	assert.Nil(err)
+	assert.Equal(jwtEnc.Algorithm(), jwt.HS512)
+	assert.Equal(jwtEnc.KeyID(), "key-1")
+	// Decrypt only.
+	jwtDec, err := jwt.Decrypt(jwtEnc.String(), rsaKey)
+	assert.Nil(err)
+	assert.Equal(jwtDec.Algorithm(), jwt.HS512)
+	assert.Equal(jwtDec.KeyID(), "key-1")
+	testClaims(assert, jwtDec.Claims())
+	// Decrypt and verify.
+	jwtVer, err := jwt.VerifyWith(jwtEnc.String(), jwt.WithKey(signKey), jwt.WithDecryptionKey(rsaKey))
+	assert.Nil(err)
+	assert.Equal(jwtVer.String(), jwtEnc.String())
+	assert.Equal(jwtVer.Algorithm(), jwt.HS512)
+	testClaims(assert, jwtVer.Claims())
+	_, err = jwt.VerifyWith(jwtEnc.String(), jwt.WithKey([]byte("wrong")), jwt.WithDecryptionKey(rsaKey))
+	assert.ErrorMatch(err, ".*cannot verify the signature.*")
+	_, err = jwt.VerifyWith(jwtEnc.String(), jwt.WithKey(signKey))
+	assert.ErrorMatch(err, ".*cannot verify the parts.*")
+	// Unsigned nested tokens are rejected by default.
+	unsigned, err := jwt.Encode(initClaims(), "", jwt.NONE)
+	assert.Nil(err)
+	jwtEnc, err = jwt.EncodeNested(unsigned, rsaKey.Public(), jwt.RSAOAEP256, jwt.A256GCM)
+	assert.Nil(err)
+	_, err = jwt.VerifyWith(jwtEnc.String(), jwt.WithKey(""), jwt.WithDecryptionKey(rsaKey))
+	assert.ErrorMatch(err, ".*algorithm \"none\" is not allowed.*")
+	// Encrypted claims without signature cannot be verified.
+	jwtEnc, err = jwt.EncodeEncrypted(initClaims(), rsaKey.Public(), jwt.RSAOAEP256, jwt.A256GCM)
+	assert.Nil(err)
+	_, err = jwt.VerifyWith(jwtEnc.String(), jwt.WithKey(signKey), jwt.WithDecryptionKey(rsaKey))
+	assert.ErrorMatch(err, ".*encrypted token contains no signed token.*")
+}
+
+// EOF
@@ -0,0 +1,378 @@
+// Tideland GoREST - JSON Web Token - JSON Web Keys
+//
+// Copyright (C) 2016-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package jwt
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tideland/golib/errors"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// Key types of JSON Web Keys.
+const (
+	KeyTypeRSA = "RSA"
+	KeyTypeEC  = "EC"
+	KeyTypeOKP = "OKP"
+	KeyTypeOct = "oct"
+)
+
+//--------------------
+// JSON WEB KEY
+//--------------------
+
+// JWK is a JSON Web Key as defined in RFC 7517. Supported are
+// RSA, EC, and Ed25519 (OKP) public keys as well as symmetric keys.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	K         string `json:"k,omitempty"`
+}
+
+// Key returns the key usable for verification, a *rsa.PublicKey,
+// a *ecdsa.PublicKey, an ed25519.PublicKey, or a []byte.
+func (jwk *JWK) Key() (Key, error) {
+	switch jwk.KeyType {
+	case KeyTypeRSA:
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, errors.Annotate(err, ErrInvalidJWK, errorMessages, "RSA modulus")
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, errors.Annotate(err, ErrInvalidJWK, errorMessages, "RSA exponent")
+		}
+		if n.Sign() <= 0 || !e.IsInt64() || e.Int64() < 2 || e.Int64() > 1<<31-1 {
+			return nil, errors.New(ErrInvalidJWK, errorMessages, "RSA values")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case KeyTypeEC:
+		var curve elliptic.Curve
+		switch jwk.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New(ErrInvalidJWK, errorMessages, "curve "+jwk.Curve)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, errors.Annotate(err, ErrInvalidJWK, errorMessages, "EC x")
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, errors.Annotate(err, ErrInvalidJWK, errorMessages, "EC y")
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New(ErrInvalidJWK, errorMessages, "EC point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case KeyTypeOKP:
+		if jwk.Curve != "Ed25519" {
+			return nil, errors.New(ErrInvalidJWK, errorMessages, "curve "+jwk.Curve)
+		}
+		x, err := decodeBase64URL(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New(ErrInvalidJWK, errorMessages, "Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	case KeyTypeOct:
+		k, err := decodeBase64URL(jwk.K)
+		if err != nil || len(k) == 0 {
+			return nil, errors.New(ErrInvalidJWK, errorMessages, "symmetric key")
+		}
+		return k, nil
+	}
+	return nil, errors.New(ErrInvalidJWK, errorMessages, "key type "+jwk.KeyType)
+}
+
+// matches checks if the key can be used for the key ID and
+// the algorithm.
+func (jwk *JWK) matches(keyID string, algorithm Algorithm) bool {
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return false
+	}
+	if keyID != "" && jwk.KeyID != keyID {
+		return false
+	}
+	if jwk.Algorithm != "" && jwk.Algorithm != string(algorithm) {
+		return false
+	}
+	if algorithm == "" {
+		return false
+	}
+	switch jwk.KeyType {
+	case KeyTypeRSA:
+		return algorithm[0] == 'R' || algorithm[0] == 'P'
+	case KeyTypeEC:
+		return algorithm[0] == 'E' && algorithm != EdDSA
+	case KeyTypeOKP:
+		return algorithm == EdDSA
+	case KeyTypeOct:
+		return algorithm[0] == 'H'
+	}
+	return false
+}
+
+//--------------------
+// JSON WEB KEY SET
+//--------------------
+
+// JWKS is a JSON Web Key Set. It can be used as KeyResolver.
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// ParseJWKS parses a JSON Web Key Set. Keys not used for signatures
+// or not usable, e.g. of unsupported types or curves, are ignored.
+// So only malformed JSON leads to an error.
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var raw JWKS
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, errors.Annotate(err, ErrJSONUnmarshalling, errorMessages)
+	}
+	jwks := &JWKS{}
+	for _, jwk := range raw.Keys {
+		if jwk == nil || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		if _, err := jwk.Key(); err != nil {
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks, nil
+}
+
+// ResolveKey implements the KeyResolver interface. Without a key ID
+// the first key matching the algorithm is returned.
+func (jwks *JWKS) ResolveKey(keyID string, algorithm Algorithm) (Key, error) {
+	for _, jwk := range jwks.Keys {
+		if jwk.matches(keyID, algorithm) {
+			return jwk.Key()
+		}
+	}
+	return nil, errors.New(ErrNoMatchingKey, errorMessages, keyID, algorithm)
+}
+
+//--------------------
+// JWKS RESOLVER
+//--------------------
+
+// JWKSResolver resolves keys out of a JSON Web Key Set fetched
+// from a URL.
+type JWKSResolver interface {
+	KeyResolver
+
+	// Refresh fetches the key set again.
+	Refresh() error
+}
+
+// JWKSResolverConfig allows to control the JWKS resolver. All values
+// are optional. By default a HTTP client with a timeout of ten seconds
+// is used, the key set is fetched again after one hour and, if a key
+// ID is unknown, at most once per minute.
+type JWKSResolverConfig struct {
+	Client             *http.Client
+	RefreshInterval    time.Duration
+	MinRefreshInterval time.Duration
+}
+
+// maxJWKSSize is the maximum size of a fetched key set.
+const maxJWKSSize = 1 << 20
+
+// jwksFetch is a running fetch of the key set. Concurrent
+// resolvings wait for its result.
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// jwksResolver implements JWKSResolver.
+type jwksResolver struct {
+	mutex              sync.Mutex
+	url                string
+	client             *http.Client
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+	jwks               *JWKS
+	fetched            time.Time
+	fetches            int
+	err                error
+	fetch              *jwksFetch
+}
+
+// NewJWKSResolver creates a resolver for the JSON Web Key Set at
+// the passed URL. The set is fetched at the first resolving and then
+// cached. Unknown key IDs lead to a new fetching, e.g. after a key
+// rotation. Failing fetches keep the old key set. Only one fetch runs
+// at a time, the lock isn't held while fetching. Resolvings racing
+// with a fetch use its result instead of fetching again.
+func NewJWKSResolver(url string, config *JWKSResolverConfig) JWKSResolver {
+	r := &jwksResolver{
+		url:                url,
+		client:             &http.Client{Timeout: 10 * time.Second},
+		refreshInterval:    time.Hour,
+		minRefreshInterval: time.Minute,
+	}
+	if config != nil {
+		if config.Client != nil {
+			r.client = config.Client
+		}
+		if config.RefreshInterval > 0 {
+			r.refreshInterval = config.RefreshInterval
+		}
+		if config.MinRefreshInterval > 0 {
+			r.minRefreshInterval = config.MinRefreshInterval
+		}
+	}
+	return r
+}
+
+// ResolveKey implements the KeyResolver interface.
+func (r *jwksResolver) ResolveKey(keyID string, algorithm Algorithm) (Key, error) {
+	jwks, fetched, fetches := r.current()
+	if jwks == nil || time.Since(fetched) > r.refreshInterval {
+		err := r.refresh(fetches)
+		if jwks, fetched, fetches = r.current(); jwks == nil {
+			return nil, err
+		}
+	}
+	key, err := jwks.ResolveKey(keyID, algorithm)
+	if err != nil && time.Since(fetched) > r.minRefreshInterval {
+		if r.refresh(fetches) == nil {
+			jwks, _, _ = r.current()
+			key, err = jwks.ResolveKey(keyID, algorithm)
+		}
+	}
+	return key, err
+}
+
+// Refresh implements the JWKSResolver interface. If a fetch is
+// already running its result is returned.
+func (r *jwksResolver) Refresh() error {
+	return r.refresh(-1)
+}
+
+// refresh fetches the key set. If a fetch is running its result is
+// returned. If one has finished since the caller has seen the passed
+// number of fetches its result is returned too. So callers racing
+// with a fetch don't start another one.
+func (r *jwksResolver) refresh(seen int) error {
+	r.mutex.Lock()
+	if f := r.fetch; f != nil {
+		r.mutex.Unlock()
+		<-f.done
+		return f.err
+	}
+	if seen >= 0 && r.fetches != seen {
+		err := r.err
+		r.mutex.Unlock()
+		return err
+	}
+	f := &jwksFetch{done: make(chan struct{})}
+	r.fetch = f
+	r.mutex.Unlock()
+	jwks, err := r.fetchJWKS()
+	r.mutex.Lock()
+	r.fetched = time.Now()
+	r.fetches++
+	r.err = err
+	if err == nil {
+		r.jwks = jwks
+	}
+	r.fetch = nil
+	r.mutex.Unlock()
+	f.err = err
+	close(f.done)
+	return err
+}
+
+// current returns the key set, the time of the last fetch, and
+// the number of fetches.
+func (r *jwksResolver) current() (*JWKS, time.Time, int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.jwks, r.fetched, r.fetches
+}
+
+// fetchJWKS retrieves the key set.
+func (r *jwksResolver) fetchJWKS() (*JWKS, error) {
+	resp, err := r.client.Get(r.url)
+	if err != nil {
+		return nil, errors.Annotate(err, ErrCannotFetchJWKS, errorMessages, r.url)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(ErrCannotFetchJWKS, errorMessages, r.url)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxJWKSSize+1))
+	if err != nil {
+		return nil, errors.Annotate(err, ErrCannotFetchJWKS, errorMessages, r.url)
+	}
+	if len(data) > maxJWKSSize {
+		return nil, errors.New(ErrCannotFetchJWKS, errorMessages, r.url)
+	}
+	jwks, err := ParseJWKS(data)
+	if err != nil {
+		return nil, errors.Annotate(err, ErrCannotFetchJWKS, errorMessages, r.url)
+	}
+	return jwks, nil
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// decodeBase64URL decodes a base64url value with or without padding.
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// decodeBigInt decodes a base64url encoded big-endian integer.
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := decodeBase64URL(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New(ErrInvalidJWK, errorMessages, "empty integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// EOF
@@ -0,0 +1,270 @@
+// Tideland GoREST - JSON Web Token - Unit Tests
+//
+// Copyright (C) 2016-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package jwt_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+
+	"github.com/tideland/gorest/jwt"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestEncodeWithKeyID tests the setting of the key ID in the header.
+func TestEncodeWithKeyID(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing encoding with key ID")
+	key := []byte("secret")
+	jwtEnc, err := jwt.EncodeWithKeyID(initClaims(), key, jwt.HS512, "key-1")
+	assert.Nil(err)
+	assert.Equal(jwtEnc.KeyID(), "key-1")
+	jwtDec, err := jwt.Decode(jwtEnc.String())
+	assert.Nil(err)
+	assert.Equal(jwtDec.KeyID(), "key-1")
+	resolver := jwt.KeyResolverFunc(func(keyID string, algorithm jwt.Algorithm) (jwt.Key, error) {
+		assert.Equal(keyID, "key-1")
+		assert.Equal(algorithm, jwt.HS512)
+		return key, nil
+	})
+	jwtVer, err := jwt.VerifyWithResolver(jwtEnc.String(), resolver)
+	assert.Nil(err)
+	assert.Equal(jwtVer.KeyID(), "key-1")
+	testClaims(assert, jwtVer.Claims())
+	// Tokens without key ID keep their header.
+	jwtEnc, err = jwt.Encode(initClaims(), key, jwt.HS512)
+	assert.Nil(err)
+	assert.Equal(jwtEnc.KeyID(), "")
+}
+
+// TestJWKS tests the parsing of JSON Web Key Sets and the
+// resolving of keys.
+func TestJWKS(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing JSON Web Key Sets")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(err)
+	edPublicKey, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(err)
+	hmacKey := []byte("secret")
+	jwks, err := jwt.ParseJWKS([]byte(jwksJSON(rsaKey, ecKey, edPublicKey, hmacKey)))
+	assert.Nil(err)
+	assert.Length(jwks.Keys, 4)
+	// Verify tokens signed by the different keys.
+	tests := []struct {
+		keyID     string
+		key       jwt.Key
+		algorithm jwt.Algorithm
+		err       string
+	}{
+		{"rsa-1", rsaKey, jwt.RS256, ""},
+		{"rsa-1", rsaKey, jwt.PS512, ""},
+		{"ec-1", ecKey, jwt.ES256, ""},
+		{"ed-1", edKey, jwt.EdDSA, ""},
+		{"", edKey, jwt.EdDSA, ""},
+		{"ec-1", edKey, jwt.EdDSA, ".*no matching key.*"},
+		{"oct-1", hmacKey, jwt.HS256, ""},
+		{"", hmacKey, jwt.HS256, ""},
+		{"oct-1", hmacKey, jwt.HS512, ".*no matching key.*"},
+		{"rsa-1", hmacKey, jwt.HS256, ".*no matching key.*"},
+		{"unknown", hmacKey, jwt.HS256, ".*no matching key.*"},
+	}
+	for i, test := range tests {
+		assert.Logf("JWKS test #%d: %s %s", i, test.keyID, test.algorithm)
+		jwtEnc, err := jwt.EncodeWithKeyID(initClaims(), test.key, test.algorithm, test.keyID)
+		assert.Nil(err)
+		jwtVer, err := jwt.VerifyWithResolver(jwtEnc.String(), jwks)
+		if test.err != "" {
+			assert.ErrorMatch(err, test.err)
+			continue
+		}
+		assert.Nil(err)
+		testClaims(assert, jwtVer.Claims())
+	}
+	// Invalid keys and unsupported key types are ignored, only
+	// invalid JSON is an error.
+	jwks, err = jwt.ParseJWKS([]byte(`{"keys":[
+		{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"},
+		{"kty":"RSA","n":"","e":"AQAB"},
+		{"kty":"OKP","crv":"X25519","x":"AQ","use":"enc"},
+		{"kty":"EC","crv":"secp256k1","x":"AQ","y":"AQ"},
+		{"kty":"XYZ"},
+		{"kty":"oct","k":"c2VjcmV0","use":"enc"},
+		{"kty":"oct","k":"c2VjcmV0","kid":"hmac","use":"sig"}
+	]}`))
+	assert.Nil(err)
+	assert.Length(jwks.Keys, 1)
+	key, err := jwks.ResolveKey("hmac", jwt.HS256)
+	assert.Nil(err)
+	assert.Equal(key, []byte("secret"))
+	_, err = jwt.ParseJWKS([]byte(`{"keys":[{"kty":"oct"`))
+	assert.ErrorMatch(err, ".*error unmarshalling from JSON.*")
+	// The keys themselves report why they are not usable.
+	jwk := &jwt.JWK{KeyType: jwt.KeyTypeEC, Curve: "P-256", X: "AQ", Y: "AQ"}
+	_, err = jwk.Key()
+	assert.ErrorMatch(err, ".*invalid JSON Web Key: EC point not on curve.*")
+	jwk = &jwt.JWK{KeyType: jwt.KeyTypeOKP, Curve: "X25519", X: "AQ"}
+	_, err = jwk.Key()
+	assert.ErrorMatch(err, ".*invalid JSON Web Key: curve X25519.*")
+	_, err = jwks.ResolveKey("", jwt.RS256)
+	assert.ErrorMatch(err, ".*no matching key.*")
+}
+
+// TestJWKSResolver tests the fetching of key sets.
+func TestJWKSResolver(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing JWKS resolver")
+	var mutex sync.Mutex
+	fetches := 0
+	status := http.StatusOK
+	body := `{"keys":[{"kty":"oct","kid":"key-1","k":"` + base64.RawURLEncoding.EncodeToString([]byte("one")) + `"}]}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		fetches++
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+	resolver := jwt.NewJWKSResolver(ts.URL, &jwt.JWKSResolverConfig{
+		MinRefreshInterval: 1,
+	})
+	verify := func(key []byte, keyID string) error {
+		jwtEnc, err := jwt.EncodeWithKeyID(initClaims(), key, jwt.HS256, keyID)
+		assert.Nil(err)
+		_, err = jwt.VerifyWithResolver(jwtEnc.String(), resolver)
+		return err
+	}
+	// First verifications fetch once.
+	assert.Nil(verify([]byte("one"), "key-1"))
+	assert.Nil(verify([]byte("one"), "key-1"))
+	assert.Equal(fetches, 1)
+	// Rotated key leads to fetching.
+	mutex.Lock()
+	body = `{"keys":[{"kty":"oct","kid":"key-2","k":"` + base64.RawURLEncoding.EncodeToString([]byte("two")) + `"}]}`
+	mutex.Unlock()
+	assert.Nil(verify([]byte("two"), "key-2"))
+	assert.Equal(fetches, 2)
+	// Failing fetches keep the old set.
+	mutex.Lock()
+	status = http.StatusInternalServerError
+	mutex.Unlock()
+	err := resolver.Refresh()
+	assert.ErrorMatch(err, ".*cannot fetch JSON Web Key Set.*")
+	assert.Nil(verify([]byte("two"), "key-2"))
+	assert.ErrorMatch(verify([]byte("one"), "key-1"), ".*no matching key.*")
+}
+
+// TestJWKSResolverFetching tests concurrent, hanging, and
+// oversized fetches of key sets.
+func TestJWKSResolverFetching(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing JWKS resolver fetching")
+	var fetches int32
+	release := make(chan struct{})
+	body := `{"keys":[{"kty":"oct","kid":"key-1","k":"` + base64.RawURLEncoding.EncodeToString([]byte("one")) + `"}]}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		switch r.URL.Path {
+		case "/slow":
+			<-release
+			fmt.Fprint(w, body)
+		case "/hanging":
+			select {
+			case <-release:
+			case <-time.After(time.Second):
+			}
+		case "/large":
+			fmt.Fprint(w, `{"keys":[],"padding":"`+strings.Repeat("x", 2<<20)+`"}`)
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+	// Concurrent resolvings fetch only once, also those starting
+	// after the fetch has finished.
+	resolver := jwt.NewJWKSResolver(ts.URL+"/slow", nil)
+	var started, wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		started.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			_, err := resolver.ResolveKey("key-1", jwt.HS256)
+			assert.Nil(err)
+		}()
+	}
+	started.Wait()
+	release <- struct{}{}
+	wg.Wait()
+	assert.Equal(atomic.LoadInt32(&fetches), int32(1))
+	// Hanging endpoints time out.
+	resolver = jwt.NewJWKSResolver(ts.URL+"/hanging", &jwt.JWKSResolverConfig{
+		Client: &http.Client{Timeout: 50 * time.Millisecond},
+	})
+	_, err := resolver.ResolveKey("key-1", jwt.HS256)
+	assert.ErrorMatch(err, ".*cannot fetch JSON Web Key Set.*")
+	// Too large key sets are rejected.
+	resolver = jwt.NewJWKSResolver(ts.URL+"/large", nil)
+	_, err = resolver.ResolveKey("key-1", jwt.HS256)
+	assert.ErrorMatch(err, ".*cannot fetch JSON Web Key Set.*")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// jwksJSON creates a JSON Web Key Set containing the public keys.
+func jwksJSON(rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey, edKey ed25519.PublicKey, hmacKey []byte) string {
+	enc := func(b []byte) string {
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	size := (ecKey.Curve.Params().BitSize + 7) / 8
+	pad := func(i *big.Int) string {
+		b := i.Bytes()
+		for len(b) < size {
+			b = append([]byte{0}, b...)
+		}
+		return enc(b)
+	}
+	return fmt.Sprintf(`{"keys":[
+		{"kty":"RSA","kid":"rsa-1","use":"sig","n":%q,"e":%q},
+		{"kty":"EC","kid":"ec-1","crv":"P-256","x":%q,"y":%q},
+		{"kty":"OKP","kid":"ed-1","crv":"Ed25519","x":%q},
+		{"kty":"oct","kid":"oct-1","alg":"HS256","k":%q}
+	]}`,
+		enc(rsaKey.N.Bytes()), enc(big.NewInt(int64(rsaKey.E)).Bytes()),
+		pad(ecKey.X), pad(ecKey.Y),
+		enc(edKey),
+		enc(hmacKey))
+}
+
+// EOF
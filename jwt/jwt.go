@@ -13,6 +13,8 @@ package jwt
 
 import (
 	"context"
+	"crypto"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -65,32 +67,80 @@ type JWT interface {
 	// after encoding, decoding, or verification.
 	Algorithm() Algorithm
 
+	// KeyID returns the ID of the key used for signing
+	// the token if it is set in the header.
+	KeyID() string
+
 	// IsValid is a convenience method checking the
 	// registered claims if the token is valid.
 	IsValid(leeway time.Duration) bool
 }
 
 type jwtHeader struct {
-	Algorithm string `json:"alg"`
-	Type      string `json:"typ"`
+	Algorithm string   `json:"alg"`
+	Type      string   `json:"typ"`
+	KeyID     string   `json:"kid,omitempty"`
+	X5C       []string `json:"x5c,omitempty"`
 }
 
 type jwt struct {
 	claims    Claims
 	key       Key
 	algorithm Algorithm
+	keyID     string
 	token     string
 }
 
 // Encode creates a JSON Web Token for the given claims
 // based on key and algorithm.
 func Encode(claims Claims, key Key, algorithm Algorithm) (JWT, error) {
+	return EncodeWithKeyID(claims, key, algorithm, "")
+}
+
+// EncodeWithKeyID creates a JSON Web Token like Encode but also
+// sets the ID of the key in the header. So the verifier can
+// resolve the matching key.
+func EncodeWithKeyID(claims Claims, key Key, algorithm Algorithm, keyID string) (JWT, error) {
+	return encode(claims, key, jwtHeader{string(algorithm), "JWT", keyID, nil})
+}
+
+// EncodeWithCertificates creates a JSON Web Token like Encode but
+// also sets the certificate chain in the "x5c" header. The first
+// certificate has to contain the public key of the passed key,
+// otherwise an error is returned.
+func EncodeWithCertificates(claims Claims, key Key, algorithm Algorithm, chain []*x509.Certificate) (JWT, error) {
+	if len(chain) == 0 {
+		return nil, errors.New(ErrNoCertificateChain, errorMessages)
+	}
+	signKey, err := checkAlgorithm(key, algorithm, nil)
+	if err != nil {
+		return nil, errors.Annotate(err, ErrCannotEncode, errorMessages, "key")
+	}
+	publicKey, err := PublicKey(signKey)
+	if err != nil {
+		return nil, errors.Annotate(err, ErrCannotEncode, errorMessages, "key")
+	}
+	equaler, ok := publicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !equaler.Equal(chain[0].PublicKey) {
+		return nil, errors.New(ErrInvalidCertificateChain, errorMessages, "key does not match leaf")
+	}
+	return encode(claims, key, jwtHeader{string(algorithm), "JWT", "", encodeCertificateChain(chain)})
+}
+
+// encode creates a JSON Web Token with the given header.
+func encode(claims Claims, key Key, header jwtHeader) (JWT, error) {
+	algorithm, keyID := Algorithm(header.Algorithm), header.KeyID
+	key, err := checkAlgorithm(key, algorithm, nil)
+	if err != nil {
+		return nil, errors.Annotate(err, ErrCannotEncode, errorMessages, "key")
+	}
 	jwt := &jwt{
 		claims:    claims,
 		key:       key,
 		algorithm: algorithm,
+		keyID:     keyID,
 	}
-	headerPart, err := marshallAndEncode(jwtHeader{string(algorithm), "JWT"})
+	headerPart, err := marshallAndEncode(header)
 	if err != nil {
 		return nil, errors.Annotate(err, ErrCannotEncode, errorMessages, "header")
 	}
@@ -126,13 +176,31 @@ func Decode(token string) (JWT, error) {
 	return &jwt{
 		claims:    claims,
 		algorithm: Algorithm(header.Algorithm),
+		keyID:     header.KeyID,
 		token:     token,
 	}, nil
 }
 
 // Verify creates a token out of a string and varifies it against
-// the passed key.
+// the passed key. The algorithm "none" is rejected, use VerifyWith()
+// together with WithAlgorithms() to explicitly allow it.
 func Verify(token string, key Key) (JWT, error) {
+	return VerifyWith(token, WithKey(key))
+}
+
+// VerifyWithResolver creates a token out of a string and verifies it
+// against the key returned by the resolver for the key ID and the
+// algorithm in the header. The algorithm "none" is rejected.
+func VerifyWithResolver(token string, resolver KeyResolver) (JWT, error) {
+	return VerifyWith(token, WithKeyResolver(resolver))
+}
+
+// verify verifies the token with the resolved key. If options are
+// passed the algorithm is checked against them.
+func verify(token string, resolver KeyResolver, vo *verifyOptions) (JWT, error) {
+	if vo != nil && vo.decryptionKey != nil && isEncrypted(token) {
+		return verifyEncrypted(token, resolver, vo)
+	}
 	parts := strings.Split(token, ".")
 	if len(parts) != 3 {
 		return nil, errors.New(ErrCannotVerify, errorMessages, "parts")
@@ -142,7 +210,21 @@ func Verify(token string, key Key) (JWT, error) {
 	if err != nil {
 		return nil, errors.Annotate(err, ErrCannotVerify, errorMessages, "header")
 	}
-	err = decodeAndVerify(parts, key, Algorithm(header.Algorithm))
+	algorithm := Algorithm(header.Algorithm)
+	var key Key
+	if vo != nil && vo.certificates != nil {
+		key, err = vo.certificates.leafKey(header.X5C)
+	} else {
+		key, err = resolver.ResolveKey(header.KeyID, algorithm)
+	}
+	if err != nil {
+		return nil, errors.Annotate(err, ErrCannotVerify, errorMessages, "key")
+	}
+	key, err = checkAlgorithm(key, algorithm, vo)
+	if err != nil {
+		return nil, errors.Annotate(err, ErrCannotVerify, errorMessages, "algorithm")
+	}
+	err = decodeAndVerify(parts, key, algorithm)
 	if err != nil {
 		return nil, errors.Annotate(err, ErrCannotVerify, errorMessages, "signature")
 	}
@@ -151,14 +233,37 @@ func Verify(token string, key Key) (JWT, error) {
 	if err != nil {
 		return nil, errors.Annotate(err, ErrCannotVerify, errorMessages, "claims")
 	}
+	if err = vo.validate(claims); err != nil {
+		return nil, err
+	}
 	return &jwt{
 		claims:    claims,
 		key:       key,
-		algorithm: Algorithm(header.Algorithm),
+		algorithm: algorithm,
+		keyID:     header.KeyID,
 		token:     token,
 	}, nil
 }
 
+// verifyEncrypted decrypts the token and verifies the nested
+// signed token.
+func verifyEncrypted(token string, resolver KeyResolver, vo *verifyOptions) (JWT, error) {
+	header, payload, err := decrypt(token, vo.decryptionKey)
+	if err != nil {
+		return nil, errors.Annotate(err, ErrCannotVerify, errorMessages, "encryption")
+	}
+	if header.ContentType != "JWT" {
+		return nil, errors.New(ErrNoNestedToken, errorMessages)
+	}
+	nested, err := verify(string(payload), resolver, vo)
+	if err != nil {
+		return nil, err
+	}
+	signed := nested.(*jwt)
+	signed.token = token
+	return signed, nil
+}
+
 // Claims implements the JWT interface.
 func (jwt *jwt) Claims() Claims {
 	return jwt.claims
@@ -177,6 +282,11 @@ func (jwt *jwt) Algorithm() Algorithm {
 	return jwt.algorithm
 }
 
+// KeyID implements the JWT interface.
+func (jwt *jwt) KeyID() string {
+	return jwt.keyID
+}
+
 // IsValid implements the JWT interface.
 func (jwt *jwt) IsValid(leeway time.Duration) bool {
 	return jwt.claims.IsValid(leeway)
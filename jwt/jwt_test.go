@@ -14,6 +14,7 @@ package jwt_test
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
@@ -62,6 +63,32 @@ func TestESAlgorithms(t *testing.T) {
 	}
 }
 
+// TestEdDSAAlgorithm tests the EdDSA algorithm for the
+// JWT signature.
+func TestEdDSAAlgorithm(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing algorithm \"EdDSA\"")
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(err)
+	claims := initClaims()
+	// Encode.
+	jwtEncode, err := jwt.Encode(claims, privateKey, jwt.EdDSA)
+	assert.Nil(err)
+	parts := strings.Split(jwtEncode.String(), ".")
+	assert.Length(parts, 3)
+	// Verify.
+	jwtVerify, err := jwt.Verify(jwtEncode.String(), publicKey)
+	assert.Nil(err)
+	assert.Equal(jwtEncode.Algorithm(), jwtVerify.Algorithm())
+	assert.Equal(jwtEncode.String(), jwtVerify.String())
+	testClaims(assert, jwtVerify.Claims())
+	// Verify with a different key.
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(err)
+	_, err = jwt.Verify(jwtEncode.String(), otherKey)
+	assert.ErrorMatch(err, ".*token signature is invalid.*")
+}
+
 // TestHSAlgorithms tests the HMAC algorithms for the
 // JWT signature.
 func TestHSAlgorithms(t *testing.T) {
@@ -142,8 +169,10 @@ func TestNoneAlgorithm(t *testing.T) {
 	parts := strings.Split(jwtEncode.String(), ".")
 	assert.Length(parts, 3)
 	assert.Equal(parts[2], "")
-	// Verify.
-	jwtVerify, err := jwt.Verify(jwtEncode.String(), "")
+	// Verify only if explicitly allowed.
+	_, err = jwt.Verify(jwtEncode.String(), "")
+	assert.ErrorMatch(err, `.*algorithm "none" is not allowed.*`)
+	jwtVerify, err := jwt.VerifyWith(jwtEncode.String(), jwt.WithKey(""), jwt.WithAlgorithms(jwt.NONE))
 	assert.Nil(err)
 	assert.Equal(jwtEncode.Algorithm(), jwtVerify.Algorithm())
 	assert.Equal(jwtEncode.String(), jwtVerify.String())
@@ -160,6 +189,8 @@ func TestNotMatchingAlgorithm(t *testing.T) {
 	rsPrivateKey, err := rsa.GenerateKey(rand.Reader, 2048)
 	rsPublicKey := rsPrivateKey.Public()
 	assert.Nil(err)
+	edPublicKey, edPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(err)
 	noneKey := ""
 	claims := initClaims()
 	errorMatch := ".* combination of algorithm .* and key type .*"
@@ -171,7 +202,9 @@ func TestNotMatchingAlgorithm(t *testing.T) {
 		verifyKeys  []jwt.Key
 	}{
 		{"ECDSA", jwt.ES512, esPrivateKey,
-			[]jwt.Key{hsKey, rsPrivateKey, noneKey}, []jwt.Key{hsKey, rsPublicKey, noneKey}},
+			[]jwt.Key{hsKey, rsPrivateKey, edPrivateKey, noneKey}, []jwt.Key{hsKey, rsPublicKey, edPublicKey, noneKey}},
+		{"EdDSA", jwt.EdDSA, edPrivateKey,
+			[]jwt.Key{esPrivateKey, hsKey, rsPrivateKey, noneKey}, []jwt.Key{esPublicKey, hsKey, rsPublicKey, noneKey}},
 		{"HMAC", jwt.HS512, hsKey,
 			[]jwt.Key{esPrivateKey, rsPrivateKey, noneKey}, []jwt.Key{esPublicKey, rsPublicKey, noneKey}},
 		{"RSA", jwt.RS512, rsPrivateKey,
@@ -179,7 +212,7 @@ func TestNotMatchingAlgorithm(t *testing.T) {
 		{"RSAPSS", jwt.PS512, rsPrivateKey,
 			[]jwt.Key{esPrivateKey, hsKey, noneKey}, []jwt.Key{esPublicKey, hsKey, noneKey}},
 		{"none", jwt.NONE, noneKey,
-			[]jwt.Key{esPrivateKey, hsKey, rsPrivateKey}, []jwt.Key{esPublicKey, hsKey, rsPublicKey}},
+			[]jwt.Key{esPrivateKey, hsKey, rsPrivateKey, edPrivateKey}, []jwt.Key{esPublicKey, hsKey, rsPublicKey, edPublicKey}},
 	}
 	// Run the tests.
 	for _, test := range tests {
@@ -191,7 +224,7 @@ func TestNotMatchingAlgorithm(t *testing.T) {
 		jwtEncode, err := jwt.Encode(claims, test.key, test.algorithm)
 		assert.Nil(err)
 		for _, key := range test.verifyKeys {
-			_, err = jwt.Verify(jwtEncode.String(), key)
+			_, err = jwt.VerifyWith(jwtEncode.String(), jwt.WithKey(key), jwt.WithAlgorithms(test.algorithm))
 			assert.ErrorMatch(err, errorMatch)
 		}
 	}
@@ -240,6 +273,57 @@ func TestESTools(t *testing.T) {
 	testClaims(assert, jwtVerify.Claims())
 }
 
+// TestEdTools tests the tools for the reading of PEM encoded
+// Ed25519 keys.
+func TestEdTools(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing \"Ed25519\" tools")
+	// Generate keys and PEMs.
+	publicKeyIn, privateKeyIn, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(err)
+	privateBytes, err := x509.MarshalPKCS8PrivateKey(privateKeyIn)
+	assert.Nil(err)
+	privateBlock := pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: privateBytes,
+	}
+	privatePEM := pem.EncodeToMemory(&privateBlock)
+	publicBytes, err := x509.MarshalPKIXPublicKey(publicKeyIn)
+	assert.Nil(err)
+	publicBlock := pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: publicBytes,
+	}
+	publicPEM := pem.EncodeToMemory(&publicBlock)
+	assert.NotNil(publicPEM)
+	// Now read them.
+	buf := bytes.NewBuffer(privatePEM)
+	privateKeyOut, err := jwt.ReadEdPrivateKey(buf)
+	assert.Nil(err)
+	buf = bytes.NewBuffer(publicPEM)
+	publicKeyOut, err := jwt.ReadEdPublicKey(buf)
+	assert.Nil(err)
+	// And as a last step check if they are correctly usable.
+	claims := initClaims()
+	jwtEncode, err := jwt.Encode(claims, privateKeyOut, jwt.EdDSA)
+	assert.Nil(err)
+	parts := strings.Split(jwtEncode.String(), ".")
+	assert.Length(parts, 3)
+	jwtVerify, err := jwt.Verify(jwtEncode.String(), publicKeyOut)
+	assert.Nil(err)
+	assert.Equal(jwtEncode.Algorithm(), jwtVerify.Algorithm())
+	assert.Equal(jwtEncode.String(), jwtVerify.String())
+	testClaims(assert, jwtVerify.Claims())
+	// Other key types are rejected.
+	rsPrivateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+	rsBytes, err := x509.MarshalPKCS8PrivateKey(rsPrivateKey)
+	assert.Nil(err)
+	buf = bytes.NewBuffer(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: rsBytes}))
+	_, err = jwt.ReadEdPrivateKey(buf)
+	assert.ErrorMatch(err, ".*passed key is no Ed25519 key.*")
+}
+
 // TestRSTools tests the tools for the reading of PEM encoded
 // RSA keys.
 func TestRSTools(t *testing.T) {
@@ -13,6 +13,7 @@ package jwt
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
@@ -30,8 +31,8 @@ import (
 // controls signing and verification.
 type Key interface{}
 
-// ReadECPrivateKey reads a PEM formated ECDSA private key
-// from the passed reader.
+// ReadECPrivateKey reads a PEM formated SEC1 or PKCS8 ECDSA
+// private key from the passed reader.
 func ReadECPrivateKey(r io.Reader) (Key, error) {
 	pemkey, err := ioutil.ReadAll(r)
 	if err != nil {
@@ -41,11 +42,19 @@ func ReadECPrivateKey(r io.Reader) (Key, error) {
 	if block, _ = pem.Decode(pemkey); block == nil {
 		return nil, errors.New(ErrCannotDecodePEM, errorMessages)
 	}
-	var parsed *ecdsa.PrivateKey
-	if parsed, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
-		return nil, errors.Annotate(err, ErrCannotParseECDSA, errorMessages)
+	var parsed interface{}
+	parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Annotate(err, ErrCannotParseECDSA, errorMessages)
+		}
 	}
-	return parsed, nil
+	privateKey, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New(ErrNoECDSAKey, errorMessages)
+	}
+	return privateKey, nil
 }
 
 // ReadECPublicKey reads a PEM encoded ECDSA public key
@@ -128,4 +137,105 @@ func ReadRSAPublicKey(r io.Reader) (Key, error) {
 	return publicKey, nil
 }
 
+// ReadEdPrivateKey reads a PEM encoded PKCS8 Ed25519 private key
+// from the passed reader.
+func ReadEdPrivateKey(r io.Reader) (Key, error) {
+	pemkey, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.New(ErrCannotReadPEM, errorMessages)
+	}
+	var block *pem.Block
+	if block, _ = pem.Decode(pemkey); block == nil {
+		return nil, errors.New(ErrCannotDecodePEM, errorMessages)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Annotate(err, ErrCannotParseEd25519, errorMessages)
+	}
+	privateKey, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New(ErrNoEd25519Key, errorMessages)
+	}
+	return privateKey, nil
+}
+
+// ReadEdPublicKey reads a PEM encoded PKIX Ed25519 public key
+// from the passed reader.
+func ReadEdPublicKey(r io.Reader) (Key, error) {
+	pemkey, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.New(ErrCannotReadPEM, errorMessages)
+	}
+	var block *pem.Block
+	if block, _ = pem.Decode(pemkey); block == nil {
+		return nil, errors.New(ErrCannotDecodePEM, errorMessages)
+	}
+	var parsed interface{}
+	parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Annotate(err, ErrCannotParseEd25519, errorMessages)
+		}
+		parsed = certificate.PublicKey
+	}
+	publicKey, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New(ErrNoEd25519Key, errorMessages)
+	}
+	return publicKey, nil
+}
+
+// ReadEncryptedPrivateKey reads a PEM encoded and with the password
+// encrypted PKCS8 private key from the passed reader. It returns a
+// RSA, ECDSA, or Ed25519 private key. Only PBES2 with PBKDF2 and
+// AES-CBC is supported.
+func ReadEncryptedPrivateKey(r io.Reader, password []byte) (Key, error) {
+	pemkey, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.New(ErrCannotReadPEM, errorMessages)
+	}
+	var block *pem.Block
+	if block, _ = pem.Decode(pemkey); block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		return nil, errors.New(ErrCannotDecodePEM, errorMessages)
+	}
+	der, err := decryptPKCS8(block.Bytes, password)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.Annotate(err, ErrCannotDecryptPEM, errorMessages, "invalid private key")
+	}
+	return parsed, nil
+}
+
+//--------------------
+// KEY RESOLVER
+//--------------------
+
+// KeyResolver returns the key for verifying a token based on the
+// key ID and the algorithm of its header.
+type KeyResolver interface {
+	ResolveKey(keyID string, algorithm Algorithm) (Key, error)
+}
+
+// KeyResolverFunc allows to use a function as KeyResolver.
+type KeyResolverFunc func(keyID string, algorithm Algorithm) (Key, error)
+
+// ResolveKey implements the KeyResolver interface.
+func (f KeyResolverFunc) ResolveKey(keyID string, algorithm Algorithm) (Key, error) {
+	return f(keyID, algorithm)
+}
+
+// staticKeyResolver always returns the same key.
+type staticKeyResolver struct {
+	key Key
+}
+
+// ResolveKey implements the KeyResolver interface.
+func (r staticKeyResolver) ResolveKey(keyID string, algorithm Algorithm) (Key, error) {
+	return r.key, nil
+}
+
 // EOF
@@ -0,0 +1,227 @@
+// Tideland GoREST - JSON Web Token - Key Generation and Export
+//
+// Copyright (C) 2016-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package jwt
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+
+	"github.com/tideland/golib/errors"
+)
+
+//--------------------
+// GENERATION
+//--------------------
+
+// GenerateKey generates a new key for the algorithm. These are a
+// *rsa.PrivateKey with 2048 bits for RS* and PS*, a *ecdsa.PrivateKey
+// on the matching curve for ES*, an ed25519.PrivateKey for EdDSA, and
+// a []byte with the size of the hash for HS*.
+func GenerateKey(algorithm Algorithm) (Key, error) {
+	switch algorithm {
+	case RS256, RS384, RS512, PS256, PS384, PS512:
+		return GenerateRSAKey(2048)
+	case ES256:
+		return generateECKey(elliptic.P256())
+	case ES384:
+		return generateECKey(elliptic.P384())
+	case ES512:
+		return generateECKey(elliptic.P521())
+	case EdDSA:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, errors.Annotate(err, ErrCannotGenerateKey, errorMessages, algorithm)
+		}
+		return privateKey, nil
+	case HS256:
+		return generateHMACKey(32)
+	case HS384:
+		return generateHMACKey(48)
+	case HS512:
+		return generateHMACKey(64)
+	}
+	return nil, errors.New(ErrInvalidAlgorithm, errorMessages, algorithm)
+}
+
+// GenerateRSAKey generates a RSA private key with the passed number
+// of bits. It has to be at least 2048.
+func GenerateRSAKey(bits int) (Key, error) {
+	if bits < 2048 {
+		return nil, errors.New(ErrInvalidKeySize, errorMessages, bits)
+	}
+	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, errors.Annotate(err, ErrCannotGenerateKey, errorMessages, "RSA")
+	}
+	return privateKey, nil
+}
+
+// generateECKey generates an ECDSA private key on the curve.
+func generateECKey(curve elliptic.Curve) (Key, error) {
+	privateKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, errors.Annotate(err, ErrCannotGenerateKey, errorMessages, "ECDSA")
+	}
+	return privateKey, nil
+}
+
+// generateHMACKey generates a random key of the passed size.
+func generateHMACKey(size int) (Key, error) {
+	key := make([]byte, size)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, errors.Annotate(err, ErrCannotGenerateKey, errorMessages, "HMAC")
+	}
+	return key, nil
+}
+
+// PublicKey returns the public key of a private key. Public keys
+// are returned unchanged.
+func PublicKey(key Key) (Key, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return k, nil
+	case crypto.Signer:
+		return k.Public(), nil
+	}
+	return nil, errors.New(ErrInvalidKeyType, errorMessages, key)
+}
+
+//--------------------
+// PEM WRITING
+//--------------------
+
+// WriteRSAPrivateKey writes a RSA private key PEM encoded
+// as PKCS1 to the passed writer.
+func WriteRSAPrivateKey(w io.Writer, key Key) error {
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return errors.New(ErrNoRSAKey, errorMessages)
+	}
+	return writePEM(w, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(privateKey))
+}
+
+// WriteECPrivateKey writes an ECDSA private key PEM encoded
+// as SEC1 to the passed writer.
+func WriteECPrivateKey(w io.Writer, key Key) error {
+	privateKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return errors.New(ErrNoECDSAKey, errorMessages)
+	}
+	der, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return errors.Annotate(err, ErrCannotWritePEM, errorMessages)
+	}
+	return writePEM(w, "EC PRIVATE KEY", der)
+}
+
+// WritePKCS8PrivateKey writes a RSA, ECDSA, or Ed25519 private key
+// PEM encoded as PKCS8 to the passed writer.
+func WritePKCS8PrivateKey(w io.Writer, key Key) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return errors.Annotate(err, ErrCannotWritePEM, errorMessages)
+	}
+	return writePEM(w, "PRIVATE KEY", der)
+}
+
+// WriteEncryptedPKCS8PrivateKey writes a RSA, ECDSA, or Ed25519
+// private key PEM encoded as PKCS8 encrypted with the password to
+// the passed writer. It uses PBES2 with PBKDF2 and AES-256-CBC.
+func WriteEncryptedPKCS8PrivateKey(w io.Writer, key Key, password []byte) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return errors.Annotate(err, ErrCannotWritePEM, errorMessages)
+	}
+	encrypted, err := encryptPKCS8(der, password)
+	if err != nil {
+		return errors.Annotate(err, ErrCannotWritePEM, errorMessages)
+	}
+	return writePEM(w, "ENCRYPTED PRIVATE KEY", encrypted)
+}
+
+// WritePublicKey writes the public key PEM encoded as PKIX to the
+// passed writer. For private keys their public key is written.
+func WritePublicKey(w io.Writer, key Key) error {
+	publicKey, err := PublicKey(key)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return errors.Annotate(err, ErrCannotWritePEM, errorMessages)
+	}
+	return writePEM(w, "PUBLIC KEY", der)
+}
+
+// writePEM writes the PEM block.
+func writePEM(w io.Writer, blockType string, der []byte) error {
+	if err := pem.Encode(w, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		return errors.Annotate(err, ErrCannotWritePEM, errorMessages)
+	}
+	return nil
+}
+
+//--------------------
+// JWK EXPORT
+//--------------------
+
+// NewJWK creates a JSON Web Key for the key. Private keys are
+// exported as their public key, []byte keys as symmetric key. The
+// key ID and the algorithm are optional.
+func NewJWK(key Key, keyID string, algorithm Algorithm) (*JWK, error) {
+	enc := base64.RawURLEncoding.EncodeToString
+	jwk := &JWK{
+		KeyID:     keyID,
+		Use:       "sig",
+		Algorithm: string(algorithm),
+	}
+	if k, ok := key.([]byte); ok {
+		jwk.KeyType = KeyTypeOct
+		jwk.K = enc(k)
+		return jwk, nil
+	}
+	publicKey, err := PublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	switch k := publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = KeyTypeRSA
+		jwk.N = enc(k.N.Bytes())
+		jwk.E = enc(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		params := k.Curve.Params()
+		size := (params.BitSize + 7) / 8
+		jwk.KeyType = KeyTypeEC
+		jwk.Curve = params.Name
+		jwk.X = enc(k.X.FillBytes(make([]byte, size)))
+		jwk.Y = enc(k.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.KeyType = KeyTypeOKP
+		jwk.Curve = "Ed25519"
+		jwk.X = enc(k)
+	default:
+		return nil, errors.New(ErrInvalidKeyType, errorMessages, key)
+	}
+	return jwk, nil
+}
+
+// EOF
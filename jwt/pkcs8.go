@@ -0,0 +1,228 @@
+// Tideland GoREST - JSON Web Token - Encrypted PKCS8
+//
+// Copyright (C) 2016-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package jwt
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/asn1"
+	"hash"
+	"io"
+
+	"github.com/tideland/golib/errors"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// pkcs8Iterations is the number of PBKDF2 iterations when encrypting.
+const pkcs8Iterations = 600000
+
+// maxPKCS8Iterations limits the PBKDF2 iterations when decrypting, so
+// that keys from untrusted sources cannot block for a long time.
+const maxPKCS8Iterations = 10000000
+
+// Object identifiers of PBES2 (RFC 8018).
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+//--------------------
+// ASN.1 STRUCTURES
+//--------------------
+
+// encryptedPrivateKeyInfo is the PKCS8 structure of an encrypted key.
+type encryptedPrivateKeyInfo struct {
+	Algorithm     algorithmIdentifier
+	EncryptedData []byte
+}
+
+// algorithmIdentifier describes an algorithm and its parameters.
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+// pbes2Params are the parameters of PBES2.
+type pbes2Params struct {
+	KeyDerivationFunc algorithmIdentifier
+	EncryptionScheme  algorithmIdentifier
+}
+
+// pbkdf2Params are the parameters of PBKDF2.
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                 `asn1:"optional"`
+	PRF            algorithmIdentifier `asn1:"optional"`
+}
+
+//--------------------
+// ENCRYPTION
+//--------------------
+
+// encryptPKCS8 encrypts the DER encoded PKCS8 key using PBES2 with
+// PBKDF2 HMAC-SHA256 and AES-256-CBC.
+func encryptPKCS8(der, password []byte) ([]byte, error) {
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	key, err := pbkdf2.Key(sha256.New, string(password), salt, pkcs8Iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	encrypted := make([]byte, len(der)+padding)
+	copy(encrypted, der)
+	for i := len(der); i < len(encrypted); i++ {
+		encrypted[i] = byte(padding)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+	// Build the ASN.1 structure.
+	prf, err := marshalAlgorithm(oidHMACWithSHA256, asn1.NullRawValue)
+	if err != nil {
+		return nil, err
+	}
+	kdf, err := marshalAlgorithm(oidPBKDF2, pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pkcs8Iterations,
+		PRF:            prf,
+	})
+	if err != nil {
+		return nil, err
+	}
+	scheme, err := marshalAlgorithm(oidAES256CBC, iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := marshalAlgorithm(oidPBES2, pbes2Params{kdf, scheme})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{params, encrypted})
+}
+
+// decryptPKCS8 decrypts a DER encoded encrypted PKCS8 key. Only PBES2
+// with PBKDF2 and AES-CBC is supported.
+func decryptPKCS8(der, password []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, errors.Annotate(err, ErrCannotDecryptPEM, errorMessages, "invalid structure")
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, errors.New(ErrCannotDecryptPEM, errorMessages, "only PBES2 is supported")
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, errors.Annotate(err, ErrCannotDecryptPEM, errorMessages, "invalid PBES2 parameters")
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, errors.New(ErrCannotDecryptPEM, errorMessages, "only PBKDF2 is supported")
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, errors.Annotate(err, ErrCannotDecryptPEM, errorMessages, "invalid PBKDF2 parameters")
+	}
+	var h func() hash.Hash
+	switch {
+	case len(kdf.PRF.Algorithm) == 0 || kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		h = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		h = sha256.New
+	default:
+		return nil, errors.New(ErrCannotDecryptPEM, errorMessages, "unsupported PRF")
+	}
+	var keyLength int
+	switch {
+	case params.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+		keyLength = 16
+	case params.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+		keyLength = 24
+	case params.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+		keyLength = 32
+	default:
+		return nil, errors.New(ErrCannotDecryptPEM, errorMessages, "unsupported encryption scheme")
+	}
+	if kdf.KeyLength != 0 && kdf.KeyLength != keyLength {
+		return nil, errors.New(ErrCannotDecryptPEM, errorMessages, "invalid key length")
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, errors.New(ErrCannotDecryptPEM, errorMessages, "invalid IV")
+	}
+	if kdf.IterationCount < 1 || len(info.EncryptedData) == 0 || len(info.EncryptedData)%aes.BlockSize != 0 {
+		return nil, errors.New(ErrCannotDecryptPEM, errorMessages, "invalid parameters")
+	}
+	if kdf.IterationCount > maxPKCS8Iterations {
+		return nil, errors.New(ErrCannotDecryptPEM, errorMessages, "too many iterations")
+	}
+	// Derive key and decrypt.
+	key, err := pbkdf2.Key(h, string(password), kdf.Salt, kdf.IterationCount, keyLength)
+	if err != nil {
+		return nil, errors.Annotate(err, ErrCannotDecryptPEM, errorMessages, "invalid key")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Annotate(err, ErrCannotDecryptPEM, errorMessages, "invalid key")
+	}
+	decrypted := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, info.EncryptedData)
+	padding := int(decrypted[len(decrypted)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, errors.New(ErrCannotDecryptPEM, errorMessages, "wrong password")
+	}
+	for _, b := range decrypted[len(decrypted)-padding:] {
+		if int(b) != padding {
+			return nil, errors.New(ErrCannotDecryptPEM, errorMessages, "wrong password")
+		}
+	}
+	return decrypted[:len(decrypted)-padding], nil
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// marshalAlgorithm creates an algorithm identifier with the
+// marshalled parameters.
+func marshalAlgorithm(oid asn1.ObjectIdentifier, params interface{}) (algorithmIdentifier, error) {
+	raw, err := asn1.Marshal(params)
+	if err != nil {
+		return algorithmIdentifier{}, err
+	}
+	return algorithmIdentifier{
+		Algorithm:  oid,
+		Parameters: asn1.RawValue{FullBytes: raw},
+	}, nil
+}
+
+// EOF
@@ -0,0 +1,117 @@
+// Tideland GoREST - JSON Web Token - Revocation
+//
+// Copyright (C) 2016-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package jwt
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tideland/golib/errors"
+)
+
+//--------------------
+// REVOCATIONS
+//--------------------
+
+// Revocations manages revoked tokens. Single tokens are revoked by
+// their identifier ("jti"), all tokens of a subject ("sub") issued
+// before a given time ("iat") can be revoked too.
+type Revocations interface {
+	// RevokeIdentifier revokes the token with the identifier. The
+	// revocation has to be kept until the passed time, normally the
+	// expiration of the token. A zero time keeps it forever.
+	RevokeIdentifier(id string, until time.Time) error
+
+	// RevokeSubject revokes all tokens of the subject issued before
+	// the passed time. Tokens of the subject without "iat" are
+	// revoked too. As "iat" only has a precision of seconds the
+	// time is truncated to the second.
+	RevokeSubject(subject string, issuedBefore time.Time) error
+
+	// IsRevoked checks if the token with the claims is revoked.
+	IsRevoked(claims Claims) (bool, error)
+}
+
+// RevokeToken revokes the passed token by its identifier until
+// its expiration.
+func RevokeToken(revocations Revocations, token JWT) error {
+	id, ok := token.Claims().Identifier()
+	if !ok {
+		return errors.New(ErrNoIdentifier, errorMessages)
+	}
+	exp, _ := token.Claims().Expiration()
+	return revocations.RevokeIdentifier(id, exp)
+}
+
+// memoryRevocations implements Revocations.
+type memoryRevocations struct {
+	mutex       sync.RWMutex
+	identifiers map[string]time.Time
+	subjects    map[string]time.Time
+}
+
+// NewMemoryRevocations creates revocations kept in memory.
+func NewMemoryRevocations() Revocations {
+	return &memoryRevocations{
+		identifiers: make(map[string]time.Time),
+		subjects:    make(map[string]time.Time),
+	}
+}
+
+// RevokeIdentifier implements the Revocations interface.
+func (r *memoryRevocations) RevokeIdentifier(id string, until time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	for rid, runtil := range r.identifiers {
+		if !runtil.IsZero() && runtil.Before(now) {
+			delete(r.identifiers, rid)
+		}
+	}
+	r.identifiers[id] = until
+	return nil
+}
+
+// RevokeSubject implements the Revocations interface.
+func (r *memoryRevocations) RevokeSubject(subject string, issuedBefore time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	issuedBefore = issuedBefore.Truncate(time.Second)
+	if current, ok := r.subjects[subject]; !ok || issuedBefore.After(current) {
+		r.subjects[subject] = issuedBefore
+	}
+	return nil
+}
+
+// IsRevoked implements the Revocations interface.
+func (r *memoryRevocations) IsRevoked(claims Claims) (bool, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if id, ok := claims.Identifier(); ok {
+		if until, ok := r.identifiers[id]; ok {
+			if until.IsZero() || until.After(time.Now()) {
+				return true, nil
+			}
+		}
+	}
+	if subject, ok := claims.Subject(); ok {
+		if issuedBefore, ok := r.subjects[subject]; ok {
+			iat, ok := claims.IssuedAt()
+			if !ok || iat.Before(issuedBefore) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// EOF
@@ -0,0 +1,129 @@
+// Tideland GoREST - JSON Web Token - Unit Tests
+//
+// Copyright (C) 2016-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package jwt_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+
+	"github.com/tideland/gorest/jwt"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestRevokeIdentifier tests the revocation of single tokens.
+func TestRevokeIdentifier(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing revocation by identifier")
+	revocations := jwt.NewMemoryRevocations()
+	key := []byte("secret")
+	claims := initClaims()
+	claims.SetIdentifier("jti-1")
+	claims.SetExpiration(time.Now().Add(time.Hour))
+	token, err := jwt.Encode(claims, key, jwt.HS512)
+	assert.Nil(err)
+	revoked, err := revocations.IsRevoked(token.Claims())
+	assert.Nil(err)
+	assert.False(revoked)
+	err = jwt.RevokeToken(revocations, token)
+	assert.Nil(err)
+	revoked, err = revocations.IsRevoked(token.Claims())
+	assert.Nil(err)
+	assert.True(revoked)
+	// Other identifiers are not touched, passed revocations end.
+	claims.SetIdentifier("jti-2")
+	revoked, err = revocations.IsRevoked(claims)
+	assert.Nil(err)
+	assert.False(revoked)
+	err = revocations.RevokeIdentifier("jti-2", time.Now().Add(-time.Second))
+	assert.Nil(err)
+	revoked, err = revocations.IsRevoked(claims)
+	assert.Nil(err)
+	assert.False(revoked)
+	// Tokens without identifier cannot be revoked.
+	token, err = jwt.Encode(initClaims(), key, jwt.HS512)
+	assert.Nil(err)
+	err = jwt.RevokeToken(revocations, token)
+	assert.ErrorMatch(err, ".*token contains no identifier")
+}
+
+// TestRevokeSubject tests the revocation of all tokens of a subject
+// issued before a given time.
+func TestRevokeSubject(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing revocation by subject")
+	revocations := jwt.NewMemoryRevocations()
+	now := time.Now()
+	old := initClaims()
+	old.SetIssuedAt(now.Add(-time.Hour))
+	young := initClaims()
+	young.SetIssuedAt(now.Add(time.Hour))
+	same := initClaims()
+	same.SetIssuedAt(now)
+	none := initClaims()
+	other := jwt.NewClaims()
+	other.SetSubject("other")
+	other.SetIssuedAt(now.Add(-time.Hour))
+	err := revocations.RevokeSubject("1234567890", now)
+	assert.Nil(err)
+	for i, test := range []struct {
+		claims  jwt.Claims
+		revoked bool
+	}{
+		{old, true},
+		{young, false},
+		{same, false},
+		{none, true},
+		{other, false},
+	} {
+		assert.Logf("subject revocation #%d", i)
+		revoked, err := revocations.IsRevoked(test.claims)
+		assert.Nil(err)
+		assert.Equal(revoked, test.revoked)
+	}
+	// An earlier time does not reduce the revocation.
+	err = revocations.RevokeSubject("1234567890", now.Add(-2*time.Hour))
+	assert.Nil(err)
+	revoked, err := revocations.IsRevoked(old)
+	assert.Nil(err)
+	assert.True(revoked)
+}
+
+// TestRevocationCheckingCache tests the eviction of revoked
+// tokens from the cache.
+func TestRevocationCheckingCache(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing revocation checking cache")
+	revocations := jwt.NewMemoryRevocations()
+	cache := jwt.NewRevocationCheckingCache(time.Minute, time.Minute, time.Minute, 10, revocations)
+	defer cache.Stop()
+	key := []byte("secret")
+	claims := initClaims()
+	claims.SetIdentifier("jti-1")
+	token, err := jwt.Encode(claims, key, jwt.HS512)
+	assert.Nil(err)
+	assert.Equal(cache.Put(token), 1)
+	_, ok := cache.Get(token.String())
+	assert.True(ok)
+	// Revoke and check eviction.
+	err = jwt.RevokeToken(revocations, token)
+	assert.Nil(err)
+	_, ok = cache.Get(token.String())
+	assert.False(ok)
+	assert.Equal(cache.Put(token), 0)
+}
+
+// EOF
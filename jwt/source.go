@@ -0,0 +1,243 @@
+// Tideland GoREST - JSON Web Token - Token Sources
+//
+// Copyright (C) 2016-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package jwt
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/tideland/golib/errors"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// Default names of CSRF cookie and header.
+const (
+	DefaultCSRFCookie = "csrf_token"
+	DefaultCSRFHeader = "X-CSRF-Token"
+)
+
+//--------------------
+// TOKEN SOURCE
+//--------------------
+
+// TokenSource describes where a token is transported in a request.
+type TokenSource interface {
+	// Token retrieves the token out of the request. It returns
+	// false if the source contains no token.
+	Token(req *http.Request) (string, bool, error)
+
+	// AddToken adds the token to the request for the usage
+	// by a client.
+	AddToken(req *http.Request, token string)
+}
+
+// tokenFromRequest retrieves the token out of the first source
+// containing one. Without sources the authorization header is used.
+func tokenFromRequest(req *http.Request, sources []TokenSource) (string, error) {
+	if len(sources) == 0 {
+		token, ok, err := HeaderSource{}.Token(req)
+		if err == nil && !ok {
+			err = errors.New(ErrNoAuthorizationHeader, errorMessages)
+		}
+		return token, err
+	}
+	for _, source := range sources {
+		token, ok, err := source.Token(req)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return token, nil
+		}
+	}
+	return "", errors.New(ErrNoToken, errorMessages)
+}
+
+// AddToRequestWith adds a token to a request using the passed
+// source for usage by a client.
+func AddToRequestWith(req *http.Request, jwt JWT, source TokenSource) *http.Request {
+	source.AddToken(req, jwt.String())
+	return req
+}
+
+//--------------------
+// HEADER SOURCE
+//--------------------
+
+// HeaderSource transports the token in a header. By default it is
+// the "Authorization" header with the scheme "Bearer". With the
+// scheme "-" the header only contains the token.
+type HeaderSource struct {
+	Name   string
+	Scheme string
+}
+
+// Token implements the TokenSource interface.
+func (s HeaderSource) Token(req *http.Request) (string, bool, error) {
+	name, scheme := s.names()
+	value := req.Header.Get(name)
+	if value == "" {
+		return "", false, nil
+	}
+	if scheme == "" {
+		return value, true, nil
+	}
+	fields := strings.Fields(value)
+	if len(fields) != 2 || !strings.EqualFold(fields[0], scheme) {
+		return "", false, errors.New(ErrInvalidAuthorizationHeader, errorMessages, value)
+	}
+	return fields[1], true, nil
+}
+
+// AddToken implements the TokenSource interface.
+func (s HeaderSource) AddToken(req *http.Request, token string) {
+	name, scheme := s.names()
+	if scheme == "" {
+		req.Header.Set(name, token)
+		return
+	}
+	req.Header.Set(name, scheme+" "+token)
+}
+
+// names returns the header name and the scheme.
+func (s HeaderSource) names() (string, string) {
+	name, scheme := s.Name, s.Scheme
+	if name == "" {
+		name = "Authorization"
+	}
+	switch scheme {
+	case "":
+		scheme = "Bearer"
+	case "-":
+		scheme = ""
+	}
+	return name, scheme
+}
+
+//--------------------
+// COOKIE SOURCE
+//--------------------
+
+// CookieSource transports the token in a cookie. As browsers send
+// cookies automatically requests with other methods than GET, HEAD,
+// and OPTIONS are protected against CSRF. They need a CSRF cookie and
+// a CSRF header with the same value (double submit). By default these
+// are named "csrf_token" and "X-CSRF-Token". The value is an HMAC of
+// the token with the CSRFKey, so it is bound to the token and cannot
+// be chosen by an attacker. Servers issue it with SetCSRFCookie().
+type CookieSource struct {
+	Name       string
+	CSRFCookie string
+	CSRFHeader string
+	CSRFKey    []byte
+}
+
+// Token implements the TokenSource interface.
+func (s CookieSource) Token(req *http.Request) (string, bool, error) {
+	cookie, err := req.Cookie(s.Name)
+	if err != nil || cookie.Value == "" {
+		return "", false, nil
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return cookie.Value, true, nil
+	}
+	csrfCookie, csrfHeader := s.csrfNames()
+	csrf, err := req.Cookie(csrfCookie)
+	if err != nil || csrf.Value == "" {
+		return "", false, errors.New(ErrInvalidCSRFToken, errorMessages)
+	}
+	expected := []byte(s.CSRFToken(cookie.Value))
+	if subtle.ConstantTimeCompare(expected, []byte(csrf.Value)) != 1 ||
+		subtle.ConstantTimeCompare(expected, []byte(req.Header.Get(csrfHeader))) != 1 {
+		return "", false, errors.New(ErrInvalidCSRFToken, errorMessages)
+	}
+	return cookie.Value, true, nil
+}
+
+// AddToken implements the TokenSource interface. For requests
+// needing it also the CSRF cookie and header are added.
+func (s CookieSource) AddToken(req *http.Request, token string) {
+	req.AddCookie(&http.Cookie{Name: s.Name, Value: token})
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return
+	}
+	csrfCookie, csrfHeader := s.csrfNames()
+	csrf := s.CSRFToken(token)
+	req.AddCookie(&http.Cookie{Name: csrfCookie, Value: csrf})
+	req.Header.Set(csrfHeader, csrf)
+}
+
+// CSRFToken returns the CSRF value for the token.
+func (s CookieSource) CSRFToken(token string) string {
+	mac := hmac.New(sha256.New, s.CSRFKey)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SetCSRFCookie sets the CSRF cookie for the token in the response,
+// e.g. when issuing the token cookie. Scripts of the own site have
+// to read it and send its value in the CSRF header.
+func (s CookieSource) SetCSRFCookie(w http.ResponseWriter, token string) {
+	csrfCookie, _ := s.csrfNames()
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    s.CSRFToken(token),
+		Path:     "/",
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// csrfNames returns the names of CSRF cookie and header.
+func (s CookieSource) csrfNames() (string, string) {
+	cookie, header := s.CSRFCookie, s.CSRFHeader
+	if cookie == "" {
+		cookie = DefaultCSRFCookie
+	}
+	if header == "" {
+		header = DefaultCSRFHeader
+	}
+	return cookie, header
+}
+
+//--------------------
+// QUERY SOURCE
+//--------------------
+
+// QuerySource transports the token in a query parameter, e.g. for
+// WebSocket or server-sent events clients.
+type QuerySource struct {
+	Name string
+}
+
+// Token implements the TokenSource interface.
+func (s QuerySource) Token(req *http.Request) (string, bool, error) {
+	token := req.URL.Query().Get(s.Name)
+	return token, token != "", nil
+}
+
+// AddToken implements the TokenSource interface.
+func (s QuerySource) AddToken(req *http.Request, token string) {
+	query := req.URL.Query()
+	query.Set(s.Name, token)
+	req.URL.RawQuery = query.Encode()
+}
+
+// EOF
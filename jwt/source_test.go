@@ -0,0 +1,123 @@
+// Tideland GoREST - JSON Web Token - Unit Tests
+//
+// Copyright (C) 2016-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package jwt_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tideland/golib/audit"
+
+	"github.com/tideland/gorest/jwt"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestTokenSources tests adding tokens to requests and
+// retrieving them again.
+func TestTokenSources(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	tests := []struct {
+		description string
+		method      string
+		source      jwt.TokenSource
+	}{
+		{"authorization header", "GET", jwt.HeaderSource{}},
+		{"custom header with scheme", "POST", jwt.HeaderSource{Name: "X-Auth", Scheme: "Token"}},
+		{"custom header without scheme", "POST", jwt.HeaderSource{Name: "X-Token", Scheme: "-"}},
+		{"cookie", "GET", jwt.CookieSource{Name: "token"}},
+		{"cookie with CSRF", "POST", jwt.CookieSource{Name: "token"}},
+		{"cookie with own CSRF names", "DELETE", jwt.CookieSource{Name: "token", CSRFCookie: "xsrf", CSRFHeader: "X-XSRF"}},
+		{"cookie with CSRF key", "PUT", jwt.CookieSource{Name: "token", CSRFKey: []byte("csrf-secret")}},
+		{"query", "GET", jwt.QuerySource{Name: "access_token"}},
+	}
+	jwtIn, err := jwt.Encode(initClaims(), []byte("secret"), jwt.HS512)
+	assert.Nil(err)
+	for _, test := range tests {
+		assert.Logf("testing token source: %s", test.description)
+		req := httptest.NewRequest(test.method, "/test?a=b", nil)
+		jwt.AddToRequestWith(req, jwtIn, test.source)
+		token, ok, err := test.source.Token(req)
+		assert.Nil(err)
+		assert.True(ok)
+		assert.Equal(token, jwtIn.String())
+		// Other sources do not find it.
+		_, ok, err = jwt.QuerySource{Name: "other"}.Token(req)
+		assert.Nil(err)
+		assert.False(ok)
+	}
+}
+
+// TestCSRFProtection tests the CSRF protection of cookie sources.
+func TestCSRFProtection(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing CSRF protection")
+	source := jwt.CookieSource{Name: "token", CSRFKey: []byte("csrf-secret")}
+	csrf := source.CSRFToken("a.b.c")
+	otherCSRF := jwt.CookieSource{Name: "token", CSRFKey: []byte("other")}.CSRFToken("a.b.c")
+	tests := []struct {
+		description string
+		method      string
+		cookie      string
+		header      string
+		err         string
+	}{
+		{"safe method", "GET", "", "", ""},
+		{"matching", "POST", csrf, csrf, ""},
+		{"no cookie", "POST", "", csrf, ".*missing or invalid CSRF token.*"},
+		{"no header", "PUT", csrf, "", ".*missing or invalid CSRF token.*"},
+		{"not matching", "PATCH", csrf, "abd", ".*missing or invalid CSRF token.*"},
+		{"not bound to token", "POST", "abc", "abc", ".*missing or invalid CSRF token.*"},
+		{"other key", "DELETE", otherCSRF, otherCSRF, ".*missing or invalid CSRF token.*"},
+	}
+	for _, test := range tests {
+		assert.Logf("testing CSRF: %s", test.description)
+		req := httptest.NewRequest(test.method, "/test", nil)
+		req.AddCookie(&http.Cookie{Name: "token", Value: "a.b.c"})
+		if test.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: jwt.DefaultCSRFCookie, Value: test.cookie})
+		}
+		if test.header != "" {
+			req.Header.Set(jwt.DefaultCSRFHeader, test.header)
+		}
+		token, ok, err := source.Token(req)
+		if test.err != "" {
+			assert.ErrorMatch(err, test.err)
+			assert.False(ok)
+			continue
+		}
+		assert.Nil(err)
+		assert.True(ok)
+		assert.Equal(token, "a.b.c")
+	}
+	// Cookie issued by the server.
+	rec := httptest.NewRecorder()
+	source.SetCSRFCookie(rec, "a.b.c")
+	cookies := rec.Result().Cookies()
+	assert.Length(cookies, 1)
+	assert.Equal(cookies[0].Name, jwt.DefaultCSRFCookie)
+	assert.Equal(cookies[0].Value, csrf)
+	assert.False(cookies[0].HttpOnly)
+	req := httptest.NewRequest("POST", "/test", nil)
+	req.AddCookie(&http.Cookie{Name: "token", Value: "a.b.c"})
+	req.AddCookie(cookies[0])
+	req.Header.Set(jwt.DefaultCSRFHeader, cookies[0].Value)
+	token, ok, err := source.Token(req)
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Equal(token, "a.b.c")
+}
+
+// EOF
@@ -0,0 +1,182 @@
+// Tideland GoREST - JSON Web Token - Typed Claims
+//
+// Copyright (C) 2016-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package jwt
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/tideland/golib/errors"
+)
+
+//--------------------
+// REGISTERED CLAIMS
+//--------------------
+
+// NumericDate is a time marshalled as seconds since epoch like
+// the registered time claims.
+type NumericDate struct {
+	time.Time
+}
+
+// NewNumericDate returns a pointer to the numeric date of the time
+// as needed by RegisteredClaims.
+func NewNumericDate(t time.Time) *NumericDate {
+	return &NumericDate{t.Truncate(time.Second)}
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (nd NumericDate) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatInt(nd.Unix(), 10)), nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (nd *NumericDate) UnmarshalJSON(b []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(b, &seconds); err != nil {
+		return errors.Annotate(err, ErrJSONUnmarshalling, errorMessages)
+	}
+	nd.Time = time.Unix(int64(seconds), 0)
+	return nil
+}
+
+// Audience contains the audiences of the "aud" claim. A single
+// audience is marshalled as string.
+type Audience []string
+
+// MarshalJSON implements the json.Marshaler interface.
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return errors.Annotate(err, ErrJSONUnmarshalling, errorMessages)
+	}
+	*a = Audience(multiple)
+	return nil
+}
+
+// RegisteredClaims contains the registered claims. It can be embedded
+// in own structs for the usage with the typed functions.
+type RegisteredClaims struct {
+	Issuer     string       `json:"iss,omitempty"`
+	Subject    string       `json:"sub,omitempty"`
+	Audience   Audience     `json:"aud,omitempty"`
+	Expiration *NumericDate `json:"exp,omitempty"`
+	NotBefore  *NumericDate `json:"nbf,omitempty"`
+	IssuedAt   *NumericDate `json:"iat,omitempty"`
+	Identifier string       `json:"jti,omitempty"`
+}
+
+//--------------------
+// TYPED CLAIMS
+//--------------------
+
+// ClaimsFrom creates claims out of a struct, e.g. one embedding
+// the RegisteredClaims, by marshalling it to JSON.
+func ClaimsFrom(v interface{}) (Claims, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Annotate(err, ErrJSONMarshalling, errorMessages)
+	}
+	claims := NewClaims()
+	if err = json.Unmarshal(b, &claims); err != nil {
+		return nil, errors.Annotate(err, ErrJSONUnmarshalling, errorMessages)
+	}
+	return claims, nil
+}
+
+// Unmarshal stores the claims in the value pointed to by v,
+// e.g. a struct embedding the RegisteredClaims.
+func (c Claims) Unmarshal(v interface{}) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return errors.Annotate(err, ErrJSONMarshalling, errorMessages)
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		return errors.Annotate(err, ErrJSONUnmarshalling, errorMessages)
+	}
+	return nil
+}
+
+// EncodeTyped creates a JSON Web Token for the claims struct
+// based on key and algorithm.
+func EncodeTyped(v interface{}, key Key, algorithm Algorithm) (JWT, error) {
+	claims, err := ClaimsFrom(v)
+	if err != nil {
+		return nil, errors.Annotate(err, ErrCannotEncode, errorMessages, "claims")
+	}
+	return Encode(claims, key, algorithm)
+}
+
+// DecodeTyped creates a token out of a string without verification
+// and stores the claims in the value pointed to by v.
+func DecodeTyped(token string, v interface{}) (JWT, error) {
+	jwt, err := Decode(token)
+	if err != nil {
+		return nil, err
+	}
+	if err = jwt.Claims().Unmarshal(v); err != nil {
+		return nil, errors.Annotate(err, ErrCannotDecode, errorMessages, "claims")
+	}
+	return jwt, nil
+}
+
+// VerifyTyped verifies a token like VerifyWith() and stores the
+// claims in the value pointed to by v.
+func VerifyTyped(token string, v interface{}, options ...VerifyOption) (JWT, error) {
+	jwt, err := VerifyWith(token, options...)
+	if err != nil {
+		return nil, err
+	}
+	if err = jwt.Claims().Unmarshal(v); err != nil {
+		return nil, errors.Annotate(err, ErrCannotVerify, errorMessages, "claims")
+	}
+	return jwt, nil
+}
+
+// ValidateTyped validates the claims struct like Validate().
+func (p *ValidationPolicy) ValidateTyped(v interface{}) error {
+	claims, err := ClaimsFrom(v)
+	if err != nil {
+		return err
+	}
+	return p.Validate(claims)
+}
+
+// ClaimsFromContext stores the claims of the token in ctx in the
+// value pointed to by v. It returns false if there's no token.
+func ClaimsFromContext(ctx context.Context, v interface{}) (bool, error) {
+	token, ok := FromContext(ctx)
+	if !ok {
+		return false, nil
+	}
+	if err := token.Claims().Unmarshal(v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// EOF
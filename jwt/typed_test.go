@@ -0,0 +1,112 @@
+// Tideland GoREST - JSON Web Token - Unit Tests
+//
+// Copyright (C) 2016-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package jwt_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+	"github.com/tideland/golib/errors"
+
+	"github.com/tideland/gorest/jwt"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestTypedClaims tests encoding and decoding of claims structs.
+func TestTypedClaims(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing typed claims")
+	key := []byte("secret")
+	now := time.Now()
+	in := userClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:     "issuer",
+			Subject:    "1234567890",
+			Audience:   jwt.Audience{"service"},
+			Expiration: jwt.NewNumericDate(now.Add(time.Hour)),
+			IssuedAt:   jwt.NewNumericDate(now),
+		},
+		Name:  "John Doe",
+		Admin: true,
+		Roles: []string{"a", "b"},
+	}
+	jwtEnc, err := jwt.EncodeTyped(in, key, jwt.HS512)
+	assert.Nil(err)
+	testClaims(assert, jwtEnc.Claims())
+	audience, ok := jwtEnc.Claims().Audience()
+	assert.True(ok)
+	assert.Equal(audience, []string{"service"})
+	// Decode and verify.
+	var decoded userClaims
+	_, err = jwt.DecodeTyped(jwtEnc.String(), &decoded)
+	assert.Nil(err)
+	assert.Equal(decoded, in)
+	var verified userClaims
+	jwtVer, err := jwt.VerifyTyped(jwtEnc.String(), &verified, jwt.WithKey(key), jwt.WithValidation(&jwt.ValidationPolicy{
+		Issuers:   []string{"issuer"},
+		Audiences: []string{"service"},
+	}))
+	assert.Nil(err)
+	assert.Equal(verified, in)
+	_, err = jwt.VerifyTyped(jwtEnc.String(), &verified, jwt.WithKey([]byte("wrong")))
+	assert.ErrorMatch(err, ".*cannot verify the signature.*")
+	// Validate the struct directly.
+	policy := &jwt.ValidationPolicy{Audiences: []string{"other"}}
+	assert.True(errors.IsError(policy.ValidateTyped(in), jwt.ErrInvalidAudience))
+	// Retrieve from context.
+	var fromContext userClaims
+	ok, err = jwt.ClaimsFromContext(context.Background(), &fromContext)
+	assert.Nil(err)
+	assert.False(ok)
+	ctx := jwt.NewContext(context.Background(), jwtVer)
+	ok, err = jwt.ClaimsFromContext(ctx, &fromContext)
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Equal(fromContext, in)
+}
+
+// TestAudience tests the marshalling of single and multiple
+// audiences.
+func TestAudience(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing audience")
+	claims, err := jwt.ClaimsFrom(jwt.RegisteredClaims{Audience: jwt.Audience{"a", "b"}})
+	assert.Nil(err)
+	audience, ok := claims.Audience()
+	assert.True(ok)
+	assert.Equal(audience, []string{"a", "b"})
+	var registered jwt.RegisteredClaims
+	claims = jwt.NewClaims()
+	claims.SetAudience("a")
+	err = claims.Unmarshal(&registered)
+	assert.Nil(err)
+	assert.Equal(registered.Audience, jwt.Audience{"a"})
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// userClaims is a claims struct for tests.
+type userClaims struct {
+	jwt.RegisteredClaims
+	Name  string   `json:"name"`
+	Admin bool     `json:"admin"`
+	Roles []string `json:"roles"`
+}
+
+// EOF
@@ -0,0 +1,186 @@
+// Tideland GoREST - JSON Web Token - Validation
+//
+// Copyright (C) 2016-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package jwt
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"time"
+
+	"github.com/tideland/golib/errors"
+)
+
+//--------------------
+// CLOCK
+//--------------------
+
+// Clock provides the current time for the validation. So tests
+// are able to control it.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// ClockFunc is a function implementing the Clock interface.
+type ClockFunc func() time.Time
+
+// Now implements the Clock interface.
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// systemClock returns the system time.
+type systemClock struct{}
+
+// Now implements the Clock interface.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+//--------------------
+// VALIDATION POLICY
+//--------------------
+
+// AudienceMatch defines how the expected audiences are matched.
+type AudienceMatch int
+
+// Matching of the expected audiences.
+const (
+	AudienceAnyOf AudienceMatch = iota
+	AudienceAllOf
+)
+
+// ValidationPolicy describes the validation of the claims. All values
+// are optional. Tokens are valid if they have one of the issuers and,
+// depending on the audience match, one or all of the audiences. All
+// required claims have to be contained. With a maximum age the "iat"
+// claim is required and must not be older. The claims "nbf" and "exp"
+// are always checked. The leeway accounts for clock skew, the clock
+// defaults to the system time.
+type ValidationPolicy struct {
+	Issuers        []string
+	Audiences      []string
+	AudienceMatch  AudienceMatch
+	RequiredClaims []string
+	MaxAge         time.Duration
+	Leeway         time.Duration
+	Clock          Clock
+}
+
+// Validate checks the claims against the policy. The returned error
+// tells the reason, e.g. ErrTokenExpired or ErrInvalidAudience. Time
+// claims which cannot be parsed lead to ErrInvalidClaim.
+func (p *ValidationPolicy) Validate(claims Claims) error {
+	var clock Clock = systemClock{}
+	if p.Clock != nil {
+		clock = p.Clock
+	}
+	now := clock.Now()
+	// Check times.
+	exp, hasExp, err := timeClaim(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if hasExp && !now.Before(exp.Add(p.Leeway)) {
+		return errors.New(ErrTokenExpired, errorMessages)
+	}
+	nbf, hasNbf, err := timeClaim(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if hasNbf && !now.After(nbf.Add(-p.Leeway)) {
+		return errors.New(ErrTokenNotYetValid, errorMessages)
+	}
+	iat, hasIat, err := timeClaim(claims, "iat")
+	if err != nil {
+		return err
+	}
+	if p.MaxAge > 0 {
+		if !hasIat {
+			return errors.New(ErrMissingClaim, errorMessages, "iat")
+		}
+		if iat.After(now.Add(p.Leeway)) {
+			return errors.New(ErrTokenIssuedInFuture, errorMessages)
+		}
+		if now.After(iat.Add(p.MaxAge + p.Leeway)) {
+			return errors.New(ErrTokenTooOld, errorMessages, p.MaxAge)
+		}
+	}
+	// Check issuer and audience.
+	if len(p.Issuers) > 0 {
+		issuer, ok := claims.Issuer()
+		if !ok {
+			return errors.New(ErrMissingClaim, errorMessages, "iss")
+		}
+		if !containsString(p.Issuers, issuer) {
+			return errors.New(ErrInvalidIssuer, errorMessages, issuer)
+		}
+	}
+	if len(p.Audiences) > 0 {
+		audiences, ok := claims.Audience()
+		if !ok {
+			return errors.New(ErrMissingClaim, errorMessages, "aud")
+		}
+		if !p.matchesAudiences(audiences) {
+			return errors.New(ErrInvalidAudience, errorMessages, audiences)
+		}
+	}
+	// Check required claims.
+	for _, key := range p.RequiredClaims {
+		if !claims.Contains(key) {
+			return errors.New(ErrMissingClaim, errorMessages, key)
+		}
+	}
+	return nil
+}
+
+// matchesAudiences checks the audiences of the token.
+func (p *ValidationPolicy) matchesAudiences(audiences []string) bool {
+	if p.AudienceMatch == AudienceAllOf {
+		for _, expected := range p.Audiences {
+			if !containsString(audiences, expected) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, expected := range p.Audiences {
+		if containsString(audiences, expected) {
+			return true
+		}
+	}
+	return false
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// timeClaim retrieves a time claim. Contained claims which cannot
+// be parsed lead to an error.
+func timeClaim(claims Claims, key string) (time.Time, bool, error) {
+	t, ok := claims.GetTime(key)
+	if !ok && claims.Contains(key) {
+		return time.Time{}, false, errors.New(ErrInvalidClaim, errorMessages, key)
+	}
+	return t, ok, nil
+}
+
+// containsString checks if the value is contained in the values.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// EOF
@@ -0,0 +1,193 @@
+// Tideland GoREST - JSON Web Token - Unit Tests
+//
+// Copyright (C) 2016-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package jwt_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tideland/golib/audit"
+	"github.com/tideland/golib/errors"
+
+	"github.com/tideland/gorest/jwt"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestValidationPolicy tests the validation of claims.
+func TestValidationPolicy(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	now := time.Date(2017, time.December, 1, 12, 0, 0, 0, time.UTC)
+	clock := jwt.ClockFunc(func() time.Time { return now })
+	tests := []struct {
+		description string
+		prepare     func(claims jwt.Claims)
+		policy      jwt.ValidationPolicy
+		code        int
+	}{
+		{
+			description: "empty policy",
+		}, {
+			description: "expired",
+			prepare: func(claims jwt.Claims) {
+				claims.SetExpiration(now.Add(-time.Minute))
+			},
+			code: jwt.ErrTokenExpired,
+		}, {
+			description: "expired within leeway",
+			prepare: func(claims jwt.Claims) {
+				claims.SetExpiration(now.Add(-time.Minute))
+			},
+			policy: jwt.ValidationPolicy{Leeway: 2 * time.Minute},
+		}, {
+			description: "not yet valid",
+			prepare: func(claims jwt.Claims) {
+				claims.SetNotBefore(now.Add(time.Minute))
+			},
+			code: jwt.ErrTokenNotYetValid,
+		}, {
+			description: "issuer",
+			prepare: func(claims jwt.Claims) {
+				claims.SetIssuer("b")
+			},
+			policy: jwt.ValidationPolicy{Issuers: []string{"a", "b"}},
+		}, {
+			description: "wrong issuer",
+			prepare: func(claims jwt.Claims) {
+				claims.SetIssuer("c")
+			},
+			policy: jwt.ValidationPolicy{Issuers: []string{"a", "b"}},
+			code:   jwt.ErrInvalidIssuer,
+		}, {
+			description: "missing issuer",
+			policy:      jwt.ValidationPolicy{Issuers: []string{"a"}},
+			code:        jwt.ErrMissingClaim,
+		}, {
+			description: "any audience",
+			prepare: func(claims jwt.Claims) {
+				claims.SetAudience("x", "b")
+			},
+			policy: jwt.ValidationPolicy{Audiences: []string{"a", "b"}},
+		}, {
+			description: "no audience",
+			prepare: func(claims jwt.Claims) {
+				claims.SetAudience("x", "y")
+			},
+			policy: jwt.ValidationPolicy{Audiences: []string{"a", "b"}},
+			code:   jwt.ErrInvalidAudience,
+		}, {
+			description: "all audiences",
+			prepare: func(claims jwt.Claims) {
+				claims.SetAudience("a", "x", "b")
+			},
+			policy: jwt.ValidationPolicy{Audiences: []string{"a", "b"}, AudienceMatch: jwt.AudienceAllOf},
+		}, {
+			description: "not all audiences",
+			prepare: func(claims jwt.Claims) {
+				claims.SetAudience("a", "x")
+			},
+			policy: jwt.ValidationPolicy{Audiences: []string{"a", "b"}, AudienceMatch: jwt.AudienceAllOf},
+			code:   jwt.ErrInvalidAudience,
+		}, {
+			description: "required claims",
+			prepare: func(claims jwt.Claims) {
+				claims.SetIdentifier("id")
+			},
+			policy: jwt.ValidationPolicy{RequiredClaims: []string{"jti", "sub"}},
+		}, {
+			description: "missing required claim",
+			policy:      jwt.ValidationPolicy{RequiredClaims: []string{"jti"}},
+			code:        jwt.ErrMissingClaim,
+		}, {
+			description: "young enough",
+			prepare: func(claims jwt.Claims) {
+				claims.SetIssuedAt(now.Add(-time.Minute))
+			},
+			policy: jwt.ValidationPolicy{MaxAge: time.Hour},
+		}, {
+			description: "too old",
+			prepare: func(claims jwt.Claims) {
+				claims.SetIssuedAt(now.Add(-2 * time.Hour))
+			},
+			policy: jwt.ValidationPolicy{MaxAge: time.Hour},
+			code:   jwt.ErrTokenTooOld,
+		}, {
+			description: "issued in future",
+			prepare: func(claims jwt.Claims) {
+				claims.SetIssuedAt(now.Add(time.Hour))
+			},
+			policy: jwt.ValidationPolicy{MaxAge: time.Hour},
+			code:   jwt.ErrTokenIssuedInFuture,
+		}, {
+			description: "missing issued at",
+			policy:      jwt.ValidationPolicy{MaxAge: time.Hour},
+			code:        jwt.ErrMissingClaim,
+		}, {
+			description: "invalid expiration",
+			prepare: func(claims jwt.Claims) {
+				claims.Set("exp", "tomorrow")
+			},
+			code: jwt.ErrInvalidClaim,
+		}, {
+			description: "invalid not before",
+			prepare: func(claims jwt.Claims) {
+				claims.Set("nbf", true)
+			},
+			code: jwt.ErrInvalidClaim,
+		}, {
+			description: "invalid issued at",
+			prepare: func(claims jwt.Claims) {
+				claims.Set("iat", "yesterday")
+			},
+			policy: jwt.ValidationPolicy{MaxAge: time.Hour},
+			code:   jwt.ErrInvalidClaim,
+		},
+	}
+	for _, test := range tests {
+		assert.Logf("testing validation: %s", test.description)
+		claims := initClaims()
+		if test.prepare != nil {
+			test.prepare(claims)
+		}
+		policy := test.policy
+		policy.Clock = clock
+		err := policy.Validate(claims)
+		if test.code == 0 {
+			assert.Nil(err)
+			continue
+		}
+		assert.True(errors.IsError(err, test.code), test.description)
+	}
+}
+
+// TestVerifyWithValidation tests the validation when verifying.
+func TestVerifyWithValidation(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing verification with validation")
+	key := []byte("secret")
+	claims := initClaims()
+	claims.SetIssuer("issuer")
+	jwtEnc, err := jwt.Encode(claims, key, jwt.HS512)
+	assert.Nil(err)
+	_, err = jwt.VerifyWith(jwtEnc.String(), jwt.WithKey(key), jwt.WithValidation(&jwt.ValidationPolicy{
+		Issuers: []string{"issuer"},
+	}))
+	assert.Nil(err)
+	_, err = jwt.VerifyWith(jwtEnc.String(), jwt.WithKey(key), jwt.WithValidation(&jwt.ValidationPolicy{
+		Issuers: []string{"other"},
+	}))
+	assert.True(errors.IsError(err, jwt.ErrInvalidIssuer))
+}
+
+// EOF
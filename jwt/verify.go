@@ -0,0 +1,181 @@
+// Tideland GoREST - JSON Web Token - Verification
+//
+// Copyright (C) 2016-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package jwt
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto/x509"
+
+	"github.com/tideland/golib/errors"
+)
+
+//--------------------
+// BOUND KEY
+//--------------------
+
+// BoundKey binds a key to one algorithm. Tokens with a different
+// algorithm in the header are rejected when verified with it.
+type BoundKey struct {
+	Key       Key
+	Algorithm Algorithm
+}
+
+//--------------------
+// VERIFY OPTIONS
+//--------------------
+
+// verifyOptions contains the settings of a verification.
+type verifyOptions struct {
+	resolver      KeyResolver
+	algorithms    []Algorithm
+	decryptionKey Key
+	validation    *ValidationPolicy
+	sources       []TokenSource
+	certificates  *CertificatePolicy
+}
+
+// VerifyOption defines an option for VerifyWith().
+type VerifyOption func(vo *verifyOptions)
+
+// WithKey sets the key for the verification. A BoundKey also
+// restricts the algorithm.
+func WithKey(key Key) VerifyOption {
+	return func(vo *verifyOptions) {
+		vo.resolver = staticKeyResolver{key}
+	}
+}
+
+// WithKeyResolver sets the resolver returning the key for the
+// verification.
+func WithKeyResolver(resolver KeyResolver) VerifyOption {
+	return func(vo *verifyOptions) {
+		vo.resolver = resolver
+	}
+}
+
+// WithAlgorithms sets the allowed algorithms. Without this option
+// all algorithms except of "none" are allowed. The algorithm "none"
+// is only allowed if it is explicitly listed here.
+func WithAlgorithms(algorithms ...Algorithm) VerifyOption {
+	return func(vo *verifyOptions) {
+		vo.algorithms = append(vo.algorithms, algorithms...)
+	}
+}
+
+// WithDecryptionKey sets the key for the decryption of encrypted
+// tokens. Those have to contain a nested signed token which then is
+// verified. Unencrypted tokens are still verified as usual.
+func WithDecryptionKey(key Key) VerifyOption {
+	return func(vo *verifyOptions) {
+		vo.decryptionKey = key
+	}
+}
+
+// WithValidation sets the policy the claims of the token are
+// validated with. Errors of the validation are returned directly.
+func WithValidation(policy *ValidationPolicy) VerifyOption {
+	return func(vo *verifyOptions) {
+		vo.validation = policy
+	}
+}
+
+// WithTokenSources sets the sources of tokens in requests in the
+// order they are checked. By default it is the authorization header
+// with the scheme "Bearer".
+func WithTokenSources(sources ...TokenSource) VerifyOption {
+	return func(vo *verifyOptions) {
+		vo.sources = append(vo.sources, sources...)
+	}
+}
+
+// WithCertificates sets the roots the certificate chain in the
+// "x5c" header of a token has to lead to. The signature is then
+// verified with the key of the leaf certificate instead of a key
+// or key resolver. Tokens without chain are rejected.
+func WithCertificates(roots *x509.CertPool) VerifyOption {
+	return WithCertificatePolicy(&CertificatePolicy{Roots: roots})
+}
+
+// WithCertificatePolicy works like WithCertificates but allows
+// to control the verification of the certificate chain.
+func WithCertificatePolicy(policy *CertificatePolicy) VerifyOption {
+	return func(vo *verifyOptions) {
+		vo.certificates = policy
+	}
+}
+
+// allows checks if the algorithm is allowed.
+func (vo *verifyOptions) allows(algorithm Algorithm) bool {
+	if len(vo.algorithms) == 0 {
+		return algorithm != NONE
+	}
+	for _, allowed := range vo.algorithms {
+		if allowed == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// validate validates the claims if a policy is set.
+func (vo *verifyOptions) validate(claims Claims) error {
+	if vo == nil || vo.validation == nil {
+		return nil
+	}
+	return vo.validation.Validate(claims)
+}
+
+// newVerifyOptions creates the options out of the passed ones.
+func newVerifyOptions(options []VerifyOption) *verifyOptions {
+	vo := &verifyOptions{}
+	for _, option := range options {
+		option(vo)
+	}
+	return vo
+}
+
+//--------------------
+// VERIFY
+//--------------------
+
+// VerifyWith creates a token out of a string and verifies it based
+// on the passed options. The algorithm "none" is rejected unless it
+// is explicitly allowed.
+func VerifyWith(token string, options ...VerifyOption) (JWT, error) {
+	vo := newVerifyOptions(options)
+	if vo.resolver == nil && vo.certificates == nil {
+		return nil, errors.New(ErrNoKey, errorMessages)
+	}
+	return verify(token, vo.resolver, vo)
+}
+
+// checkAlgorithm checks if the algorithm is allowed and matches
+// a bound key. It returns the unwrapped key.
+func checkAlgorithm(key Key, algorithm Algorithm, vo *verifyOptions) (Key, error) {
+	if vo != nil && !vo.allows(algorithm) {
+		return nil, errors.New(ErrAlgorithmNotAllowed, errorMessages, algorithm)
+	}
+	var bound BoundKey
+	switch bk := key.(type) {
+	case BoundKey:
+		bound = bk
+	case *BoundKey:
+		bound = *bk
+	default:
+		return key, nil
+	}
+	if bound.Algorithm != algorithm {
+		return nil, errors.New(ErrKeyAlgorithmMismatch, errorMessages, bound.Algorithm, algorithm)
+	}
+	return bound.Key, nil
+}
+
+// EOF
@@ -0,0 +1,83 @@
+// Tideland GoREST - JSON Web Token - Unit Tests
+//
+// Copyright (C) 2016-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package jwt_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"testing"
+
+	"github.com/tideland/golib/audit"
+
+	"github.com/tideland/gorest/jwt"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestVerifyWithAlgorithms tests the allow-list of algorithms.
+func TestVerifyWithAlgorithms(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing verification with allowed algorithms")
+	key := []byte("secret")
+	hs256, err := jwt.Encode(initClaims(), key, jwt.HS256)
+	assert.Nil(err)
+	hs512, err := jwt.Encode(initClaims(), key, jwt.HS512)
+	assert.Nil(err)
+	none, err := jwt.Encode(initClaims(), "", jwt.NONE)
+	assert.Nil(err)
+	// Default allows all except of none.
+	jwtVer, err := jwt.VerifyWith(hs512.String(), jwt.WithKey(key))
+	assert.Nil(err)
+	testClaims(assert, jwtVer.Claims())
+	_, err = jwt.VerifyWith(none.String(), jwt.WithKey(""))
+	assert.ErrorMatch(err, `.*algorithm "none" is not allowed.*`)
+	// Explicit list.
+	_, err = jwt.VerifyWith(hs256.String(), jwt.WithKey(key), jwt.WithAlgorithms(jwt.HS256))
+	assert.Nil(err)
+	_, err = jwt.VerifyWith(hs512.String(), jwt.WithKey(key), jwt.WithAlgorithms(jwt.HS256))
+	assert.ErrorMatch(err, `.*algorithm "HS512" is not allowed.*`)
+	_, err = jwt.VerifyWith(none.String(), jwt.WithKey(""), jwt.WithAlgorithms(jwt.NONE))
+	assert.Nil(err)
+	// Resolver does not enable none.
+	_, err = jwt.VerifyWithResolver(none.String(), jwt.KeyResolverFunc(func(keyID string, algorithm jwt.Algorithm) (jwt.Key, error) {
+		return "", nil
+	}))
+	assert.ErrorMatch(err, `.*algorithm "none" is not allowed.*`)
+	// Missing key.
+	_, err = jwt.VerifyWith(hs512.String())
+	assert.ErrorMatch(err, ".*no key available.*")
+}
+
+// TestBoundKey tests the binding of keys to algorithms.
+func TestBoundKey(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	assert.Logf("testing keys bound to algorithms")
+	key := []byte("secret")
+	bound := jwt.BoundKey{Key: key, Algorithm: jwt.HS256}
+	hs256, err := jwt.Encode(initClaims(), bound, jwt.HS256)
+	assert.Nil(err)
+	_, err = jwt.Encode(initClaims(), bound, jwt.HS512)
+	assert.ErrorMatch(err, `.*key is bound to algorithm "HS256", not "HS512".*`)
+	hs512, err := jwt.Encode(initClaims(), key, jwt.HS512)
+	assert.Nil(err)
+	// Verify with bound key.
+	_, err = jwt.Verify(hs256.String(), bound)
+	assert.Nil(err)
+	_, err = jwt.Verify(hs512.String(), bound)
+	assert.ErrorMatch(err, `.*key is bound to algorithm "HS256", not "HS512".*`)
+	_, err = jwt.VerifyWith(hs256.String(), jwt.WithKey(&bound))
+	assert.Nil(err)
+	_, err = jwt.VerifyWith(hs512.String(), jwt.WithKey(&bound))
+	assert.ErrorMatch(err, `.*key is bound to algorithm "HS256", not "HS512".*`)
+}
+
+// EOF
@@ -61,6 +61,12 @@ type Servers interface {
 	Caller(domain string) (Caller, error)
 }
 
+// contextCaller is implemented by servers to retrieve callers
+// using a context.
+type contextCaller interface {
+	callerWithContext(ctx context.Context, domain string) (Caller, error)
+}
+
 // servers implements servers.
 type servers struct {
 	mutex   sync.RWMutex
@@ -89,13 +95,34 @@ func (s *servers) Add(domain, url string, transport *http.Transport) {
 
 // Caller implements the Servers interface.
 func (s *servers) Caller(domain string) (Caller, error) {
+	return s.callerWithContext(context.Background(), domain)
+}
+
+// callerWithContext retrieves a caller for a domain using the
+// passed context for its requests.
+func (s *servers) callerWithContext(ctx context.Context, domain string) (Caller, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 	srvs, ok := s.servers[domain]
 	if !ok {
 		return nil, errors.New(ErrNoServerDefined, errorMessages, domain)
 	}
-	return newCaller(domain, srvs), nil
+	return newCaller(ctx, domain, srvs), nil
+}
+
+// CallerWithContext retrieves a caller for a domain of the servers
+// using the passed context for its requests. If the context is a job
+// context the request ID and the trace context are passed to the
+// called servers. Own implementations of Servers only return their
+// Caller() for the domain.
+func CallerWithContext(servers Servers, ctx context.Context, domain string) (Caller, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if cc, ok := servers.(contextCaller); ok {
+		return cc.callerWithContext(ctx, domain)
+	}
+	return servers.Caller(domain)
 }
 
 // NewContext returns a new context that carries configured servers.
@@ -323,13 +350,14 @@ type Caller interface {
 
 // caller implements the Caller interface.
 type caller struct {
+	ctx    context.Context
 	domain string
 	srvs   []*server
 }
 
 // newCaller creates a configured caller.
-func newCaller(domain string, srvs []*server) Caller {
-	return &caller{domain, srvs}
+func newCaller(ctx context.Context, domain string, srvs []*server) Caller {
+	return &caller{ctx, domain, srvs}
 }
 
 // Get implements the Caller interface.
@@ -378,6 +406,13 @@ func (c *caller) request(method, resource, resourceID string, params *Parameters
 	if err != nil {
 		return nil, err
 	}
+	// Continue a possible trace with a span for this call.
+	if parent, ok := rest.SpanFromContext(c.ctx); ok {
+		span := parent.StartChild("call " + method + " " + c.domain + "/" + resource)
+		span.SetAttribute("http.url", urlStr)
+		defer span.Finish()
+		request = span.AddToRequest(request)
+	}
 	// Perform request.
 	response, err := client.Do(request)
 	if err != nil {
@@ -445,6 +480,10 @@ func (c *caller) prepareRequest(method, urlStr string, params *Parameters) (*htt
 	if params.Token != nil {
 		request = jwt.AddToRequest(request, params.Token)
 	}
+	request = request.WithContext(c.ctx)
+	if id, ok := rest.RequestIDFromContext(c.ctx); ok {
+		request.Header.Set(requestIDHeader(c.ctx), id)
+	}
 	if params.Accept == "" {
 		params.Accept = params.ContentType
 	}
@@ -454,6 +493,15 @@ func (c *caller) prepareRequest(method, urlStr string, params *Parameters) (*htt
 	return request, nil
 }
 
+// requestIDHeader returns the name of the request ID header. It's
+// the one of the environment if the context contains one.
+func requestIDHeader(ctx context.Context) string {
+	if env, ok := rest.EnvironmentFromContext(ctx); ok {
+		return env.RequestIDHeader()
+	}
+	return rest.DefaultRequestIDHeader
+}
+
 // analyzeResponse creates a response struct out of the HTTP response.
 func analyzeResponse(resp *http.Response) (Response, error) {
 	content, err := ioutil.ReadAll(resp.Body)
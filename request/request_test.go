@@ -260,6 +260,57 @@ func TestRequests(t *testing.T) {
 	}
 }
 
+// TestRequestID tests the passing of the request ID from a
+// context to the called server.
+func TestRequestID(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	servers := newServers(assert, 12347)
+	ctx := rest.NewRequestIDContext(context.Background(), "my-request-4711")
+	caller, err := request.CallerWithContext(servers, ctx, "testing")
+	assert.Nil(err)
+	response, err := caller.Get("item", "foo", &request.Parameters{
+		Accept: rest.ContentTypeJSON,
+	})
+	assert.Nil(err)
+	assert.Equal(response.StatusCode(), rest.StatusOK)
+	assert.Equal(response.Header().Get(rest.DefaultRequestIDHeader), "my-request-4711")
+	// Without context a new ID is generated.
+	caller, err = servers.Caller("testing")
+	assert.Nil(err)
+	response, err = caller.Get("item", "foo", &request.Parameters{
+		Accept: rest.ContentTypeJSON,
+	})
+	assert.Nil(err)
+	id := response.Header().Get(rest.DefaultRequestIDHeader)
+	assert.NotEmpty(id)
+	assert.Different(id, "my-request-4711")
+}
+
+// TestTraceContext tests the passing of the trace context
+// to the called server.
+func TestTraceContext(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	servers := newServers(assert, 12348)
+	exporter := rest.NewMemorySpanExporter()
+	span := rest.StartSpan("test", exporter)
+	ctx := rest.NewSpanContext(context.Background(), span)
+	caller, err := request.CallerWithContext(servers, ctx, "testing")
+	assert.Nil(err)
+	response, err := caller.Get("item", "trace", &request.Parameters{
+		Accept: rest.ContentTypeJSON,
+	})
+	assert.Nil(err)
+	assert.Equal(response.StatusCode(), rest.StatusOK)
+	assert.Equal(response.Header().Get("Trace-Id"), span.TraceID)
+	span.Finish()
+	spans := exporter.Spans()
+	assert.Length(spans, 2)
+	assert.Equal(spans[0].Name, "call GET testing/item")
+	assert.Equal(spans[0].TraceID, span.TraceID)
+	assert.Equal(spans[0].ParentSpanID, span.SpanID)
+	assert.Equal(spans[1], span)
+}
+
 //--------------------
 // TEST HANDLER
 //--------------------
@@ -303,6 +354,10 @@ func (th *TestHandler) Get(job rest.Job) (bool, error) {
 	case "negative-feedback":
 		return rest.NegativeFeedback(job.JSON(true), rest.StatusBadRequest, "negative feedback")
 	}
+	// Return the trace ID for the trace tests.
+	if span, ok := rest.SpanFromContext(job.Context()); ok {
+		job.ResponseWriter().Header().Set("Trace-Id", span.TraceID)
+	}
 	// Regular behavior.
 	content := &Content{
 		Index:   th.index,
@@ -0,0 +1,240 @@
+// Tideland GoREST - REST - Access Log
+//
+// Copyright (C) 2009-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package rest
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tideland/golib/etc"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// Formats of the access log.
+const (
+	AccessLogJSON   = "json"
+	AccessLogLogfmt = "logfmt"
+)
+
+// Fields of the access log.
+const (
+	AccessLogTime       = "time"
+	AccessLogRequestID  = "request-id"
+	AccessLogRemoteAddr = "remote-addr"
+	AccessLogMethod     = "method"
+	AccessLogPath       = "path"
+	AccessLogDomain     = "domain"
+	AccessLogResource   = "resource"
+	AccessLogStatus     = "status"
+	AccessLogSize       = "size"
+	AccessLogDuration   = "duration"
+	AccessLogSubject    = "subject"
+	AccessLogHandlers   = "handlers"
+	AccessLogTraceID    = "trace-id"
+	AccessLogUserAgent  = "user-agent"
+	AccessLogReferer    = "referer"
+	AccessLogTenant     = "tenant"
+)
+
+// defaultAccessLogFields are the fields logged if none are configured.
+var defaultAccessLogFields = []string{
+	AccessLogTime,
+	AccessLogRequestID,
+	AccessLogRemoteAddr,
+	AccessLogMethod,
+	AccessLogPath,
+	AccessLogStatus,
+	AccessLogSize,
+	AccessLogDuration,
+	AccessLogSubject,
+	AccessLogHandlers,
+}
+
+//--------------------
+// ACCESS LOG
+//--------------------
+
+// WithAccessLogWriter sets the writer for the access log, e.g. one
+// rotating the files. If the configuration contains no access-log
+// section the default format and fields are used.
+func WithAccessLogWriter(w io.Writer) Option {
+	return func(mux *multiplexer) {
+		mux.accessLogWriter = w
+	}
+}
+
+// accessLogEntry contains the data of one handled job.
+type accessLogEntry struct {
+	job        Job
+	rw         *responseWriter
+	start      time.Time
+	duration   time.Duration
+	domain     string
+	resource   string
+	handlerIDs []string
+}
+
+// accessLog writes one line per handled job.
+type accessLog struct {
+	mutex    sync.Mutex
+	writer   io.Writer
+	format   string
+	fields   []string
+	sampling float64
+	random   *rand.Rand
+}
+
+// newAccessLog creates the access log based on the access-log
+// section of the configuration. Without a configuration the
+// defaults are used.
+func newAccessLog(cfg etc.Etc) *accessLog {
+	al := &accessLog{
+		writer:   os.Stdout,
+		format:   AccessLogJSON,
+		fields:   defaultAccessLogFields,
+		sampling: 1.0,
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if cfg != nil {
+		al.format = strings.ToLower(cfg.ValueAsString("access-log/format", al.format))
+		fields := strings.Fields(strings.Replace(cfg.ValueAsString("access-log/fields", ""), ",", " ", -1))
+		if len(fields) > 0 {
+			al.fields = fields
+		}
+		al.sampling = cfg.ValueAsFloat64("access-log/sampling", al.sampling)
+	}
+	return al
+}
+
+// log writes the entry. Successful requests are only written if they
+// are part of the sample, failed ones always.
+func (al *accessLog) log(entry *accessLogEntry) {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+	if entry.rw.StatusCode() < 400 && al.sampling < 1.0 {
+		if al.random.Float64() >= al.sampling {
+			return
+		}
+	}
+	var buf bytes.Buffer
+	switch al.format {
+	case AccessLogLogfmt:
+		for i, field := range al.fields {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(field)
+			buf.WriteByte('=')
+			buf.WriteString(logfmtValue(entry.value(field)))
+		}
+	default:
+		buf.WriteByte('{')
+		for i, field := range al.fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			name, _ := json.Marshal(field)
+			value, err := json.Marshal(entry.value(field))
+			if err != nil {
+				value = []byte("null")
+			}
+			buf.Write(name)
+			buf.WriteByte(':')
+			buf.Write(value)
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte('\n')
+	if _, err := al.writer.Write(buf.Bytes()); err != nil {
+		logErrorf(entry.job, "cannot write access log: %v", err)
+	}
+}
+
+// value returns the value of the named field.
+func (e *accessLogEntry) value(field string) interface{} {
+	r := e.job.Request()
+	switch field {
+	case AccessLogTime:
+		return e.start.UTC().Format(time.RFC3339Nano)
+	case AccessLogRequestID:
+		return e.job.RequestID()
+	case AccessLogRemoteAddr:
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	case AccessLogMethod:
+		return r.Method
+	case AccessLogPath:
+		return r.URL.Path
+	case AccessLogDomain:
+		return e.domain
+	case AccessLogResource:
+		return e.resource
+	case AccessLogStatus:
+		return e.rw.StatusCode()
+	case AccessLogSize:
+		return e.rw.Size()
+	case AccessLogDuration:
+		return e.duration.Seconds()
+	case AccessLogSubject:
+		subject, _ := SubjectFromContext(e.job.Context())
+		return subject
+	case AccessLogHandlers:
+		return strings.Join(e.handlerIDs, ",")
+	case AccessLogTraceID:
+		if span, ok := SpanFromContext(e.job.Context()); ok {
+			return span.TraceID
+		}
+		return ""
+	case AccessLogUserAgent:
+		return r.UserAgent()
+	case AccessLogReferer:
+		return r.Referer()
+	case AccessLogTenant:
+		return e.job.Environment().Tenant()
+	}
+	return r.Header.Get(field)
+}
+
+// logfmtValue formats a value for logfmt, quoting strings
+// if needed.
+func logfmtValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		if v == "" || strings.ContainsAny(v, " =\"\\\t\n") {
+			return strconv.Quote(v)
+		}
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.Quote("")
+}
+
+// EOF
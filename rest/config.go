@@ -0,0 +1,159 @@
+// Tideland GoREST - REST - Configuration
+//
+// Copyright (C) 2009-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package rest
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tideland/golib/errors"
+	"github.com/tideland/golib/etc"
+	"github.com/tideland/golib/logger"
+	"github.com/tideland/golib/loop"
+)
+
+//--------------------
+// VALIDATION
+//--------------------
+
+// prepareConfig validates the configuration and returns the
+// configurations of the tenants. Afterwards applying it cannot fail.
+func prepareConfig(cfg etc.Etc) (map[string]etc.Etc, error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, err
+	}
+	return tenantConfigs(cfg)
+}
+
+// validateConfig checks the configuration before it is applied.
+func validateConfig(cfg etc.Etc) error {
+	if cfg == nil {
+		return nil
+	}
+	if err := validateDomainResolution(cfg); err != nil {
+		return err
+	}
+	if cfg.HasPath("access-log") {
+		switch strings.ToLower(cfg.ValueAsString("access-log/format", AccessLogJSON)) {
+		case AccessLogJSON, AccessLogLogfmt:
+		default:
+			return errors.New(ErrInvalidConfiguration, errorMessages, "unknown access log format")
+		}
+		sampling := cfg.ValueAsFloat64("access-log/sampling", 1.0)
+		if sampling < 0.0 || sampling > 1.0 {
+			return errors.New(ErrInvalidConfiguration, errorMessages, "access log sampling not between 0.0 and 1.0")
+		}
+	}
+	return validateCORS(cfg)
+}
+
+// validateDomainResolution checks the domain resolution strategies.
+func validateDomainResolution(cfg etc.Etc) error {
+	for _, strategy := range domainResolution(cfg) {
+		switch strategy {
+		case DomainFromPath, DomainFromHost, DomainFromHeader:
+		default:
+			return errors.New(ErrInvalidConfiguration, errorMessages, "unknown domain resolution "+strategy)
+		}
+	}
+	return nil
+}
+
+//--------------------
+// CONFIG WATCHER
+//--------------------
+
+// ConfigWatcher watches a configuration file and reconfigures
+// a multiplexer when it changes.
+type ConfigWatcher interface {
+	// Stop ends the watching.
+	Stop() error
+}
+
+// configWatcher implements ConfigWatcher.
+type configWatcher struct {
+	mux      Multiplexer
+	filename string
+	interval time.Duration
+	modTime  time.Time
+	size     int64
+	loop     loop.Loop
+}
+
+// WatchConfigFile checks the passed configuration file in the given
+// interval. If it changes it is read and passed to Reconfigure() of
+// the multiplexer. Errors are logged and the old configuration stays
+// active. The interval has to be positive.
+func WatchConfigFile(mux Multiplexer, filename string, interval time.Duration) (ConfigWatcher, error) {
+	if interval <= 0 {
+		return nil, errors.New(ErrInvalidConfiguration, errorMessages, "watch interval has to be positive")
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, errors.Annotate(err, ErrWatchingConfig, errorMessages, filename)
+	}
+	w := &configWatcher{
+		mux:      mux,
+		filename: filename,
+		interval: interval,
+		modTime:  fi.ModTime(),
+		size:     fi.Size(),
+	}
+	w.loop = loop.Go(w.backendLoop, "rest", "config-watcher")
+	return w, nil
+}
+
+// Stop implements the ConfigWatcher interface.
+func (w *configWatcher) Stop() error {
+	return w.loop.Stop()
+}
+
+// backendLoop checks the file in the configured interval.
+func (w *configWatcher) backendLoop(l loop.Loop) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.ShallStop():
+			return nil
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// check reloads the file if it changed.
+func (w *configWatcher) check() {
+	fi, err := os.Stat(w.filename)
+	if err != nil {
+		logger.Errorf("cannot check configuration file %q: %v", w.filename, err)
+		return
+	}
+	if fi.ModTime().Equal(w.modTime) && fi.Size() == w.size {
+		return
+	}
+	w.modTime = fi.ModTime()
+	w.size = fi.Size()
+	cfg, err := etc.ReadFile(w.filename)
+	if err != nil {
+		logger.Errorf("cannot read configuration file %q: %v", w.filename, err)
+		return
+	}
+	if err = w.mux.Reconfigure(cfg); err != nil {
+		logger.Errorf("cannot reconfigure with configuration file %q: %v", w.filename, err)
+		return
+	}
+	logger.Infof("reconfigured with configuration file %q", w.filename)
+}
+
+// EOF
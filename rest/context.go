@@ -28,6 +28,15 @@ const (
 
 	// jobKey addresses the job inside the context.
 	jobKey contextKey = 1
+
+	// requestIDKey addresses the request ID inside the context.
+	requestIDKey contextKey = 2
+
+	// spanKey addresses the tracing span inside the context.
+	spanKey contextKey = 3
+
+	// subjectKey addresses the authenticated subject inside the context.
+	subjectKey contextKey = 4
 )
 
 //--------------------
@@ -41,9 +50,29 @@ func newEnvironmentContext(ctx context.Context, env Environment) context.Context
 }
 
 // newJobContext creates a context based on the passed one
-// and containing the passed job.
+// and containing the passed job as well as its request ID.
 func newJobContext(ctx context.Context, job Job) context.Context {
-	return context.WithValue(ctx, jobKey, job)
+	ctx = context.WithValue(ctx, jobKey, job)
+	return NewRequestIDContext(ctx, job.RequestID())
+}
+
+// NewRequestIDContext creates a context based on the passed one
+// and containing the passed request ID.
+func NewRequestIDContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// NewSpanContext creates a context based on the passed one
+// and containing the passed tracing span.
+func NewSpanContext(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey, span)
+}
+
+// NewSubjectContext creates a context based on the passed one
+// and containing the subject of an authenticated request, e.g. the
+// "sub" claim of a JSON Web Token.
+func NewSubjectContext(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectKey, subject)
 }
 
 // EnvironmentFromContext retrieves the environment out of a context.
@@ -58,4 +87,22 @@ func JobFromContext(ctx context.Context) (Job, bool) {
 	return job, ok
 }
 
+// RequestIDFromContext retrieves the request ID out of a context.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey).(string)
+	return id, ok
+}
+
+// SpanFromContext retrieves the tracing span out of a context.
+func SpanFromContext(ctx context.Context) (*Span, bool) {
+	span, ok := ctx.Value(spanKey).(*Span)
+	return span, ok
+}
+
+// SubjectFromContext retrieves the authenticated subject out of a context.
+func SubjectFromContext(ctx context.Context) (string, bool) {
+	subject, ok := ctx.Value(subjectKey).(string)
+	return subject, ok
+}
+
 // EOF
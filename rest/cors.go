@@ -0,0 +1,336 @@
+// Tideland GoREST - REST - CORS
+//
+// Copyright (C) 2009-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package rest
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tideland/golib/errors"
+	"github.com/tideland/golib/etc"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// Header names of the Cross-Origin Resource Sharing.
+const (
+	HeaderOrigin                        = "Origin"
+	HeaderAccessControlRequestMethod    = "Access-Control-Request-Method"
+	HeaderAccessControlRequestHeaders   = "Access-Control-Request-Headers"
+	HeaderAccessControlAllowOrigin      = "Access-Control-Allow-Origin"
+	HeaderAccessControlAllowMethods     = "Access-Control-Allow-Methods"
+	HeaderAccessControlAllowHeaders     = "Access-Control-Allow-Headers"
+	HeaderAccessControlAllowCredentials = "Access-Control-Allow-Credentials"
+	HeaderAccessControlExposeHeaders    = "Access-Control-Expose-Headers"
+	HeaderAccessControlMaxAge           = "Access-Control-Max-Age"
+)
+
+// defaultCORSMethods are allowed if a policy names none.
+var defaultCORSMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+//--------------------
+// CORS POLICY
+//--------------------
+
+// CORSPolicy defines which cross-origin requests are allowed. The
+// allowed origins may contain the wildcard "*" alone to allow all
+// origins or inside of patterns like "https://*.example.com". An
+// empty list of allowed methods leads to GET, HEAD, POST, PUT, PATCH,
+// and DELETE, an empty list of allowed headers allows all requested
+// headers. Credentials are never allowed together with the wildcard
+// "*" alone, as any site then could make credentialed requests. So
+// such policies are answered without credentials and Reconfigure()
+// rejects configurations containing them.
+type CORSPolicy struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// WithCORSPolicy sets a CORS policy for a domain and resource. An
+// empty resource sets it for the whole domain, an empty domain too
+// sets the global policy. The most specific policy is used. Policies
+// set with this option overrule configured ones.
+func WithCORSPolicy(domain, resource string, policy CORSPolicy) Option {
+	return func(mux *multiplexer) {
+		mux.corsOptions[corsLocation(domain, resource)] = &policy
+	}
+}
+
+// allowsOrigin checks if the origin is allowed.
+func (p *CORSPolicy) allowsOrigin(origin string) bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if strings.Contains(allowed, "*") && matchOrigin(strings.ToLower(allowed), strings.ToLower(origin)) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsAnyOrigin checks if the policy allows all origins.
+func (p *CORSPolicy) allowsAnyOrigin() bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// methods returns the allowed methods.
+func (p *CORSPolicy) methods() []string {
+	if len(p.AllowedMethods) == 0 {
+		return defaultCORSMethods
+	}
+	return p.AllowedMethods
+}
+
+// allowsMethod checks if the method is allowed.
+func (p *CORSPolicy) allowsMethod(method string) bool {
+	for _, allowed := range p.methods() {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHeaders checks if all requested headers are allowed.
+func (p *CORSPolicy) allowsHeaders(headers []string) bool {
+	if len(p.AllowedHeaders) == 0 {
+		return true
+	}
+	for _, header := range headers {
+		found := false
+		for _, allowed := range p.AllowedHeaders {
+			if strings.EqualFold(allowed, header) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+//--------------------
+// CORS
+//--------------------
+
+// cors contains the policies of a multiplexer.
+type cors struct {
+	policies map[string]*CORSPolicy
+}
+
+// newCORS reads the CORS policies out of the configuration
+// and adds the ones set by options.
+func newCORS(cfg etc.Etc, options map[string]*CORSPolicy) *cors {
+	c := &cors{
+		policies: make(map[string]*CORSPolicy),
+	}
+	if cfg != nil && cfg.HasPath("cors") {
+		global := readCORSPolicy(cfg, "cors", &CORSPolicy{})
+		c.policies[corsLocation("", "")] = global
+		if cfg.HasPath("cors/policies") {
+			cfg.Do("cors/policies", func(p string) error {
+				domain := cfg.ValueAsString(p+"/domain", "")
+				resource := cfg.ValueAsString(p+"/resource", "")
+				c.policies[corsLocation(domain, resource)] = readCORSPolicy(cfg, p, global)
+				return nil
+			})
+		}
+	}
+	for location, policy := range options {
+		c.policies[location] = policy
+	}
+	if len(c.policies) == 0 {
+		return nil
+	}
+	return c
+}
+
+// validateCORS checks that no configured policy allows credentials
+// for any origin.
+func validateCORS(cfg etc.Etc) error {
+	if !cfg.HasPath("cors") {
+		return nil
+	}
+	check := func(policy *CORSPolicy) error {
+		if policy.allowsAnyOrigin() && policy.AllowCredentials {
+			return errors.New(ErrInvalidConfiguration, errorMessages, "CORS credentials are not allowed for any origin")
+		}
+		return nil
+	}
+	global := readCORSPolicy(cfg, "cors", &CORSPolicy{})
+	if err := check(global); err != nil {
+		return err
+	}
+	if !cfg.HasPath("cors/policies") {
+		return nil
+	}
+	return cfg.Do("cors/policies", func(p string) error {
+		return check(readCORSPolicy(cfg, p, global))
+	})
+}
+
+// readCORSPolicy reads a policy at the path of the configuration.
+// Missing values are taken from the parent policy.
+func readCORSPolicy(cfg etc.Etc, p string, parent *CORSPolicy) *CORSPolicy {
+	list := func(key string, dv []string) []string {
+		value := cfg.ValueAsString(p+"/"+key, "")
+		if value == "" {
+			return dv
+		}
+		return strings.Fields(strings.Replace(value, ",", " ", -1))
+	}
+	return &CORSPolicy{
+		AllowedOrigins:   list("allowed-origins", parent.AllowedOrigins),
+		AllowedMethods:   list("allowed-methods", parent.AllowedMethods),
+		AllowedHeaders:   list("allowed-headers", parent.AllowedHeaders),
+		ExposedHeaders:   list("exposed-headers", parent.ExposedHeaders),
+		AllowCredentials: cfg.ValueAsBool(p+"/allow-credentials", parent.AllowCredentials),
+		MaxAge:           cfg.ValueAsDuration(p+"/max-age", parent.MaxAge),
+	}
+}
+
+// policy returns the most specific policy for the job or nil.
+func (c *cors) policy(job Job) *CORSPolicy {
+	for _, location := range []string{
+		corsLocation(job.Domain(), job.Resource()),
+		corsLocation(job.Domain(), ""),
+		corsLocation("", ""),
+	} {
+		if policy, ok := c.policies[location]; ok {
+			return policy
+		}
+	}
+	return nil
+}
+
+// handle checks the CORS headers of the job. Preflight requests are
+// answered directly, then true is returned. Other ones with an allowed
+// origin get the according response headers.
+func (c *cors) handle(job Job) bool {
+	r := job.Request()
+	origin := r.Header.Get(HeaderOrigin)
+	if origin == "" {
+		return false
+	}
+	policy := c.policy(job)
+	if policy == nil {
+		return false
+	}
+	header := job.ResponseWriter().Header()
+	header.Add("Vary", HeaderOrigin)
+	preflight := r.Method == http.MethodOptions && r.Header.Get(HeaderAccessControlRequestMethod) != ""
+	if !policy.allowsOrigin(origin) {
+		if preflight {
+			job.ResponseWriter().WriteHeader(StatusForbidden)
+		}
+		return preflight
+	}
+	if policy.allowsAnyOrigin() {
+		header.Set(HeaderAccessControlAllowOrigin, "*")
+	} else {
+		header.Set(HeaderAccessControlAllowOrigin, origin)
+		if policy.AllowCredentials {
+			header.Set(HeaderAccessControlAllowCredentials, "true")
+		}
+	}
+	if !preflight {
+		if len(policy.ExposedHeaders) > 0 {
+			header.Set(HeaderAccessControlExposeHeaders, strings.Join(policy.ExposedHeaders, ", "))
+		}
+		return false
+	}
+	// Answer the preflight request.
+	header.Add("Vary", HeaderAccessControlRequestMethod)
+	header.Add("Vary", HeaderAccessControlRequestHeaders)
+	var requested []string
+	for _, h := range strings.Split(r.Header.Get(HeaderAccessControlRequestHeaders), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			requested = append(requested, h)
+		}
+	}
+	if !policy.allowsMethod(r.Header.Get(HeaderAccessControlRequestMethod)) || !policy.allowsHeaders(requested) {
+		header.Del(HeaderAccessControlAllowOrigin)
+		header.Del(HeaderAccessControlAllowCredentials)
+		job.ResponseWriter().WriteHeader(StatusForbidden)
+		return true
+	}
+	header.Set(HeaderAccessControlAllowMethods, strings.Join(policy.methods(), ", "))
+	if len(requested) > 0 {
+		header.Set(HeaderAccessControlAllowHeaders, strings.Join(requested, ", "))
+	}
+	if policy.MaxAge > 0 {
+		header.Set(HeaderAccessControlMaxAge, strconv.Itoa(int(policy.MaxAge/time.Second)))
+	}
+	job.ResponseWriter().WriteHeader(StatusNoContent)
+	return true
+}
+
+// matchOrigin matches the origin against a pattern where each "*"
+// stands for any characters except of slashes and colons. So the
+// wildcard cannot cover the scheme or the port.
+func matchOrigin(pattern, origin string) bool {
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(origin, parts[0]) {
+		return false
+	}
+	origin = origin[len(parts[0]):]
+	for i, part := range parts[1:] {
+		var idx int
+		if i == len(parts)-2 {
+			// Last part has to be the suffix.
+			if !strings.HasSuffix(origin, part) {
+				return false
+			}
+			idx = len(origin) - len(part)
+		} else {
+			idx = strings.Index(origin, part)
+			if idx < 0 {
+				return false
+			}
+		}
+		if strings.ContainsAny(origin[:idx], "/:") {
+			return false
+		}
+		origin = origin[idx+len(part):]
+	}
+	return true
+}
+
+// corsLocation builds the map key for domain and resource.
+func corsLocation(domain, resource string) string {
+	return strings.ToLower(domain + "/" + resource)
+}
+
+// EOF
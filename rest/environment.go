@@ -13,6 +13,7 @@ package rest
 
 import (
 	"context"
+	"strings"
 
 	"github.com/tideland/golib/etc"
 	"github.com/tideland/golib/stringex"
@@ -27,6 +28,15 @@ type Environment interface {
 	// Context returns the context of the environment.
 	Context() context.Context
 
+	// Tenant returns the name of the tenant of the environment. It
+	// is empty for the environment of the multiplexer itself.
+	Tenant() string
+
+	// Config returns the configuration of the environment. For
+	// tenants it's their subtree of the configuration. It may
+	// be nil.
+	Config() etc.Etc
+
 	// Basepath returns the configured basepath.
 	Basepath() string
 
@@ -36,36 +46,124 @@ type Environment interface {
 	// DefaultResource returns the configured default resource.
 	DefaultResource() string
 
+	// RequestIDHeader returns the name of the header used to
+	// receive and return request IDs.
+	RequestIDHeader() string
+
 	// TemplatesCache returns the template cache.
 	TemplatesCache() TemplatesCache
+
+	// Metrics returns the metrics registry. Handlers can use
+	// it to register their own metrics.
+	Metrics() Metrics
+
+	// Health returns the health check registry. Handlers can use
+	// it to register their own checks.
+	Health() Health
 }
 
 // environment implements the Environment interface.
 type environment struct {
-	ctx             context.Context
-	basepath        string
-	baseparts       []string
-	basepartsLen    int
-	defaultDomain   string
-	defaultResource string
-	templatesCache  TemplatesCache
+	ctx              context.Context
+	tenant           string
+	cfg              etc.Etc
+	basepath         string
+	baseparts        []string
+	basepartsLen     int
+	defaultDomain    string
+	defaultResource  string
+	requestIDHeader  string
+	domainResolution []string
+	domainHeader     string
+	domainHostBase   string
+	templatesCache   TemplatesCache
+	spanExporter     SpanExporter
+	metrics          *metrics
+	health           *health
 }
 
 // newEnvironment crerates an environment using the
 // passed context and configuration.
 func newEnvironment(ctx context.Context, cfg etc.Etc) *environment {
 	env := &environment{
-		basepath:        "/",
-		baseparts:       []string{},
-		defaultDomain:   "default",
-		defaultResource: "default",
-		templatesCache:  newTemplatesCache(),
+		templatesCache: newTemplatesCache(),
+		metrics:        newMetrics(),
+		health:         newHealth(),
+	}
+	env.configure(nil, cfg)
+	// Set context.
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	env.ctx = newEnvironmentContext(ctx, env)
+	return env
+}
+
+// newTenantEnvironment creates the environment of a tenant. Settings
+// missing in the tenant configuration are taken from the parent, the
+// templates cache is an own one. The context is based on the passed
+// one or, if nil, on the one of the parent and contains the tenant
+// configuration.
+func newTenantEnvironment(ctx context.Context, parent *environment, tenant string, cfg etc.Etc) *environment {
+	env := &environment{
+		tenant:         tenant,
+		templatesCache: newTemplatesCache(),
+		spanExporter:   parent.spanExporter,
+		metrics:        parent.metrics,
+		health:         parent.health,
+	}
+	env.configureTenant(ctx, parent, cfg)
+	return env
+}
+
+// configureTenant sets the settings and the context of a
+// tenant environment.
+func (env *environment) configureTenant(ctx context.Context, parent *environment, cfg etc.Etc) {
+	env.configure(parent, cfg)
+	// Set context.
+	if ctx == nil {
+		ctx = parent.ctx
+	}
+	if cfg != nil {
+		ctx = etc.NewContext(ctx, cfg)
+	}
+	env.ctx = newEnvironmentContext(ctx, env)
+}
+
+// configure sets the settings based on the configuration. Missing
+// ones are taken from the parent or the defaults if it is nil.
+func (env *environment) configure(parent *environment, cfg etc.Etc) {
+	// Set defaults.
+	if parent == nil {
+		env.basepath = "/"
+		env.defaultDomain = "default"
+		env.defaultResource = "default"
+		env.requestIDHeader = DefaultRequestIDHeader
+		env.domainResolution = []string{DomainFromPath}
+		env.domainHeader = DefaultDomainHeader
+		env.domainHostBase = ""
+	} else {
+		env.basepath = parent.basepath
+		env.defaultDomain = parent.defaultDomain
+		env.defaultResource = parent.defaultResource
+		env.requestIDHeader = parent.requestIDHeader
+		env.domainResolution = parent.domainResolution
+		env.domainHeader = parent.domainHeader
+		env.domainHostBase = parent.domainHostBase
 	}
 	// Check configuration.
+	env.cfg = cfg
 	if cfg != nil {
 		env.basepath = cfg.ValueAsString("basepath", env.basepath)
 		env.defaultDomain = cfg.ValueAsString("default-domain", env.defaultDomain)
 		env.defaultResource = cfg.ValueAsString("default-resource", env.defaultResource)
+		env.requestIDHeader = cfg.ValueAsString("request-id-header", env.requestIDHeader)
+		resolution := domainResolution(cfg)
+		if len(resolution) > 0 {
+			env.domainResolution = resolution
+		}
+		env.domainHeader = cfg.ValueAsString("domain-header", env.domainHeader)
+		env.domainHostBase = cfg.ValueAsString("domain-host-base", env.domainHostBase)
 	}
 	// Check basepath and remove empty parts.
 	env.baseparts = stringex.SplitMap(env.basepath, "/", func(p string) (string, bool) {
@@ -75,12 +173,22 @@ func newEnvironment(ctx context.Context, cfg etc.Etc) *environment {
 		return p, true
 	})
 	env.basepartsLen = len(env.baseparts)
-	// Set context.
-	if ctx == nil {
-		ctx = context.Background()
+}
+
+// domainResolution reads the configured domain resolution strategies.
+func domainResolution(cfg etc.Etc) []string {
+	return strings.Fields(strings.Replace(strings.ToLower(cfg.ValueAsString("domain-resolution", "")), ",", " ", -1))
+}
+
+// resolvesDomainBy checks if the strategy is one of the configured
+// domain resolution strategies.
+func (env *environment) resolvesDomainBy(strategy string) bool {
+	for _, configured := range env.domainResolution {
+		if configured == strategy {
+			return true
+		}
 	}
-	env.ctx = newEnvironmentContext(ctx, env)
-	return env
+	return false
 }
 
 // Context implements the Environment interface.
@@ -88,6 +196,16 @@ func (env *environment) Context() context.Context {
 	return env.ctx
 }
 
+// Tenant implements the Environment interface.
+func (env *environment) Tenant() string {
+	return env.tenant
+}
+
+// Config implements the Environment interface.
+func (env *environment) Config() etc.Etc {
+	return env.cfg
+}
+
 // Basepath implements the Environment interface.
 func (env *environment) Basepath() string {
 	return env.basepath
@@ -103,9 +221,24 @@ func (env *environment) DefaultResource() string {
 	return env.defaultResource
 }
 
+// RequestIDHeader implements the Environment interface.
+func (env *environment) RequestIDHeader() string {
+	return env.requestIDHeader
+}
+
 // TemplatesCache implements the Environment interface.
 func (env *environment) TemplatesCache() TemplatesCache {
 	return env.templatesCache
 }
 
+// Metrics implements the Environment interface.
+func (env *environment) Metrics() Metrics {
+	return env.metrics
+}
+
+// Health implements the Environment interface.
+func (env *environment) Health() Health {
+	return env.health
+}
+
 // EOF
@@ -43,6 +43,15 @@ const (
 	ErrProcessingRequestContent
 	ErrContentNotKeyValue
 	ErrReadingResponse
+	ErrInvalidMetric
+	ErrDuplicateHealthCheck
+	ErrInvalidVersionConstraint
+	ErrInvalidVersion
+	ErrUnsupportedVersion
+	ErrUnknownTenant
+	ErrInvalidConfiguration
+	ErrReconfigureHandler
+	ErrWatchingConfig
 )
 
 var errorMessages = errors.Messages{
@@ -68,6 +77,15 @@ var errorMessages = errors.Messages{
 	ErrProcessingRequestContent: "cannot process request content",
 	ErrContentNotKeyValue:       "content is not key/value",
 	ErrReadingResponse:          "cannot read the HTTP response",
+	ErrInvalidMetric:            "metric %q cannot be registered: %s",
+	ErrDuplicateHealthCheck:     "health check %q is already registered",
+	ErrInvalidVersionConstraint: "invalid version constraint %q",
+	ErrInvalidVersion:           "invalid requested version %q",
+	ErrUnsupportedVersion:       "requested version %v is not supported, supported are %s",
+	ErrUnknownTenant:            "tenant %q is not configured",
+	ErrInvalidConfiguration:     "invalid configuration: %s",
+	ErrReconfigureHandler:       "error during reconfiguration of handlers",
+	ErrWatchingConfig:           "cannot watch configuration file %q",
 }
 
 // EOF
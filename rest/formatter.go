@@ -23,7 +23,6 @@ import (
 	"time"
 
 	"github.com/tideland/golib/errors"
-	"github.com/tideland/golib/logger"
 	"github.com/tideland/golib/stringex"
 )
 
@@ -49,6 +48,7 @@ const (
 	StatusTooManyRequests     = http.StatusTooManyRequests
 	StatusConflict            = http.StatusConflict
 	StatusInternalServerError = http.StatusInternalServerError
+	StatusServiceUnavailable  = http.StatusServiceUnavailable
 )
 
 // Standard REST content types.
@@ -93,11 +93,24 @@ func PositiveFeedback(f Formatter, payload interface{}, msg string, args ...inte
 // The message is also logged.
 func NegativeFeedback(f Formatter, statusCode int, msg string, args ...interface{}) (bool, error) {
 	fmsg := fmt.Sprintf(msg, args...)
-	lmsg := fmt.Sprintf("(status code %d) "+fmsg, statusCode)
-	logger.Warningf(lmsg)
+	logWarningf(formatterJob(f), "(status code %d) %s", statusCode, fmsg)
 	return false, f.Write(statusCode, Feedback{statusCode, "fail", fmsg, nil})
 }
 
+// formatterJob returns the job of the formatter if it is one of
+// the package formatters.
+func formatterJob(f Formatter) Job {
+	switch tf := f.(type) {
+	case *gobFormatter:
+		return tf.job
+	case *jsonFormatter:
+		return tf.job
+	case *xmlFormatter:
+		return tf.job
+	}
+	return nil
+}
+
 //--------------------
 // FORMATTER
 //--------------------
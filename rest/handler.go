@@ -112,6 +112,14 @@ type InfoResourceHandler interface {
 	Info(job Job) (bool, error)
 }
 
+// ReconfigurableResourceHandler is the additional interface
+// for handlers which want to be notified after the multiplexer
+// has been reconfigured. The passed environment already contains
+// the new configuration.
+type ReconfigurableResourceHandler interface {
+	Reconfigure(env Environment) error
+}
+
 // handleJob dispatches the passed job to the right method of the
 // passed handler. It always tries the nativ method first, then
 // the alias method according to the REST conventions.
@@ -0,0 +1,194 @@
+// Tideland GoREST - REST - Health
+//
+// Copyright (C) 2009-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package rest
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tideland/golib/errors"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// DefaultHealthCheckTimeout is used for health checks registered
+// without a timeout.
+const DefaultHealthCheckTimeout = 5 * time.Second
+
+// Status values of health reports and check results.
+const (
+	HealthUp       = "up"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+	HealthDraining = "draining"
+)
+
+//--------------------
+// HEALTH
+//--------------------
+
+// HealthCheck checks one component, e.g. a database connection. It
+// returns an error if the component is not healthy. The context is
+// cancelled when the timeout of the check is reached.
+type HealthCheck func(ctx context.Context) error
+
+// HealthCheckResult contains the result of one health check.
+type HealthCheckResult struct {
+	Name     string        `json:"name"`
+	Status   string        `json:"status"`
+	Critical bool          `json:"critical"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// HealthReport aggregates the results of all health checks. The
+// status is down if a critical check fails, degraded if only non-
+// critical ones fail, and up otherwise.
+type HealthReport struct {
+	Status string              `json:"status"`
+	Checks []HealthCheckResult `json:"checks"`
+}
+
+// Health manages the health checks of a multiplexer. Handlers can
+// register their checks during initialization via the environment.
+type Health interface {
+	// Register adds a named health check. A timeout of zero leads
+	// to DefaultHealthCheckTimeout. Failing critical checks make
+	// the service not ready.
+	Register(name string, check HealthCheck, timeout time.Duration, critical bool) error
+
+	// Deregister removes a named health check.
+	Deregister(name string)
+
+	// Check runs all health checks concurrently and returns the
+	// aggregated report.
+	Check(ctx context.Context) HealthReport
+}
+
+// healthCheck contains a registered check.
+type healthCheck struct {
+	name     string
+	check    HealthCheck
+	timeout  time.Duration
+	critical bool
+}
+
+// health implements Health.
+type health struct {
+	mutex  sync.RWMutex
+	checks map[string]*healthCheck
+}
+
+// newHealth creates a new health check registry.
+func newHealth() *health {
+	return &health{
+		checks: make(map[string]*healthCheck),
+	}
+}
+
+// Register implements the Health interface.
+func (h *health) Register(name string, check HealthCheck, timeout time.Duration, critical bool) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.checks[name]; ok {
+		return errors.New(ErrDuplicateHealthCheck, errorMessages, name)
+	}
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+	h.checks[name] = &healthCheck{
+		name:     name,
+		check:    check,
+		timeout:  timeout,
+		critical: critical,
+	}
+	return nil
+}
+
+// Deregister implements the Health interface.
+func (h *health) Deregister(name string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.checks, name)
+}
+
+// Check implements the Health interface.
+func (h *health) Check(ctx context.Context) HealthReport {
+	h.mutex.RLock()
+	checks := make([]*healthCheck, 0, len(h.checks))
+	for _, hc := range h.checks {
+		checks = append(checks, hc)
+	}
+	h.mutex.RUnlock()
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].name < checks[j].name
+	})
+	report := HealthReport{
+		Status: HealthUp,
+		Checks: make([]HealthCheckResult, len(checks)),
+	}
+	var wg sync.WaitGroup
+	for i, hc := range checks {
+		wg.Add(1)
+		go func(i int, hc *healthCheck) {
+			defer wg.Done()
+			report.Checks[i] = hc.run(ctx)
+		}(i, hc)
+	}
+	wg.Wait()
+	for _, result := range report.Checks {
+		if result.Status == HealthUp {
+			continue
+		}
+		if result.Critical {
+			report.Status = HealthDown
+			break
+		}
+		report.Status = HealthDegraded
+	}
+	return report
+}
+
+// run executes the check with its timeout. The check is not waited
+// for after the timeout.
+func (hc *healthCheck) run(ctx context.Context) HealthCheckResult {
+	result := HealthCheckResult{
+		Name:     hc.name,
+		Status:   HealthUp,
+		Critical: hc.critical,
+	}
+	ctx, cancel := context.WithTimeout(ctx, hc.timeout)
+	defer cancel()
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- hc.check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Status = HealthDown
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// EOF
@@ -14,12 +14,12 @@ package rest
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"sort"
 	"strconv"
 	"strings"
 
-	"github.com/tideland/golib/logger"
 	"github.com/tideland/golib/version"
 )
 
@@ -57,6 +57,12 @@ type Job interface {
 	// Path returns access to the request path inside the URL.
 	Path() Path
 
+	// RequestID returns the ID of the request. It is either taken
+	// from the configured request ID header or generated. The ID
+	// is also stored in the job context and returned in the same
+	// header of the response.
+	RequestID() string
+
 	// Context returns a job context also containing the
 	// job itself.
 	Context() context.Context
@@ -89,6 +95,8 @@ type Job interface {
 	Languages() Languages
 
 	// InternalPath builds an internal path out of the passed parts.
+	// Without domain resolution by path a path to another domain does
+	// not contain it, clients have to pass it by host or header.
 	InternalPath(domain, resource, resourceID string, query ...KeyValue) string
 
 	// Redirect to a domain, resource and resource ID (optional).
@@ -120,7 +128,9 @@ type job struct {
 	request        *http.Request
 	responseWriter http.ResponseWriter
 	version        version.Version
-	path           Path
+	path           *path
+	requestID      string
+	span           *Span
 }
 
 // newJob parses the URL and returns the prepared job.
@@ -132,6 +142,18 @@ func newJob(env *environment, r *http.Request, rw http.ResponseWriter) Job {
 		responseWriter: rw,
 		path:           newPath(env, r),
 	}
+	// Retrieve or generate the request ID and return it.
+	j.requestID = j.request.Header.Get(env.requestIDHeader)
+	if !isValidRequestID(j.requestID) {
+		j.requestID = newRequestID()
+	}
+	j.responseWriter.Header().Set(env.requestIDHeader, j.requestID)
+	// Continue or start the trace.
+	j.span = newRootSpan(r, r.Method+" "+j.Domain()+"/"+j.Resource(), env.spanExporter)
+	j.span.SetAttribute("http.method", r.Method)
+	j.span.SetAttribute("rest.domain", j.Domain())
+	j.span.SetAttribute("rest.resource", j.Resource())
+	j.span.SetAttribute("rest.request_id", j.requestID)
 	// Retrieve the requested version of the API.
 	vsnstr := j.request.Header.Get("Version")
 	if vsnstr == "" {
@@ -139,7 +161,7 @@ func newJob(env *environment, r *http.Request, rw http.ResponseWriter) Job {
 	} else {
 		vsn, err := version.Parse(vsnstr)
 		if err != nil {
-			logger.Errorf("invalid request version: %v", err)
+			logErrorf(j, "invalid request version: %v", err)
 			j.version = version.New(1, 0, 0)
 		} else {
 			j.version = vsn
@@ -189,11 +211,16 @@ func (j *job) Path() Path {
 	return j.path
 }
 
+// RequestID implements the Job interface.
+func (j *job) RequestID() string {
+	return j.requestID
+}
+
 // Context implements the Job interface.
 func (j *job) Context() context.Context {
 	// Lazy init.
 	if j.ctx == nil {
-		j.ctx = newJobContext(j.environment.ctx, j)
+		j.ctx = NewSpanContext(newJobContext(j.environment.ctx, j), j.span)
 	}
 	return j.ctx
 }
@@ -246,14 +273,59 @@ func (j *job) Languages() Languages {
 	return languages
 }
 
-// createPath creates a path out of the major URL parts.
+// createPath creates a path out of the major URL parts. The domain
+// is omitted if the job has been resolved by host or header for the
+// same domain. A different domain of a job resolved by host leads to
+// an absolute URL with the according host if possible. Otherwise the
+// domain is part of the path if it is resolved by path. If not, the
+// path has no domain and clients have to pass it by host or header.
 func (j *job) createPath(domain, resource, resourceID string) string {
-	parts := append(j.environment.baseparts, domain, resource)
+	var host string
+	parts := append([]string{}, j.environment.baseparts...)
+	source := j.path.domainSource
+	switch {
+	case (source == DomainFromHost || source == DomainFromHeader) && strings.EqualFold(domain, j.Domain()):
+	case source == DomainFromHost && j.hostForDomain(domain) != "":
+		host = j.hostForDomain(domain)
+	case j.environment.resolvesDomainBy(DomainFromPath):
+		parts = append(parts, domain)
+	}
+	parts = append(parts, resource)
 	if resourceID != "" {
 		parts = append(parts, resourceID)
 	}
-	path := strings.Join(parts, "/")
-	return "/" + path
+	path := "/" + strings.Join(parts, "/")
+	if host != "" {
+		scheme := "http"
+		if j.request.TLS != nil {
+			scheme = "https"
+		}
+		return scheme + "://" + host + path
+	}
+	return path
+}
+
+// hostForDomain returns the host of the domain based on the host
+// of the request. The port is kept.
+func (j *job) hostForDomain(domain string) string {
+	host, port, err := net.SplitHostPort(j.request.Host)
+	if err != nil {
+		host = j.request.Host
+		port = ""
+	}
+	base := j.environment.domainHostBase
+	if base == "" {
+		labels := strings.SplitN(host, ".", 2)
+		if len(labels) < 2 {
+			return ""
+		}
+		base = labels[1]
+	}
+	host = domain + "." + strings.TrimPrefix(base, ".")
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	return host
 }
 
 // InternalPath implements the Job interface.
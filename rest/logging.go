@@ -0,0 +1,50 @@
+// Tideland GoREST - REST - Logging
+//
+// Copyright (C) 2009-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package rest
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"github.com/tideland/golib/logger"
+)
+
+//--------------------
+// LOGGING
+//--------------------
+
+// logInfof writes an info message for the passed job. It is
+// prefixed with the request ID of the job.
+func logInfof(job Job, format string, args ...interface{}) {
+	logger.Infof("[%s] "+format, prependRequestID(job, args)...)
+}
+
+// logWarningf writes a warning message for the passed job. It is
+// prefixed with the request ID of the job.
+func logWarningf(job Job, format string, args ...interface{}) {
+	logger.Warningf("[%s] "+format, prependRequestID(job, args)...)
+}
+
+// logErrorf writes an error message for the passed job. It is
+// prefixed with the request ID of the job.
+func logErrorf(job Job, format string, args ...interface{}) {
+	logger.Errorf("[%s] "+format, prependRequestID(job, args)...)
+}
+
+// prependRequestID returns the arguments for a log message with
+// the request ID of the job as first one.
+func prependRequestID(job Job, args []interface{}) []interface{} {
+	id := "-"
+	if job != nil {
+		id = job.RequestID()
+	}
+	return append([]interface{}{id}, args...)
+}
+
+// EOF
@@ -13,10 +13,10 @@ package rest
 
 import (
 	"strings"
+	"time"
 
 	"github.com/tideland/golib/errors"
 	"github.com/tideland/golib/etc"
-	"github.com/tideland/golib/logger"
 )
 
 //--------------------
@@ -32,7 +32,12 @@ type handlerListEntry struct {
 // handlerList maintains a list of handlers responsible
 // for one domain and resource.
 type handlerList struct {
-	head *handlerListEntry
+	domain     string
+	resource   string
+	constraint *versionConstraint
+	deprecated bool
+	sunset     time.Time
+	head       *handlerListEntry
 }
 
 // register adds a new resource handler.
@@ -93,11 +98,13 @@ func (hl *handlerList) ids() []string {
 	return ids
 }
 
-// handle lets all resource handlers process the request.
+// handle lets all resource handlers process the request. Each
+// handler is timed as a child span of the job span.
 func (hl *handlerList) handle(job Job) error {
+	parent, _ := SpanFromContext(job.Context())
 	current := hl.head
 	for current != nil {
-		goOn, err := handleJob(current.handler, job)
+		goOn, err := handleJobTraced(parent, current.handler, job)
 		if err != nil {
 			return err
 		}
@@ -109,6 +116,20 @@ func (hl *handlerList) handle(job Job) error {
 	return nil
 }
 
+// handleJobTraced lets the handler handle the job inside a child
+// span of the passed parent span.
+func handleJobTraced(parent *Span, handler ResourceHandler, job Job) (bool, error) {
+	if parent == nil {
+		return handleJob(handler, job)
+	}
+	span := parent.StartChild("handler " + handler.ID())
+	defer span.Finish()
+	span.SetAttribute("rest.handler_id", handler.ID())
+	goOn, err := handleJob(handler, job)
+	span.SetError(err)
+	return goOn, err
+}
+
 //--------------------
 // MAPPING
 //--------------------
@@ -118,13 +139,24 @@ func (hl *handlerList) handle(job Job) error {
 type mapping struct {
 	ignoreFavicon bool
 	handlers      map[string]*handlerList
+	versioned     map[string][]*handlerList
 }
 
 // newMapping returns a new handler mapping.
 func newMapping(cfg etc.Etc) *mapping {
-	return &mapping{
-		ignoreFavicon: cfg.ValueAsBool("ignore-favicon", true),
-		handlers:      make(map[string]*handlerList),
+	m := &mapping{
+		handlers:  make(map[string]*handlerList),
+		versioned: make(map[string][]*handlerList),
+	}
+	m.configure(cfg)
+	return m
+}
+
+// configure sets the settings of the mapping.
+func (m *mapping) configure(cfg etc.Etc) {
+	m.ignoreFavicon = true
+	if cfg != nil {
+		m.ignoreFavicon = cfg.ValueAsBool("ignore-favicon", m.ignoreFavicon)
 	}
 }
 
@@ -133,72 +165,167 @@ func (m *mapping) register(domain, resource string, handler ResourceHandler) err
 	location := m.location(domain, resource)
 	hl, ok := m.handlers[location]
 	if !ok {
-		hl = &handlerList{}
+		hl = &handlerList{
+			domain:   strings.ToLower(domain),
+			resource: strings.ToLower(resource),
+		}
 		m.handlers[location] = hl
 	}
 	return hl.register(handler)
 }
 
-// registeredHandlers returns the IDs of the registered resource handlers.
+// registerVersion adds a resource handler for the versions
+// matching the constraint.
+func (m *mapping) registerVersion(domain, resource, constraint string, handler ResourceHandler) error {
+	vc, err := parseVersionConstraint(constraint)
+	if err != nil {
+		return err
+	}
+	location := m.location(domain, resource)
+	for _, hl := range m.versioned[location] {
+		if hl.constraint.raw == vc.raw {
+			return hl.register(handler)
+		}
+	}
+	hl := &handlerList{
+		domain:     strings.ToLower(domain),
+		resource:   strings.ToLower(resource),
+		constraint: vc,
+	}
+	m.versioned[location] = append(m.versioned[location], hl)
+	return hl.register(handler)
+}
+
+// deprecateVersion marks the handler list registered for the
+// constraint as deprecated.
+func (m *mapping) deprecateVersion(domain, resource, constraint string, sunset time.Time) error {
+	vc, err := parseVersionConstraint(constraint)
+	if err != nil {
+		return err
+	}
+	location := m.location(domain, resource)
+	for _, hl := range m.versioned[location] {
+		if hl.constraint.raw == vc.raw {
+			hl.deprecated = true
+			hl.sunset = sunset
+			return nil
+		}
+	}
+	return errors.New(ErrNoHandler, errorMessages, location+" "+vc.raw)
+}
+
+// registeredHandlers returns the IDs of the registered resource
+// handlers. Those of versioned handler lists follow the unversioned
+// ones in the order of the registration of their constraints.
 func (m *mapping) registeredHandlers(domain, resource string) []string {
 	location := m.location(domain, resource)
-	hl, ok := m.handlers[location]
-	if !ok {
-		return nil
+	var ids []string
+	if hl, ok := m.handlers[location]; ok {
+		ids = hl.ids()
+	}
+	for _, hl := range m.versioned[location] {
+		ids = append(ids, hl.ids()...)
 	}
-	return hl.ids()
+	return ids
 }
 
 // deregister removes a resource handler.
 func (m *mapping) deregister(domain, resource string, ids ...string) {
 	location := m.location(domain, resource)
-	hl, ok := m.handlers[location]
-	if !ok {
-		return
+	if hl, ok := m.handlers[location]; ok {
+		hl.deregister(ids...)
+		if hl.head == nil {
+			delete(m.handlers, location)
+		}
 	}
-	hl.deregister(ids...)
-	if hl.head == nil {
-		delete(m.handlers, location)
+	var versioned []*handlerList
+	for _, hl := range m.versioned[location] {
+		hl.deregister(ids...)
+		if hl.head != nil {
+			versioned = append(versioned, hl)
+		}
+	}
+	if len(versioned) == 0 {
+		delete(m.versioned, location)
+	} else {
+		m.versioned[location] = versioned
 	}
 }
 
-// handle handles a request.
-func (m *mapping) handle(job Job) error {
-	// Check for favicon.ico.
-	if m.ignoreFavicon {
-		if job.Domain() == "favicon.ico" {
-			job.ResponseWriter().WriteHeader(StatusNoContent)
-			return nil
+// resourceHandlers returns all registered resource handlers.
+func (m *mapping) resourceHandlers() []ResourceHandler {
+	var handlers []ResourceHandler
+	collect := func(hl *handlerList) {
+		for current := hl.head; current != nil; current = current.next {
+			handlers = append(handlers, current.handler)
 		}
 	}
-	// Find handler list.
-	hl, err := m.handlerList(job)
-	if err != nil {
-		return err
+	for _, hl := range m.handlers {
+		collect(hl)
+	}
+	for _, hls := range m.versioned {
+		for _, hl := range hls {
+			collect(hl)
+		}
 	}
-	// Let the handler list handle the job.
-	logger.Infof("handling %s", job)
-	return hl.handle(job)
+	return handlers
+}
+
+// ignores checks if the job shall be ignored. This is the case
+// for favicon.ico requests if configured.
+func (m *mapping) ignores(job Job) bool {
+	return m.ignoreFavicon && job.Domain() == "favicon.ico"
 }
 
 // handlerList retrieves the handler list for the job.
 func (m *mapping) handlerList(job Job) (*handlerList, error) {
-	location := m.location(job.Domain(), job.Resource())
-	hl, ok := m.handlers[location]
-	if ok {
-		return hl, nil
+	locations := []string{
+		m.location(job.Domain(), job.Resource()),
+		m.location(job.Domain(), job.Environment().DefaultResource()),
+		m.location(job.Environment().DefaultDomain(), job.Environment().DefaultResource()),
 	}
-	location = m.location(job.Domain(), job.Environment().DefaultResource())
-	hl, ok = m.handlers[location]
-	if ok {
-		return hl, nil
+	for _, location := range locations {
+		hl, err := m.lookup(location, job)
+		if hl != nil || err != nil {
+			return hl, err
+		}
 	}
-	location = m.location(job.Environment().DefaultDomain(), job.Environment().DefaultResource())
-	hl, ok = m.handlers[location]
-	if ok {
-		return hl, nil
+	return nil, errors.New(ErrNoHandler, errorMessages, locations[len(locations)-1])
+}
+
+// lookup returns the handler list for the location. Handler lists
+// registered for versions are preferred, here the one with the highest
+// matching constraint is chosen. Without any matching the unversioned
+// list is returned.
+func (m *mapping) lookup(location string, job Job) (*handlerList, error) {
+	versioned := m.versioned[location]
+	if len(versioned) > 0 {
+		vsn, ok := requestedVersion(job)
+		if !ok {
+			return nil, errors.New(ErrInvalidVersion, errorMessages, job.Request().Header.Get("Version"))
+		}
+		var found *handlerList
+		for _, hl := range versioned {
+			if !hl.constraint.matches(vsn) {
+				continue
+			}
+			if found == nil || found.constraint.lowerBound().Less(hl.constraint.lowerBound()) {
+				found = hl
+			}
+		}
+		if found != nil {
+			return found, nil
+		}
+		if hl, ok := m.handlers[location]; ok {
+			return hl, nil
+		}
+		constraints := make([]string, len(versioned))
+		for i, hl := range versioned {
+			constraints[i] = hl.constraint.raw
+		}
+		return nil, errors.New(ErrUnsupportedVersion, errorMessages, vsn, strings.Join(constraints, ", "))
 	}
-	return nil, errors.New(ErrNoHandler, errorMessages, location)
+	return m.handlers[location], nil
 }
 
 // location builds the map key for domain and resource.
@@ -0,0 +1,430 @@
+// Tideland GoREST - REST - Metrics
+//
+// Copyright (C) 2009-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package rest
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tideland/golib/errors"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// ContentTypeMetrics is the content type of the Prometheus text
+// exposition format.
+const ContentTypeMetrics = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric types.
+const (
+	metricCounter   = "counter"
+	metricGauge     = "gauge"
+	metricHistogram = "histogram"
+)
+
+// Names of the built-in request metrics.
+const (
+	metricRequestsTotal    = "gorest_requests_total"
+	metricRequestDuration  = "gorest_request_duration_seconds"
+	metricRequestsInFlight = "gorest_requests_in_flight"
+	metricResponseSize     = "gorest_response_size_bytes"
+)
+
+// DefaultDurationBuckets are the default buckets for histograms
+// observing durations in seconds.
+var DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultSizeBuckets are the default buckets for histograms
+// observing sizes in bytes.
+var DefaultSizeBuckets = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+
+// validMetricName checks metric and label names.
+var validMetricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+//--------------------
+// METRIC TYPES
+//--------------------
+
+// Counter is a metric only going up. The label values have to be
+// passed in the order of the label names at registration.
+type Counter interface {
+	// Inc increments the counter by one.
+	Inc(labelValues ...string)
+
+	// Add adds the passed positive value to the counter.
+	Add(value float64, labelValues ...string)
+}
+
+// Gauge is a metric going up and down.
+type Gauge interface {
+	// Set sets the gauge to the passed value.
+	Set(value float64, labelValues ...string)
+
+	// Inc increments the gauge by one.
+	Inc(labelValues ...string)
+
+	// Dec decrements the gauge by one.
+	Dec(labelValues ...string)
+
+	// Add adds the passed value to the gauge.
+	Add(value float64, labelValues ...string)
+}
+
+// Histogram counts observed values in buckets.
+type Histogram interface {
+	// Observe adds a value to the histogram.
+	Observe(value float64, labelValues ...string)
+}
+
+//--------------------
+// METRICS
+//--------------------
+
+// Metrics is the registry of all metrics of a multiplexer. It
+// contains the built-in request metrics and allows handlers to
+// register their own ones, e.g. during initialization via the
+// environment. Registering the same name with the same type and
+// labels twice returns the already registered metric.
+type Metrics interface {
+	// RegisterCounter registers a counter with the passed labels.
+	RegisterCounter(name, help string, labelNames ...string) (Counter, error)
+
+	// RegisterGauge registers a gauge with the passed labels.
+	RegisterGauge(name, help string, labelNames ...string) (Gauge, error)
+
+	// RegisterHistogram registers a histogram with the passed
+	// buckets and labels. Nil buckets lead to DefaultDurationBuckets.
+	RegisterHistogram(name, help string, buckets []float64, labelNames ...string) (Histogram, error)
+
+	// WriteTo writes all metrics in the Prometheus text
+	// exposition format.
+	WriteTo(w io.Writer) (int64, error)
+}
+
+// metrics implements Metrics.
+type metrics struct {
+	mutex    sync.RWMutex
+	families map[string]*metricFamily
+}
+
+// newMetrics creates a new metrics registry.
+func newMetrics() *metrics {
+	return &metrics{
+		families: make(map[string]*metricFamily),
+	}
+}
+
+// RegisterCounter implements the Metrics interface.
+func (m *metrics) RegisterCounter(name, help string, labelNames ...string) (Counter, error) {
+	return m.register(name, help, metricCounter, nil, labelNames)
+}
+
+// RegisterGauge implements the Metrics interface.
+func (m *metrics) RegisterGauge(name, help string, labelNames ...string) (Gauge, error) {
+	return m.register(name, help, metricGauge, nil, labelNames)
+}
+
+// RegisterHistogram implements the Metrics interface.
+func (m *metrics) RegisterHistogram(name, help string, buckets []float64, labelNames ...string) (Histogram, error) {
+	if buckets == nil {
+		buckets = DefaultDurationBuckets
+	}
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+	return m.register(name, help, metricHistogram, sorted, labelNames)
+}
+
+// register adds a new metric family or returns an existing one
+// with the same definition.
+func (m *metrics) register(name, help, kind string, buckets []float64, labelNames []string) (*metricFamily, error) {
+	if !validMetricName.MatchString(name) {
+		return nil, errors.New(ErrInvalidMetric, errorMessages, name, "invalid name")
+	}
+	for _, labelName := range labelNames {
+		if !validMetricName.MatchString(labelName) || labelName == "le" {
+			return nil, errors.New(ErrInvalidMetric, errorMessages, name, "invalid label "+labelName)
+		}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if mf, ok := m.families[name]; ok {
+		if mf.kind != kind || strings.Join(mf.labelNames, ",") != strings.Join(labelNames, ",") {
+			return nil, errors.New(ErrInvalidMetric, errorMessages, name, "already registered differently")
+		}
+		return mf, nil
+	}
+	mf := &metricFamily{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		buckets:    buckets,
+		labelNames: labelNames,
+		series:     make(map[string]*metricSeries),
+	}
+	m.families[name] = mf
+	return mf, nil
+}
+
+// WriteTo implements the Metrics interface and io.WriterTo.
+func (m *metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mutex.RLock()
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	families := make([]*metricFamily, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		families[i] = m.families[name]
+	}
+	m.mutex.RUnlock()
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, mf := range families {
+		mf.writeTo(cw)
+	}
+	if cw.err == nil {
+		cw.err = cw.w.(*bufio.Writer).Flush()
+	}
+	return cw.n, cw.err
+}
+
+//--------------------
+// METRIC FAMILY
+//--------------------
+
+// metricSeries contains the values of one label combination.
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// metricFamily implements Counter, Gauge, and Histogram. The
+// kind is checked at registration.
+type metricFamily struct {
+	mutex      sync.Mutex
+	name       string
+	help       string
+	kind       string
+	buckets    []float64
+	labelNames []string
+	series     map[string]*metricSeries
+}
+
+// Inc implements the Counter and the Gauge interface.
+func (mf *metricFamily) Inc(labelValues ...string) {
+	mf.Add(1, labelValues...)
+}
+
+// Dec implements the Gauge interface.
+func (mf *metricFamily) Dec(labelValues ...string) {
+	mf.Add(-1, labelValues...)
+}
+
+// Add implements the Counter and the Gauge interface.
+func (mf *metricFamily) Add(value float64, labelValues ...string) {
+	if mf.kind == metricCounter && value < 0 {
+		return
+	}
+	mf.mutex.Lock()
+	defer mf.mutex.Unlock()
+	mf.seriesFor(labelValues).value += value
+}
+
+// Set implements the Gauge interface.
+func (mf *metricFamily) Set(value float64, labelValues ...string) {
+	mf.mutex.Lock()
+	defer mf.mutex.Unlock()
+	mf.seriesFor(labelValues).value = value
+}
+
+// Observe implements the Histogram interface.
+func (mf *metricFamily) Observe(value float64, labelValues ...string) {
+	mf.mutex.Lock()
+	defer mf.mutex.Unlock()
+	s := mf.seriesFor(labelValues)
+	for i, upper := range mf.buckets {
+		if value <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+// seriesFor returns the series for the label values. Missing
+// values are set empty, additional ones are ignored.
+func (mf *metricFamily) seriesFor(labelValues []string) *metricSeries {
+	lvs := make([]string, len(mf.labelNames))
+	copy(lvs, labelValues)
+	key := strings.Join(lvs, "\xff")
+	s, ok := mf.series[key]
+	if !ok {
+		s = &metricSeries{
+			labelValues: lvs,
+			counts:      make([]uint64, len(mf.buckets)),
+		}
+		mf.series[key] = s
+	}
+	return s
+}
+
+// writeTo writes the family in the text exposition format.
+func (mf *metricFamily) writeTo(cw *countingWriter) {
+	mf.mutex.Lock()
+	defer mf.mutex.Unlock()
+	if mf.help != "" {
+		cw.printf("# HELP %s %s\n", mf.name, escapeHelp(mf.help))
+	}
+	cw.printf("# TYPE %s %s\n", mf.name, mf.kind)
+	keys := make([]string, 0, len(mf.series))
+	for key := range mf.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := mf.series[key]
+		labels := formatLabels(mf.labelNames, s.labelValues)
+		if mf.kind != metricHistogram {
+			cw.printf("%s%s %s\n", mf.name, labels, formatFloat(s.value))
+			continue
+		}
+		names := append(append([]string{}, mf.labelNames...), "le")
+		values := append(append([]string{}, s.labelValues...), "")
+		for i, upper := range mf.buckets {
+			values[len(values)-1] = formatFloat(upper)
+			cw.printf("%s_bucket%s %d\n", mf.name, formatLabels(names, values), s.counts[i])
+		}
+		values[len(values)-1] = "+Inf"
+		cw.printf("%s_bucket%s %d\n", mf.name, formatLabels(names, values), s.count)
+		cw.printf("%s_sum%s %s\n", mf.name, labels, formatFloat(s.sum))
+		cw.printf("%s_count%s %d\n", mf.name, labels, s.count)
+	}
+}
+
+//--------------------
+// REQUEST METRICS
+//--------------------
+
+// requestMetrics contains the built-in metrics of the multiplexer.
+type requestMetrics struct {
+	total    Counter
+	duration Histogram
+	inFlight Gauge
+	size     Histogram
+}
+
+// newRequestMetrics registers the built-in metrics.
+func newRequestMetrics(m Metrics) *requestMetrics {
+	// Errors are impossible here, names and labels are valid.
+	total, _ := m.RegisterCounter(metricRequestsTotal,
+		"Total number of handled requests.",
+		"domain", "resource", "method", "status")
+	duration, _ := m.RegisterHistogram(metricRequestDuration,
+		"Duration of the request handling in seconds.",
+		DefaultDurationBuckets,
+		"domain", "resource", "method", "status")
+	inFlight, _ := m.RegisterGauge(metricRequestsInFlight,
+		"Number of requests currently handled.",
+		"domain", "resource", "method")
+	size, _ := m.RegisterHistogram(metricResponseSize,
+		"Size of the responses in bytes.",
+		DefaultSizeBuckets,
+		"domain", "resource", "method", "status")
+	return &requestMetrics{
+		total:    total,
+		duration: duration,
+		inFlight: inFlight,
+		size:     size,
+	}
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// countingWriter counts the written bytes and keeps the first error.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+// printf writes formatted if no error happened before.
+func (cw *countingWriter) printf(format string, args ...interface{}) {
+	if cw.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(cw.w, format, args...)
+	cw.n += int64(n)
+	cw.err = err
+}
+
+// statusClass returns the class of a status code like "2xx".
+func statusClass(statusCode int) string {
+	return strconv.Itoa(statusCode/100) + "xx"
+}
+
+// formatLabels formats label names and values.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escapeLabelValue(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatFloat formats a metric value.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// labelValueEscaper escapes label values.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// escapeLabelValue escapes a label value.
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+// helpEscaper escapes help texts.
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+// escapeHelp escapes a help text.
+func escapeHelp(h string) string {
+	return helpEscaper.Replace(h)
+}
+
+// EOF
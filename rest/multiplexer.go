@@ -14,13 +14,16 @@ package rest
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/tideland/golib/errors"
 	"github.com/tideland/golib/etc"
 	"github.com/tideland/golib/logger"
-	"github.com/tideland/golib/monitoring"
 )
 
 //--------------------
@@ -52,6 +55,17 @@ type Multiplexer interface {
 	// RegisterAll allows to register multiple handler in one run.
 	RegisterAll(registrations Registrations) error
 
+	// RegisterVersion adds a resource handler for a given domain and
+	// resource only responsible for API versions matching the constraint,
+	// e.g. ">=2.0.0 <3.0.0". Handlers registered with the same
+	// constraint form one handler list.
+	RegisterVersion(domain, resource, constraint string, handler ResourceHandler) error
+
+	// DeprecateVersion marks the handler list of the constraint as
+	// deprecated. Its responses contain a Deprecation header and, if
+	// the sunset is not zero, a Sunset header.
+	DeprecateVersion(domain, resource, constraint string, sunset time.Time) error
+
 	// RegisteredHandlers returns the ID stack of registered handlers
 	// for a domain and resource.
 	RegisteredHandlers(domain, resource string) []string
@@ -59,17 +73,66 @@ type Multiplexer interface {
 	// Deregister removes one, more, or all resource handler for a
 	// given domain and resource.
 	Deregister(domain, resource string, ids ...string)
+
+	// RegisterTenant adds a resource handler for a given domain and
+	// resource only responsible for the jobs of the tenant. It is
+	// initialized with the environment of the tenant.
+	RegisterTenant(tenant, domain, resource string, handler ResourceHandler) error
+
+	// DeregisterTenant removes one, more, or all resource handler
+	// of the tenant for a given domain and resource.
+	DeregisterTenant(tenant, domain, resource string, ids ...string)
+
+	// Reconfigure validates the passed configuration and applies it
+	// atomically, an invalid one changes nothing. Afterwards all
+	// registered handlers implementing the
+	// ReconfigurableResourceHandler interface are notified.
+	Reconfigure(cfg etc.Etc) error
+
+	// Drain marks the multiplexer as shutting down. From now on the
+	// readiness resource reports it as not ready while all other
+	// requests are still handled.
+	Drain()
+}
+
+// Option defines a function setting an optional parameter
+// of a multiplexer.
+type Option func(mux *multiplexer)
+
+// WithSpanExporter sets the exporter for the tracing spans of
+// the multiplexer. Without an exporter the trace context is
+// continued and propagated, but the spans are dropped.
+func WithSpanExporter(exporter SpanExporter) Option {
+	return func(mux *multiplexer) {
+		mux.environment.spanExporter = exporter
+	}
 }
 
 // multiplexer implements the Multiplexer interface.
 type multiplexer struct {
-	mutex       sync.RWMutex
-	environment *environment
-	mapping     *mapping
+	mutex           sync.RWMutex
+	environment     *environment
+	mapping         *mapping
+	requestMetrics  *requestMetrics
+	accessLog       *accessLog
+	accessLogWriter io.Writer
+	cors            *cors
+	corsOptions     map[string]*CORSPolicy
+	metricsDomain   string
+	metricsResource string
+	healthDomain    string
+	livenessRes     string
+	readinessRes    string
+	draining        int32
+	tenantHeader    string
+	tenantResolver  TenantResolver
+	tenants         map[string]*tenant
+	tenantContexts  map[string]context.Context
+	err             error
 }
 
 // NewMultiplexer creates a new HTTP multiplexer. The passed context
-// will be  used if a handler requests a context from a job, the
+// will be used if a handler requests a context from a job, the
 // configuration allows to configure the multiplexer. The allowed
 // parameters are
 //
@@ -78,21 +141,179 @@ type multiplexer struct {
 //         {default-domain default}
 //         {default-resource default}
 //         {ignore-favicon true}
+//         {request-id-header X-Request-ID}
+//         {domain-resolution path}
+//         {domain-header X-Domain}
+//         {metrics
+//             {domain system}
+//             {resource metrics}
+//         }
+//         {access-log
+//             {format json}
+//             {fields time request-id remote-addr method path status size duration subject handlers}
+//             {sampling 1.0}
+//         }
+//         {health
+//             {domain system}
+//             {liveness live}
+//             {readiness ready}
+//         }
+//         {cors
+//             {allowed-origins https://*.example.com}
+//             {allowed-methods GET POST}
+//             {allowed-headers Content-Type}
+//             {exposed-headers X-Request-ID}
+//             {allow-credentials false}
+//             {max-age 10m}
+//             {policies
+//                 {<name>
+//                     {domain <domain>}
+//                     {resource <resource>}
+//                     ...
+//                 }
+//             }
+//         }
+//         {tenant-header <header>}
+//         {tenants
+//             {<tenant>
+//                 {default-domain default}
+//                 ...
+//             }
+//         }
 //     }
 //
-// The values shown here are the default values if the configuration
-// is nil or missing these settings.
-func NewMultiplexer(ctx context.Context, cfg etc.Etc) Multiplexer {
-	return &multiplexer{
-		environment: newEnvironment(ctx, cfg),
-		mapping:     newMapping(cfg),
+// The values shown here are the default values if the configuration is
+// nil or missing these settings. They can be changed later with
+// Reconfigure(). An invalid configuration is rejected like there: it is
+// logged, the defaults are used, and registering handlers returns the
+// error until a valid one is set with Reconfigure(). Only the metrics,
+// access-log, and health sections are different: without the first one
+// the metrics are collected but not served, without the second one
+// nothing is logged unless the option WithAccessLogWriter() is used,
+// and without the third one no probes are answered. The values of the
+// cors section are only examples. Additional options allow to set
+// further parameters, e.g. the exporter for the tracing spans.
+//
+// The domain of a request is resolved by the strategies listed in
+// domain-resolution and tried in their order. "path" takes it from the
+// first part of the path after the basepath, "host" from the subdomain
+// of the Host header, and "header" from the configured domain-header,
+// e.g. set by a gateway. For "host" the setting domain-host-base, e.g.
+// "example.com", defines the base of the subdomains. Otherwise hosts
+// with at least three labels are used. If no strategy matches the
+// default domain is used. The paths of InternalPath() and Redirect()
+// follow the resolution of the job.
+//
+// Cross-origin requests are checked against the most specific CORS
+// policy for domain and resource. Named policies take missing values
+// from the global one, also WithCORSPolicy() allows to set them. The
+// multiplexer answers preflight requests itself, other requests get the
+// according headers before their handlers are called. Without a cors
+// section or option CORS isn't handled at all.
+//
+// Each configured tenant has an own environment. It is selected by the
+// TenantResolver set with WithTenantResolver(). Only without it and if
+// the tenant-header is configured the tenant is taken from this request
+// header. As clients can set it to any value it has to be set or
+// checked by an authenticating gateway in front of the server. The
+// settings of a tenant are taken from its subtree, missing ones from
+// the multiplexer. It has an own templates cache and an own context,
+// see WithTenantContext(), containing the subtree. Handlers registered
+// with RegisterTenant() are preferred for the jobs of the tenant, but
+// also the others get the tenant environment.
+//
+// Handlers registered with RegisterVersion() are chosen by the API
+// version of the Version header. Out of the matching constraints the
+// one with the highest lower bound wins, otherwise the handlers
+// registered without version are used. If there are none an invalid
+// version is answered with status 400, an unsupported one with 406.
+//
+// The multiplexer continues the W3C Trace Context passed with the
+// traceparent and tracestate headers or starts a new trace. The span of
+// the job is stored in the job context and can be retrieved with
+// SpanFromContext(). Each handler in a handler list runs in its own
+// child span.
+//
+// Each request is counted and measured labelled by the domain and
+// resource of the matching handlers, the method, and the status class.
+// With the metrics section the collected metrics including those
+// registered by handlers via Environment.Metrics() are served in the
+// Prometheus text exposition format.
+//
+// The access log writes one line per job after it has been handled,
+// either as JSON or as logfmt. Beside the default fields also domain,
+// resource, trace-id, user-agent, referer, and tenant are available,
+// any other field name is taken as name of a request header. The
+// subject is read from the job context, see NewSubjectContext(). The
+// sampling rate between 0.0 and 1.0 only applies to successful
+// requests, failed ones are always logged.
+//
+// The liveness resource always answers with status 200 as long as the
+// multiplexer is running. The readiness resource runs the health checks
+// registered via Environment.Health() and answers with status 200 and
+// the JSON report if no critical check fails, otherwise with 503. After
+// Drain() or when the context is done it answers with 503 and the
+// status "draining".
+func NewMultiplexer(ctx context.Context, cfg etc.Etc, options ...Option) Multiplexer {
+	tcfgs, err := prepareConfig(cfg)
+	if err != nil {
+		logger.Errorf("cannot configure the multiplexer: %v", err)
+		cfg, tcfgs = nil, nil
+	}
+	mux := &multiplexer{
+		environment:    newEnvironment(ctx, cfg),
+		mapping:        newMapping(cfg),
+		tenantContexts: make(map[string]context.Context),
+		corsOptions:    make(map[string]*CORSPolicy),
+	}
+	mux.requestMetrics = newRequestMetrics(mux.environment.metrics)
+	for _, option := range options {
+		option(mux)
 	}
+	mux.err = err
+	mux.configure(cfg, tcfgs)
+	return mux
+}
+
+// configure applies the settings of the multiplexer itself and
+// of the tenants.
+func (mux *multiplexer) configure(cfg etc.Etc, tcfgs map[string]etc.Etc) {
+	mux.metricsDomain, mux.metricsResource = "", ""
+	mux.healthDomain, mux.livenessRes, mux.readinessRes = "", "", ""
+	mux.accessLog = nil
+	mux.tenantHeader = ""
+	if cfg != nil {
+		if cfg.HasPath("metrics") {
+			mux.metricsDomain = cfg.ValueAsString("metrics/domain", "system")
+			mux.metricsResource = cfg.ValueAsString("metrics/resource", "metrics")
+		}
+		if cfg.HasPath("health") {
+			mux.healthDomain = cfg.ValueAsString("health/domain", "system")
+			mux.livenessRes = cfg.ValueAsString("health/liveness", "live")
+			mux.readinessRes = cfg.ValueAsString("health/readiness", "ready")
+		}
+		if cfg.HasPath("access-log") {
+			mux.accessLog = newAccessLog(cfg)
+		}
+		mux.tenantHeader = cfg.ValueAsString("tenant-header", mux.tenantHeader)
+	}
+	if mux.accessLogWriter != nil {
+		if mux.accessLog == nil {
+			mux.accessLog = newAccessLog(nil)
+		}
+		mux.accessLog.writer = mux.accessLogWriter
+	}
+	mux.cors = newCORS(cfg, mux.corsOptions)
+	mux.configureTenants(tcfgs)
 }
 
 // Register implements the Multiplexer interface.
 func (mux *multiplexer) Register(domain, resource string, handler ResourceHandler) error {
 	mux.mutex.Lock()
 	defer mux.mutex.Unlock()
+	if mux.err != nil {
+		return mux.err
+	}
 	err := handler.Init(mux.environment, domain, resource)
 	if err != nil {
 		return err
@@ -111,6 +332,59 @@ func (mux *multiplexer) RegisterAll(registrations Registrations) error {
 	return nil
 }
 
+// RegisterVersion implements the Multiplexer interface.
+func (mux *multiplexer) RegisterVersion(domain, resource, constraint string, handler ResourceHandler) error {
+	mux.mutex.Lock()
+	defer mux.mutex.Unlock()
+	if mux.err != nil {
+		return mux.err
+	}
+	if _, err := parseVersionConstraint(constraint); err != nil {
+		return err
+	}
+	err := handler.Init(mux.environment, domain, resource)
+	if err != nil {
+		return err
+	}
+	return mux.mapping.registerVersion(domain, resource, constraint, handler)
+}
+
+// DeprecateVersion implements the Multiplexer interface.
+func (mux *multiplexer) DeprecateVersion(domain, resource, constraint string, sunset time.Time) error {
+	mux.mutex.Lock()
+	defer mux.mutex.Unlock()
+	return mux.mapping.deprecateVersion(domain, resource, constraint, sunset)
+}
+
+// RegisterTenant implements the Multiplexer interface.
+func (mux *multiplexer) RegisterTenant(tenant, domain, resource string, handler ResourceHandler) error {
+	mux.mutex.Lock()
+	defer mux.mutex.Unlock()
+	if mux.err != nil {
+		return mux.err
+	}
+	t, err := mux.lookupTenant(tenant)
+	if err != nil {
+		return err
+	}
+	err = handler.Init(t.environment, domain, resource)
+	if err != nil {
+		return err
+	}
+	return t.mapping.register(domain, resource, handler)
+}
+
+// DeregisterTenant implements the Multiplexer interface.
+func (mux *multiplexer) DeregisterTenant(tenant, domain, resource string, ids ...string) {
+	mux.mutex.Lock()
+	defer mux.mutex.Unlock()
+	t, err := mux.lookupTenant(tenant)
+	if err != nil {
+		return
+	}
+	t.mapping.deregister(domain, resource, ids...)
+}
+
 // RegisteredHandlers implements the Multiplexer interface.
 func (mux *multiplexer) RegisteredHandlers(domain, resource string) []string {
 	mux.mutex.Lock()
@@ -125,25 +399,207 @@ func (mux *multiplexer) Deregister(domain, resource string, ids ...string) {
 	mux.mapping.deregister(domain, resource, ids...)
 }
 
+// Reconfigure implements the Multiplexer interface.
+func (mux *multiplexer) Reconfigure(cfg etc.Etc) error {
+	tcfgs, err := prepareConfig(cfg)
+	if err != nil {
+		return err
+	}
+	// Apply the configuration.
+	type notification struct {
+		handler ReconfigurableResourceHandler
+		env     Environment
+	}
+	var notifications []notification
+	collect := func(m *mapping, env Environment) {
+		for _, handler := range m.resourceHandlers() {
+			if rrh, ok := handler.(ReconfigurableResourceHandler); ok {
+				notifications = append(notifications, notification{rrh, env})
+			}
+		}
+	}
+	mux.mutex.Lock()
+	mux.environment.configure(nil, cfg)
+	mux.mapping.configure(cfg)
+	mux.configure(cfg, tcfgs)
+	mux.err = nil
+	collect(mux.mapping, mux.environment)
+	for _, t := range mux.tenants {
+		collect(t.mapping, t.environment)
+	}
+	mux.mutex.Unlock()
+	// Notify the handlers outside of the lock.
+	var errs []error
+	for _, n := range notifications {
+		if err := n.handler.Reconfigure(n.env); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Annotate(errors.Collect(errs...), ErrReconfigureHandler, errorMessages)
+	}
+	return nil
+}
+
+// Drain implements the Multiplexer interface.
+func (mux *multiplexer) Drain() {
+	atomic.StoreInt32(&mux.draining, 1)
+}
+
 // ServeHTTP implements the http.Handler interface.
 func (mux *multiplexer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	mux.mutex.RLock()
 	defer mux.mutex.RUnlock()
-	job := newJob(mux.environment, r, w)
-	measuring := monitoring.BeginMeasuring(job.String())
-	defer measuring.EndMeasuring()
-	if err := mux.mapping.handle(job); err != nil {
+	rw := newResponseWriter(w)
+	env := mux.environment
+	t := mux.tenantFor(r)
+	if t != nil {
+		env = t.environment
+	}
+	job := newJob(env, r, rw)
+	span, _ := SpanFromContext(job.Context())
+	defer span.Finish()
+	start := time.Now()
+	entry := &accessLogEntry{
+		job:   job,
+		rw:    rw,
+		start: start,
+	}
+	if err := mux.handle(job, t, entry); err != nil {
+		span.SetError(err)
 		mux.handleError("error handling request", job, err)
 	}
+	entry.duration = time.Since(start)
+	status := statusClass(rw.StatusCode())
+	mux.requestMetrics.total.Inc(entry.domain, entry.resource, r.Method, status)
+	mux.requestMetrics.duration.Observe(entry.duration.Seconds(), entry.domain, entry.resource, r.Method, status)
+	mux.requestMetrics.size.Observe(float64(rw.Size()), entry.domain, entry.resource, r.Method, status)
+	if mux.accessLog != nil {
+		mux.accessLog.log(entry)
+	}
+}
+
+// handle lets the matching handler list handle the job. Domain,
+// resource, and handler IDs of the handler list are stored in
+// the entry for metrics and access log.
+func (mux *multiplexer) handle(job Job, t *tenant, entry *accessLogEntry) error {
+	switch {
+	case isSystemRequest(job, mux.metricsDomain, mux.metricsResource):
+		entry.domain = mux.metricsDomain
+		entry.resource = mux.metricsResource
+		return mux.serveMetrics(job)
+	case isSystemRequest(job, mux.healthDomain, mux.livenessRes):
+		entry.domain = mux.healthDomain
+		entry.resource = mux.livenessRes
+		return mux.serveLiveness(job)
+	case isSystemRequest(job, mux.healthDomain, mux.readinessRes):
+		entry.domain = mux.healthDomain
+		entry.resource = mux.readinessRes
+		return mux.serveReadiness(job)
+	}
+	if mux.mapping.ignores(job) {
+		job.ResponseWriter().WriteHeader(StatusNoContent)
+		return nil
+	}
+	if mux.cors != nil && mux.cors.handle(job) {
+		return nil
+	}
+	hl, err := mux.handlerList(job, t)
+	if err != nil {
+		return err
+	}
+	entry.domain = hl.domain
+	entry.resource = hl.resource
+	entry.handlerIDs = hl.ids()
+	if hl.deprecated {
+		job.ResponseWriter().Header().Set("Deprecation", "true")
+		if !hl.sunset.IsZero() {
+			job.ResponseWriter().Header().Set("Sunset", hl.sunset.UTC().Format(http.TimeFormat))
+		}
+	}
+	method := job.Request().Method
+	mux.requestMetrics.inFlight.Inc(hl.domain, hl.resource, method)
+	defer mux.requestMetrics.inFlight.Dec(hl.domain, hl.resource, method)
+	logInfof(job, "handling %s", job)
+	return hl.handle(job)
+}
+
+// handlerList returns the handler list for the job. Those of
+// the tenant are preferred.
+func (mux *multiplexer) handlerList(job Job, t *tenant) (*handlerList, error) {
+	if t != nil {
+		hl, err := t.mapping.handlerList(job)
+		if !errors.IsError(err, ErrNoHandler) {
+			return hl, err
+		}
+	}
+	return mux.mapping.handlerList(job)
+}
+
+// isSystemRequest checks if the job requests the passed built-in
+// resource. An empty resource is not configured.
+func isSystemRequest(job Job, domain, resource string) bool {
+	if resource == "" {
+		return false
+	}
+	return strings.EqualFold(job.Domain(), domain) &&
+		strings.EqualFold(job.Resource(), resource) &&
+		job.Path().JoinedResourceID() == ""
+}
+
+// serveMetrics writes the metrics in the text exposition format.
+func (mux *multiplexer) serveMetrics(job Job) error {
+	if job.Request().Method != http.MethodGet {
+		return errors.New(ErrMethodNotSupported, errorMessages, job.Request().Method)
+	}
+	job.ResponseWriter().Header().Set("Content-Type", ContentTypeMetrics)
+	_, err := mux.environment.metrics.WriteTo(job.ResponseWriter())
+	return err
+}
+
+// serveLiveness answers the liveness probe.
+func (mux *multiplexer) serveLiveness(job Job) error {
+	if job.Request().Method != http.MethodGet && job.Request().Method != http.MethodHead {
+		return errors.New(ErrMethodNotSupported, errorMessages, job.Request().Method)
+	}
+	return job.JSON(false).Write(StatusOK, HealthReport{
+		Status: HealthUp,
+		Checks: []HealthCheckResult{},
+	})
+}
+
+// serveReadiness answers the readiness probe with the report
+// of the health checks.
+func (mux *multiplexer) serveReadiness(job Job) error {
+	if job.Request().Method != http.MethodGet && job.Request().Method != http.MethodHead {
+		return errors.New(ErrMethodNotSupported, errorMessages, job.Request().Method)
+	}
+	if atomic.LoadInt32(&mux.draining) == 1 || mux.environment.ctx.Err() != nil {
+		return job.JSON(false).Write(StatusServiceUnavailable, HealthReport{
+			Status: HealthDraining,
+			Checks: []HealthCheckResult{},
+		})
+	}
+	report := mux.environment.health.Check(job.Context())
+	status := StatusOK
+	if report.Status == HealthDown {
+		status = StatusServiceUnavailable
+	}
+	return job.JSON(false).Write(status, report)
 }
 
 // handleError logs an error and returns it to the user.
 func (mux *multiplexer) handleError(format string, job Job, err error) {
 	code := http.StatusInternalServerError
 	msg := fmt.Sprintf(format+" %q: %v", job, err)
-	logger.Errorf(msg)
-	if errors.IsError(err, ErrMethodNotSupported) {
+	logErrorf(job, "%s", msg)
+	switch {
+	case errors.IsError(err, ErrMethodNotSupported):
 		code = http.StatusMethodNotAllowed
+	case errors.IsError(err, ErrInvalidVersion):
+		code = http.StatusBadRequest
+	case errors.IsError(err, ErrUnsupportedVersion):
+		code = http.StatusNotAcceptable
 	}
 	http.Error(job.ResponseWriter(), msg, code)
 }
@@ -12,6 +12,7 @@ package rest
 //--------------------
 
 import (
+	"net"
 	"net/http"
 	"strings"
 
@@ -22,6 +23,17 @@ import (
 // CONSTANTS
 //--------------------
 
+// Strategies to resolve the domain of a request.
+const (
+	DomainFromPath   = "path"
+	DomainFromHost   = "host"
+	DomainFromHeader = "header"
+)
+
+// DefaultDomainHeader is the default name of the header containing
+// the domain if resolved by header.
+const DefaultDomainHeader = "X-Domain"
+
 // Path indexes for the different parts.
 const (
 	PathDomain     = 0
@@ -66,26 +78,86 @@ type Path interface {
 
 // path implements Path.
 type path struct {
-	parts []string
+	parts        []string
+	domainSource string
 }
 
-// newPath returns the analyzed path.
+// newPath returns the analyzed path. The domain is resolved with
+// the configured strategies in their order. If none matches the
+// default domain is used.
 func newPath(env *environment, r *http.Request) *path {
 	parts := stringex.SplitMap(r.URL.Path, "/", func(part string) (string, bool) {
 		if part == "" {
 			return "", false
 		}
 		return part, true
-	})[env.basepartsLen:]
-	switch len(parts) {
-	case 1:
+	})
+	if len(parts) >= env.basepartsLen {
+		parts = parts[env.basepartsLen:]
+	} else {
+		parts = []string{}
+	}
+	for _, strategy := range env.domainResolution {
+		var domain string
+		switch strategy {
+		case DomainFromPath:
+			if len(parts) == 0 {
+				continue
+			}
+			if len(parts) == 1 {
+				parts = append(parts, env.defaultResource)
+			}
+			return &path{
+				parts:        parts,
+				domainSource: DomainFromPath,
+			}
+		case DomainFromHost:
+			domain = domainFromHost(r.Host, env.domainHostBase)
+		case DomainFromHeader:
+			domain = r.Header.Get(env.domainHeader)
+		}
+		if domain != "" {
+			return newDomainlessPath(domain, strategy, parts, env)
+		}
+	}
+	return newDomainlessPath(env.defaultDomain, "", parts, env)
+}
+
+// newDomainlessPath returns a path with the passed domain and
+// the resource and resource ID taken from the parts.
+func newDomainlessPath(domain, source string, parts []string, env *environment) *path {
+	if len(parts) == 0 {
 		parts = append(parts, env.defaultResource)
-	case 0:
-		parts = append(parts, env.defaultDomain, env.defaultResource)
 	}
 	return &path{
-		parts: parts,
+		parts:        append([]string{domain}, parts...),
+		domainSource: source,
+	}
+}
+
+// domainFromHost returns the subdomain of the host. If a base is
+// given the host has to end with it, otherwise it needs at least
+// three labels and must not be an IP address.
+func domainFromHost(host, base string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if net.ParseIP(host) != nil {
+		return ""
+	}
+	if base != "" {
+		base = "." + strings.TrimPrefix(strings.ToLower(base), ".")
+		if !strings.HasSuffix(host, base) {
+			return ""
+		}
+		return strings.TrimSuffix(host, base)
+	}
+	labels := strings.Split(host, ".")
+	if len(labels) < 3 {
+		return ""
 	}
+	return labels[0]
 }
 
 // Length implements Path.
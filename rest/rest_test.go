@@ -12,10 +12,18 @@ package rest_test
 //--------------------
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/tideland/golib/audit"
 	"github.com/tideland/golib/etc"
@@ -321,6 +329,645 @@ func TestRESTHandler(t *testing.T) {
 	resp.AssertBodyContains("GET test/double/12345")
 }
 
+// TestRequestID tests the passing and generation of request IDs.
+func TestRequestID(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	// Setup the test server.
+	mux := newMultiplexer(assert)
+	ts := restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	err := mux.Register("test", "request-id", NewRequestIDHandler("request-id", assert))
+	assert.Nil(err)
+	header := http.CanonicalHeaderKey(rest.DefaultRequestIDHeader)
+	// Perform test requests.
+	req := restaudit.NewRequest("GET", "/base/test/request-id")
+	req.AddHeader(rest.DefaultRequestIDHeader, "my-request-4711")
+	resp := ts.DoRequest(req)
+	resp.AssertStatusEquals(200)
+	resp.AssertHeaderEquals(header, "my-request-4711")
+	resp.AssertBodyContains("my-request-4711")
+
+	req = restaudit.NewRequest("GET", "/base/test/request-id")
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(200)
+	id := resp.AssertHeader(header)
+	assert.Length(id, 32)
+	resp.AssertBodyContains(id)
+
+	req = restaudit.NewRequest("GET", "/base/test/request-id")
+	req.AddHeader(rest.DefaultRequestIDHeader, "no spaces allowed")
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(200)
+	id = resp.AssertHeader(header)
+	assert.Length(id, 32)
+}
+
+// TestTracing tests the continuation of traces and the
+// spans of the handlers.
+func TestTracing(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	// Setup the test server.
+	exporter := rest.NewMemorySpanExporter()
+	mux := newMultiplexer(assert, rest.WithSpanExporter(exporter))
+	ts := restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	err := mux.RegisterAll(rest.Registrations{
+		{"test", "tracing", NewAuthHandler("tracing:auth", assert)},
+		{"test", "tracing", NewRESTHandler("tracing:rest", assert)},
+	})
+	assert.Nil(err)
+	// Perform test request continuing a trace.
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	parentID := "00f067aa0ba902b7"
+	req := restaudit.NewRequest("GET", "/base/test/tracing/12345")
+	req.AddHeader("Token", "foo")
+	req.AddHeader("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	req.AddHeader("tracestate", "foo=bar")
+	resp := ts.DoRequest(req)
+	resp.AssertBodyContains("READ test/tracing/12345")
+	spans := exporter.Spans()
+	assert.Length(spans, 3)
+	root := spans[2]
+	assert.Equal(root.TraceID, traceID)
+	assert.Equal(root.ParentSpanID, parentID)
+	assert.Equal(root.TraceState, "foo=bar")
+	assert.Equal(root.Name, "GET test/tracing")
+	assert.Equal(spans[0].Name, "handler tracing:auth")
+	assert.Equal(spans[1].Name, "handler tracing:rest")
+	for _, span := range spans[:2] {
+		assert.Equal(span.TraceID, traceID)
+		assert.Equal(span.ParentSpanID, root.SpanID)
+		assert.True(span.Duration() <= root.Duration())
+	}
+	// Perform test request starting a new trace.
+	exporter.Reset()
+	req = restaudit.NewRequest("GET", "/base/test/tracing/12345")
+	req.AddHeader("Token", "foo")
+	req.AddHeader("traceparent", "illegal")
+	resp = ts.DoRequest(req)
+	resp.AssertBodyContains("READ test/tracing/12345")
+	spans = exporter.Spans()
+	assert.Length(spans, 3)
+	assert.Different(spans[2].TraceID, traceID)
+	assert.Equal(spans[2].ParentSpanID, "")
+	// Not sampled traces are continued but not exported.
+	exporter.Reset()
+	req = restaudit.NewRequest("GET", "/base/test/tracing/12345")
+	req.AddHeader("Token", "foo")
+	req.AddHeader("traceparent", "00-"+traceID+"-"+parentID+"-00")
+	resp = ts.DoRequest(req)
+	resp.AssertBodyContains("READ test/tracing/12345")
+	assert.Length(exporter.Spans(), 0)
+}
+
+// TestMetrics tests the collecting and serving of metrics.
+func TestMetrics(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	// Setup the test server.
+	cfgStr := "{etc {basepath /base/}{metrics {domain system}{resource metrics}}}"
+	cfg, err := etc.ReadString(cfgStr)
+	assert.Nil(err)
+	mux := rest.NewMultiplexer(context.Background(), cfg)
+	ts := restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	err = mux.Register("test", "metrics", NewMetricsHandler("metrics", assert))
+	assert.Nil(err)
+	// Perform test requests.
+	for i := 0; i < 3; i++ {
+		req := restaudit.NewRequest("GET", fmt.Sprintf("/base/test/metrics/%d", i))
+		resp := ts.DoRequest(req)
+		resp.AssertStatusEquals(200)
+	}
+	req := restaudit.NewRequest("GET", "/base/test/metrics/fail")
+	resp := ts.DoRequest(req)
+	resp.AssertStatusEquals(500)
+	// Retrieve the metrics.
+	req = restaudit.NewRequest("GET", "/base/system/metrics")
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(200)
+	resp.AssertHeaderContains("Content-Type", "text/plain; version=0.0.4")
+	resp.AssertBodyContains("# TYPE gorest_requests_total counter")
+	resp.AssertBodyContains(`gorest_requests_total{domain="test",resource="metrics",method="GET",status="2xx"} 3`)
+	resp.AssertBodyContains(`gorest_requests_total{domain="test",resource="metrics",method="GET",status="5xx"} 1`)
+	resp.AssertBodyContains(`gorest_request_duration_seconds_bucket{domain="test",resource="metrics",method="GET",status="2xx",le="+Inf"} 3`)
+	resp.AssertBodyContains(`gorest_requests_in_flight{domain="test",resource="metrics",method="GET"} 0`)
+	resp.AssertBodyContains(`gorest_response_size_bytes_sum{domain="test",resource="metrics",method="GET",status="2xx"} 6`)
+	resp.AssertBodyContains(`test_metrics_gets_total{id="metrics"} 4`)
+}
+
+// TestAccessLog tests the writing of the access log.
+func TestAccessLog(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	// Setup the test server.
+	cfgStr := "{etc {basepath /base/}{access-log {format logfmt}{fields request-id method path domain resource status size subject handlers}}}"
+	cfg, err := etc.ReadString(cfgStr)
+	assert.Nil(err)
+	buf := &bytes.Buffer{}
+	mux := rest.NewMultiplexer(context.Background(), cfg, rest.WithAccessLogWriter(buf))
+	ts := restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	err = mux.RegisterAll(rest.Registrations{
+		{"test", "access", NewSubjectHandler("access:subject", "john doe")},
+		{"test", "access", NewRequestIDHandler("access:request-id", assert)},
+	})
+	assert.Nil(err)
+	// Perform test request and check log.
+	req := restaudit.NewRequest("GET", "/base/test/access/4711")
+	req.AddHeader(rest.DefaultRequestIDHeader, "access-4711")
+	resp := ts.DoRequest(req)
+	resp.AssertStatusEquals(200)
+	assert.Equal(buf.String(), `request-id=access-4711 method=GET path=/base/test/access/4711 domain=test resource=access status=200 size=23 subject="john doe" handlers=access:subject,access:request-id`+"\n")
+	// Now as JSON with sampling.
+	cfgStr = "{etc {basepath /base/}{access-log {format json}{fields status path}{sampling 0.0}}}"
+	cfg, err = etc.ReadString(cfgStr)
+	assert.Nil(err)
+	buf.Reset()
+	mux = rest.NewMultiplexer(context.Background(), cfg, rest.WithAccessLogWriter(buf))
+	tsJSON := restaudit.StartServer(mux, assert)
+	defer tsJSON.Close()
+	err = mux.Register("test", "access", NewRequestIDHandler("access:request-id", assert))
+	assert.Nil(err)
+	req = restaudit.NewRequest("GET", "/base/test/access")
+	resp = tsJSON.DoRequest(req)
+	resp.AssertStatusEquals(200)
+	assert.Equal(buf.String(), "")
+	req = restaudit.NewRequest("GET", "/base/unknown/access")
+	resp = tsJSON.DoRequest(req)
+	resp.AssertStatusEquals(500)
+	assert.Equal(buf.String(), `{"status":500,"path":"/base/unknown/access"}`+"\n")
+}
+
+// TestHealth tests the liveness and readiness probes.
+func TestHealth(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	// Setup the test server.
+	cfgStr := "{etc {basepath /base/}{health {domain system}{liveness live}{readiness ready}}}"
+	cfg, err := etc.ReadString(cfgStr)
+	assert.Nil(err)
+	mux := rest.NewMultiplexer(context.Background(), cfg)
+	ts := restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	var dbErr atomic.Value
+	dbErr.Store("")
+	err = mux.Register("test", "health", NewHealthHandler("health", func(ctx context.Context) error {
+		if msg := dbErr.Load().(string); msg != "" {
+			return errors.New(msg)
+		}
+		return nil
+	}))
+	assert.Nil(err)
+	err = mux.Register("test", "health", NewHealthHandler("health", nil))
+	assert.ErrorMatch(err, `.*health check "health" is already registered.*`)
+	// Check liveness and readiness.
+	var report rest.HealthReport
+	req := restaudit.NewRequest("GET", "/base/system/live")
+	resp := ts.DoRequest(req)
+	resp.AssertStatusEquals(200)
+	resp.AssertUnmarshalledBody(&report)
+	assert.Equal(report.Status, rest.HealthUp)
+
+	req = restaudit.NewRequest("GET", "/base/system/ready")
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(200)
+	resp.AssertUnmarshalledBody(&report)
+	assert.Equal(report.Status, rest.HealthUp)
+	assert.Length(report.Checks, 3)
+	assert.Equal(report.Checks[0].Name, "health")
+	assert.Equal(report.Checks[1].Name, "health:optional")
+	assert.Equal(report.Checks[2].Name, "health:slow")
+	// Failing non-critical and timed out checks.
+	dbErr.Store("database unreachable")
+	req = restaudit.NewRequest("GET", "/base/system/ready")
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(503)
+	resp.AssertUnmarshalledBody(&report)
+	assert.Equal(report.Status, rest.HealthDown)
+	assert.Equal(report.Checks[0].Status, rest.HealthDown)
+	assert.Equal(report.Checks[0].Error, "database unreachable")
+	assert.Equal(report.Checks[1].Status, rest.HealthDown)
+	assert.Equal(report.Checks[2].Status, rest.HealthDown)
+	assert.Equal(report.Checks[2].Error, "context deadline exceeded")
+
+	dbErr.Store("")
+	mux.Drain()
+	req = restaudit.NewRequest("GET", "/base/system/ready")
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(503)
+	resp.AssertUnmarshalledBody(&report)
+	assert.Equal(report.Status, rest.HealthDraining)
+	req = restaudit.NewRequest("GET", "/base/system/live")
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(200)
+}
+
+// TestDomainResolution tests the resolving of domains by
+// host, header, and path.
+func TestDomainResolution(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	// Setup the test server.
+	cfgStr := "{etc {basepath /base/}{default-domain alpha}{default-resource paths}" +
+		"{domain-resolution header host path}{domain-header X-Tenant}{domain-host-base example.com}}"
+	cfg, err := etc.ReadString(cfgStr)
+	assert.Nil(err)
+	mux := rest.NewMultiplexer(context.Background(), cfg)
+	ts := restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	err = mux.RegisterAll(rest.Registrations{
+		{"alpha", "paths", NewDomainHandler("alpha")},
+		{"beta", "paths", NewDomainHandler("beta")},
+	})
+	assert.Nil(err)
+	setHost := func(host string) func(*http.Request) *http.Request {
+		return func(req *http.Request) *http.Request {
+			req.Host = host
+			return req
+		}
+	}
+	// Resolve by header.
+	req := restaudit.NewRequest("GET", "/base/paths/4711")
+	req.AddHeader("X-Tenant", "alpha")
+	resp := ts.DoRequest(req)
+	resp.AssertStatusEquals(200)
+	resp.AssertBodyContains("alpha paths 4711 | /base/paths/1 | /base/beta/paths/2")
+	// Resolve by host.
+	req = restaudit.NewRequest("GET", "/base/paths/4711")
+	req.SetRequestProcessor(setHost("beta.example.com:8080"))
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(200)
+	resp.AssertBodyContains("beta paths 4711 | /base/paths/1 | http://alpha.example.com:8080/base/paths/2")
+	// Resolve by path.
+	req = restaudit.NewRequest("GET", "/base/alpha/paths/4711")
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(200)
+	resp.AssertBodyContains("alpha paths 4711 | /base/alpha/paths/1 | /base/beta/paths/2")
+	// Fallback to the default domain.
+	req = restaudit.NewRequest("GET", "/base/")
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(200)
+	resp.AssertBodyContains("alpha paths  | /base/alpha/paths/1 | /base/beta/paths/2")
+}
+
+// TestDomainResolutionHeaderOnly tests the internal paths to other
+// domains if the domain is only resolved by header.
+func TestDomainResolutionHeaderOnly(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	// Setup the test server.
+	cfgStr := "{etc {basepath /base/}{domain-resolution header}{domain-header X-Tenant}}"
+	cfg, err := etc.ReadString(cfgStr)
+	assert.Nil(err)
+	mux := rest.NewMultiplexer(context.Background(), cfg)
+	ts := restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	err = mux.RegisterAll(rest.Registrations{
+		{"alpha", "paths", NewDomainHandler("alpha")},
+		{"beta", "paths", NewDomainHandler("beta")},
+	})
+	assert.Nil(err)
+	// Different domain is not part of the path, it has to be
+	// passed by header.
+	req := restaudit.NewRequest("GET", "/base/paths/4711")
+	req.AddHeader("X-Tenant", "alpha")
+	resp := ts.DoRequest(req)
+	resp.AssertStatusEquals(200)
+	resp.AssertBodyContains("alpha paths 4711 | /base/paths/1 | /base/paths/2")
+	req = restaudit.NewRequest("GET", "/base/paths/2")
+	req.AddHeader("X-Tenant", "beta")
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(200)
+	resp.AssertBodyContains("beta paths 2 | /base/paths/1 | /base/paths/2")
+}
+
+// TestVersionRouting tests the dispatching of jobs based on
+// the requested version.
+func TestVersionRouting(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	// Setup the test server.
+	mux := newMultiplexer(assert)
+	ts := restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	err := mux.RegisterVersion("test", "versions", "<2.0.0", NewDomainHandler("v1"))
+	assert.Nil(err)
+	err = mux.RegisterVersion("test", "versions", ">=2.0.0 <3.0.0", NewDomainHandler("v2"))
+	assert.Nil(err)
+	err = mux.RegisterVersion("test", "versions", ">=2.5.0 <3.0.0", NewDomainHandler("v2.5"))
+	assert.Nil(err)
+	err = mux.RegisterVersion("test", "versions", ">=abc", NewDomainHandler("illegal"))
+	assert.ErrorMatch(err, `.*invalid version constraint ">=abc".*`)
+	sunset := time.Date(2018, time.June, 30, 0, 0, 0, 0, time.UTC)
+	err = mux.DeprecateVersion("test", "versions", "<2.0.0", sunset)
+	assert.Nil(err)
+	err = mux.DeprecateVersion("test", "versions", "<1.0.0", sunset)
+	assert.ErrorMatch(err, `.*found no handler.*`)
+	assert.Equal(mux.RegisteredHandlers("test", "versions"), []string{"v1", "v2", "v2.5"})
+	// Perform test requests.
+	tests := []struct {
+		version string
+		status  int
+		id      string
+	}{
+		{"", 200, "v1"},
+		{"1.5.0", 200, "v1"},
+		{"2.0.0", 200, "v2"},
+		{"2.4.9", 200, "v2"},
+		{"2.5.0", 200, "v2.5"},
+		{"3.0.0", 406, ""},
+		{"illegal", 400, ""},
+	}
+	for _, test := range tests {
+		req := restaudit.NewRequest("GET", "/base/test/versions/1")
+		if test.version != "" {
+			req.AddHeader("Version", test.version)
+		}
+		resp := ts.DoRequest(req)
+		resp.AssertStatusEquals(test.status)
+		if test.id == "" {
+			continue
+		}
+		resp.AssertBodyContains(test.id + ": test versions 1")
+		switch test.id {
+		case "v1":
+			resp.AssertHeaderEquals("Deprecation", "true")
+			resp.AssertHeaderEquals("Sunset", "Sat, 30 Jun 2018 00:00:00 GMT")
+		default:
+			assert.Equal(resp.Header["Deprecation"], "")
+		}
+	}
+}
+
+// TestTenants tests the tenant environments.
+func TestTenants(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	// Setup the test server.
+	cfgStr := "{etc {basepath /base/}{tenant-header X-Customer}{tenants {acme {greeting Hello Acme}}{globex {default-domain globex}{greeting Hi Globex}}}}"
+	cfg, err := etc.ReadString(cfgStr)
+	assert.Nil(err)
+	ctx := context.WithValue(context.Background(), "test", "acme")
+	mux := rest.NewMultiplexer(context.Background(), cfg, rest.WithTenantContext("acme", ctx))
+	ts := restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	err = mux.Register("test", "tenant", NewTenantHandler("global", assert))
+	assert.Nil(err)
+	err = mux.RegisterTenant("acme", "test", "tenant", NewTenantHandler("acme", assert))
+	assert.Nil(err)
+	err = mux.RegisterTenant("initech", "test", "tenant", NewTenantHandler("initech", assert))
+	assert.ErrorMatch(err, `.*tenant "initech" is not configured.*`)
+	// Perform test requests.
+	req := restaudit.NewRequest("GET", "/base/test/tenant")
+	resp := ts.DoRequest(req)
+	resp.AssertBodyContains("global: tenant '' greeting 'none' value 'none'")
+
+	req = restaudit.NewRequest("GET", "/base/test/tenant")
+	req.AddHeader("X-Customer", "acme")
+	resp = ts.DoRequest(req)
+	resp.AssertBodyContains("acme: tenant 'acme' greeting 'Hello Acme' value 'acme'")
+
+	req = restaudit.NewRequest("GET", "/base/test/tenant")
+	req.AddHeader("X-Customer", "Globex")
+	resp = ts.DoRequest(req)
+	resp.AssertBodyContains("global: tenant 'globex' greeting 'Hi Globex' value 'none'")
+
+	req = restaudit.NewRequest("GET", "/base/")
+	req.AddHeader("X-Customer", "Globex")
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(500)
+	resp.AssertBodyContains("found no handler with ID \"globex/default\"")
+
+	mux.DeregisterTenant("acme", "test", "tenant")
+	req = restaudit.NewRequest("GET", "/base/test/tenant")
+	req.AddHeader("X-Customer", "acme")
+	resp = ts.DoRequest(req)
+	resp.AssertBodyContains("global: tenant 'acme' greeting 'Hello Acme' value 'acme'")
+}
+
+// TestTenantResolver tests the selection of tenants by a resolver
+// and that the header is only used if configured.
+func TestTenantResolver(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	// Setup the test server without tenant header.
+	cfgStr := "{etc {basepath /base/}{tenants {acme {greeting Hello Acme}}}}"
+	cfg, err := etc.ReadString(cfgStr)
+	assert.Nil(err)
+	mux := rest.NewMultiplexer(context.Background(), cfg)
+	ts := restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	err = mux.Register("test", "tenant", NewTenantHandler("global", assert))
+	assert.Nil(err)
+	// Header is ignored.
+	req := restaudit.NewRequest("GET", "/base/test/tenant")
+	req.AddHeader("X-Tenant", "acme")
+	resp := ts.DoRequest(req)
+	resp.AssertBodyContains("global: tenant '' greeting 'none' value 'none'")
+	// Setup the test server with resolver by host.
+	resolver := func(r *http.Request) string {
+		if strings.HasPrefix(r.Host, "acme.") {
+			return "acme"
+		}
+		return ""
+	}
+	cfgStr = "{etc {basepath /base/}{tenant-header X-Tenant}{tenants {acme {greeting Hello Acme}}}}"
+	cfg, err = etc.ReadString(cfgStr)
+	assert.Nil(err)
+	mux = rest.NewMultiplexer(context.Background(), cfg, rest.WithTenantResolver(resolver))
+	ts = restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	err = mux.Register("test", "tenant", NewTenantHandler("global", assert))
+	assert.Nil(err)
+	req = restaudit.NewRequest("GET", "/base/test/tenant")
+	req.SetRequestProcessor(func(req *http.Request) *http.Request {
+		req.Host = "acme.example.com"
+		return req
+	})
+	resp = ts.DoRequest(req)
+	resp.AssertBodyContains("global: tenant 'acme' greeting 'Hello Acme' value 'none'")
+	// Resolver is preferred to the header.
+	req = restaudit.NewRequest("GET", "/base/test/tenant")
+	req.AddHeader("X-Tenant", "acme")
+	resp = ts.DoRequest(req)
+	resp.AssertBodyContains("global: tenant '' greeting 'none' value 'none'")
+}
+
+// TestReconfigure tests the reconfiguration of a multiplexer.
+func TestReconfigure(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	// Setup the test server.
+	mux := newMultiplexer(assert)
+	ts := restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	handler := NewReconfigurableHandler("reconfigurable")
+	err := mux.Register("test", "reconfigurable", handler)
+	assert.Nil(err)
+	req := restaudit.NewRequest("GET", "/base/test/reconfigurable")
+	resp := ts.DoRequest(req)
+	resp.AssertBodyContains("basepath /base/ reconfigured 0")
+	// Invalid configuration keeps the old one.
+	cfg, err := etc.ReadString("{etc {basepath /new/}{domain-resolution path cookie}}")
+	assert.Nil(err)
+	err = mux.Reconfigure(cfg)
+	assert.ErrorMatch(err, ".*invalid configuration: unknown domain resolution cookie.*")
+	resp = ts.DoRequest(req)
+	resp.AssertBodyContains("basepath /base/ reconfigured 0")
+	// Now a valid one.
+	cfg, err = etc.ReadString("{etc {basepath /new/}{default-domain test}{default-resource reconfigurable}}")
+	assert.Nil(err)
+	err = mux.Reconfigure(cfg)
+	assert.Nil(err)
+	req = restaudit.NewRequest("GET", "/new/")
+	resp = ts.DoRequest(req)
+	resp.AssertBodyContains("basepath /new/ reconfigured 1")
+	// Invalid tenant configuration changes nothing too.
+	cfg, err = etc.ReadString("{etc {basepath /other/}{tenants {a {domain-resolution cookie}}}}")
+	assert.Nil(err)
+	err = mux.Reconfigure(cfg)
+	assert.ErrorMatch(err, ".*invalid configuration: tenants.*unknown domain resolution cookie.*")
+	resp = ts.DoRequest(req)
+	resp.AssertBodyContains("basepath /new/ reconfigured 1")
+}
+
+// TestInvalidInitialConfiguration tests that an invalid configuration
+// is rejected when creating the multiplexer like when reconfiguring.
+func TestInvalidInitialConfiguration(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	cfg, err := etc.ReadString("{etc {basepath /base/}{domain-resolution cookie}}")
+	assert.Nil(err)
+	mux := rest.NewMultiplexer(context.Background(), cfg)
+	err = mux.Register("test", "reconfigurable", NewReconfigurableHandler("reconfigurable"))
+	assert.ErrorMatch(err, ".*invalid configuration: unknown domain resolution cookie.*")
+	// A valid configuration allows registrations again.
+	cfg, err = etc.ReadString("{etc {basepath /base/}}")
+	assert.Nil(err)
+	err = mux.Reconfigure(cfg)
+	assert.Nil(err)
+	err = mux.Register("test", "reconfigurable", NewReconfigurableHandler("reconfigurable"))
+	assert.Nil(err)
+}
+
+// TestWatchConfigFile tests the reconfiguration by a changed
+// configuration file.
+func TestWatchConfigFile(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	// Setup the test server and the file.
+	dir, err := ioutil.TempDir("", "gorest-config")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "gorest.etc")
+	err = ioutil.WriteFile(filename, []byte("{etc {basepath /base/}}"), 0644)
+	assert.Nil(err)
+	mux := newMultiplexer(assert)
+	ts := restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	err = mux.Register("test", "reconfigurable", NewReconfigurableHandler("reconfigurable"))
+	assert.Nil(err)
+	watcher, err := rest.WatchConfigFile(mux, filename, 20*time.Millisecond)
+	assert.Nil(err)
+	defer watcher.Stop()
+	_, err = rest.WatchConfigFile(mux, filepath.Join(dir, "missing.etc"), time.Second)
+	assert.ErrorMatch(err, `.*cannot watch configuration file.*`)
+	_, err = rest.WatchConfigFile(mux, filename, 0)
+	assert.ErrorMatch(err, `.*watch interval has to be positive.*`)
+	// Change the file.
+	err = ioutil.WriteFile(filename, []byte("{etc {basepath /changed/}}"), 0644)
+	assert.Nil(err)
+	req := restaudit.NewRequest("GET", "/changed/test/reconfigurable")
+	for i := 0; i < 50; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if bytes.Contains(ts.DoRequest(req).Body, []byte("/changed/")) {
+			break
+		}
+	}
+	resp := ts.DoRequest(req)
+	resp.AssertStatusEquals(200)
+	resp.AssertBodyContains("basepath /changed/ reconfigured 1")
+}
+
+// TestCORS tests the handling of cross-origin requests.
+func TestCORS(t *testing.T) {
+	assert := audit.NewTestingAssertion(t, true)
+	// Setup the test server.
+	cfgStr := `{etc {basepath /base/}{cors {allowed-origins https://*.example.com}{exposed-headers X-Request-ID}{max-age 10m}
+	{policies {public {domain test}{resource public}{allowed-origins *}{allowed-methods GET}}}}}`
+	cfg, err := etc.ReadString(cfgStr)
+	assert.Nil(err)
+	mux := rest.NewMultiplexer(context.Background(), cfg, rest.WithCORSPolicy("test", "credentials", rest.CORSPolicy{
+		AllowedOrigins:   []string{"https://app.example.org"},
+		AllowedHeaders:   []string{"Authorization"},
+		AllowCredentials: true,
+	}), rest.WithCORSPolicy("test", "wildcard", rest.CORSPolicy{
+		AllowedOrigins:   []string{"*"},
+		AllowCredentials: true,
+	}))
+	ts := restaudit.StartServer(mux, assert)
+	defer ts.Close()
+	err = mux.RegisterAll(rest.Registrations{
+		{"test", "cors", NewRequestIDHandler("cors", assert)},
+		{"test", "public", NewRequestIDHandler("public", assert)},
+		{"test", "credentials", NewRequestIDHandler("credentials", assert)},
+		{"test", "wildcard", NewRequestIDHandler("wildcard", assert)},
+	})
+	assert.Nil(err)
+	// Preflight with global policy.
+	req := restaudit.NewRequest("OPTIONS", "/base/test/cors")
+	req.AddHeader("Origin", "https://shop.example.com")
+	req.AddHeader("Access-Control-Request-Method", "PUT")
+	req.AddHeader("Access-Control-Request-Headers", "Content-Type, X-Custom")
+	resp := ts.DoRequest(req)
+	resp.AssertStatusEquals(204)
+	resp.AssertHeaderEquals("Access-Control-Allow-Origin", "https://shop.example.com")
+	resp.AssertHeaderContains("Access-Control-Allow-Methods", "PUT")
+	resp.AssertHeaderEquals("Access-Control-Allow-Headers", "Content-Type, X-Custom")
+	resp.AssertHeaderEquals("Access-Control-Max-Age", "600")
+
+	req = restaudit.NewRequest("OPTIONS", "/base/test/cors")
+	req.AddHeader("Origin", "https://evil.example.org")
+	req.AddHeader("Access-Control-Request-Method", "PUT")
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(403)
+	assert.Equal(resp.Header["Access-Control-Allow-Origin"], "")
+	// Actual request.
+	req = restaudit.NewRequest("GET", "/base/test/cors")
+	req.AddHeader("Origin", "https://shop.example.com")
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(200)
+	resp.AssertHeaderEquals("Access-Control-Allow-Origin", "https://shop.example.com")
+	resp.AssertHeaderEquals("Access-Control-Expose-Headers", "X-Request-ID")
+	resp.AssertBodyContains("Request ID")
+	// Resource policies.
+	req = restaudit.NewRequest("OPTIONS", "/base/test/public")
+	req.AddHeader("Origin", "https://anywhere.org")
+	req.AddHeader("Access-Control-Request-Method", "GET")
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(204)
+	resp.AssertHeaderEquals("Access-Control-Allow-Origin", "*")
+	resp.AssertHeaderEquals("Access-Control-Allow-Methods", "GET")
+
+	req = restaudit.NewRequest("OPTIONS", "/base/test/public")
+	req.AddHeader("Origin", "https://anywhere.org")
+	req.AddHeader("Access-Control-Request-Method", "DELETE")
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(403)
+
+	req = restaudit.NewRequest("OPTIONS", "/base/test/credentials")
+	req.AddHeader("Origin", "https://app.example.org")
+	req.AddHeader("Access-Control-Request-Method", "POST")
+	req.AddHeader("Access-Control-Request-Headers", "authorization")
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(204)
+	resp.AssertHeaderEquals("Access-Control-Allow-Origin", "https://app.example.org")
+	resp.AssertHeaderEquals("Access-Control-Allow-Credentials", "true")
+	assert.Equal(resp.Header["Access-Control-Max-Age"], "")
+	// No credentials for any origin.
+	req = restaudit.NewRequest("GET", "/base/test/wildcard")
+	req.AddHeader("Origin", "https://evil.example.org")
+	resp = ts.DoRequest(req)
+	resp.AssertStatusEquals(200)
+	resp.AssertHeaderEquals("Access-Control-Allow-Origin", "*")
+	assert.Equal(resp.Header["Access-Control-Allow-Credentials"], "")
+	cfg, err = etc.ReadString(`{etc {basepath /base/}{cors {allowed-origins https://*.example.com}{allow-credentials true}
+	{policies {public {domain test}{resource public}{allowed-origins *}}}}}`)
+	assert.Nil(err)
+	err = mux.Reconfigure(cfg)
+	assert.ErrorMatch(err, ".*CORS credentials are not allowed for any origin.*")
+}
+
 //--------------------
 // AUTHENTICATION HANDLER
 //--------------------
@@ -582,18 +1229,267 @@ func (dh *doubleHandler) Read(job rest.Job) (bool, error) {
 	return true, nil
 }
 
+//--------------------
+// REQUEST ID HANDLER
+//--------------------
+
+// requestIDHandler checks and returns the request ID.
+type requestIDHandler struct {
+	id     string
+	assert audit.Assertion
+}
+
+func NewRequestIDHandler(id string, assert audit.Assertion) rest.ResourceHandler {
+	return &requestIDHandler{id, assert}
+}
+
+func (rh *requestIDHandler) ID() string {
+	return rh.id
+}
+
+func (rh *requestIDHandler) Init(env rest.Environment, domain, resource string) error {
+	return nil
+}
+
+func (rh *requestIDHandler) Get(job rest.Job) (bool, error) {
+	ctxID, ok := rest.RequestIDFromContext(job.Context())
+	rh.assert.True(ok)
+	rh.assert.Equal(ctxID, job.RequestID())
+	job.ResponseWriter().Write([]byte("Request ID: " + job.RequestID()))
+	return true, nil
+}
+
+//--------------------
+// METRICS HANDLER
+//--------------------
+
+// metricsHandler registers and increments an own metric.
+type metricsHandler struct {
+	id     string
+	assert audit.Assertion
+	gets   rest.Counter
+}
+
+func NewMetricsHandler(id string, assert audit.Assertion) rest.ResourceHandler {
+	return &metricsHandler{
+		id:     id,
+		assert: assert,
+	}
+}
+
+func (mh *metricsHandler) ID() string {
+	return mh.id
+}
+
+func (mh *metricsHandler) Init(env rest.Environment, domain, resource string) error {
+	_, err := env.Metrics().RegisterCounter("test metrics", "Invalid name.")
+	mh.assert.ErrorMatch(err, ".*metric \"test metrics\" cannot be registered.*")
+	_, err = env.Metrics().RegisterGauge("gorest_requests_total", "Already registered.")
+	mh.assert.ErrorMatch(err, ".*already registered differently.*")
+	mh.gets, err = env.Metrics().RegisterCounter("test_metrics_gets_total", "Number of GET requests.", "id")
+	return err
+}
+
+func (mh *metricsHandler) Get(job rest.Job) (bool, error) {
+	mh.gets.Inc(mh.id)
+	if job.ResourceID() == "fail" {
+		return false, fmt.Errorf("failing")
+	}
+	job.ResponseWriter().Write([]byte("OK"))
+	return true, nil
+}
+
+//--------------------
+// SUBJECT HANDLER
+//--------------------
+
+// subjectHandler sets a subject like an authentication handler.
+type subjectHandler struct {
+	id      string
+	subject string
+}
+
+func NewSubjectHandler(id, subject string) rest.ResourceHandler {
+	return &subjectHandler{id, subject}
+}
+
+func (sh *subjectHandler) ID() string {
+	return sh.id
+}
+
+func (sh *subjectHandler) Init(env rest.Environment, domain, resource string) error {
+	return nil
+}
+
+func (sh *subjectHandler) Get(job rest.Job) (bool, error) {
+	job.EnhanceContext(func(ctx context.Context) context.Context {
+		return rest.NewSubjectContext(ctx, sh.subject)
+	})
+	return true, nil
+}
+
+//--------------------
+// HEALTH HANDLER
+//--------------------
+
+// healthHandler registers health checks.
+type healthHandler struct {
+	id    string
+	check rest.HealthCheck
+}
+
+func NewHealthHandler(id string, check rest.HealthCheck) rest.ResourceHandler {
+	return &healthHandler{id, check}
+}
+
+func (hh *healthHandler) ID() string {
+	return hh.id
+}
+
+func (hh *healthHandler) Init(env rest.Environment, domain, resource string) error {
+	err := env.Health().Register(hh.id, hh.check, time.Second, true)
+	if err != nil {
+		return err
+	}
+	env.Health().Register(hh.id+":optional", func(ctx context.Context) error {
+		return hh.check(ctx)
+	}, time.Second, false)
+	env.Health().Register(hh.id+":slow", func(ctx context.Context) error {
+		if hh.check(ctx) != nil {
+			<-ctx.Done()
+		}
+		return nil
+	}, 50*time.Millisecond, false)
+	return nil
+}
+
+func (hh *healthHandler) Get(job rest.Job) (bool, error) {
+	return true, nil
+}
+
+//--------------------
+// DOMAIN HANDLER
+//--------------------
+
+// domainHandler returns the resolved path parts and internal paths.
+type domainHandler struct {
+	id string
+}
+
+func NewDomainHandler(id string) rest.ResourceHandler {
+	return &domainHandler{id}
+}
+
+func (dh *domainHandler) ID() string {
+	return dh.id
+}
+
+func (dh *domainHandler) Init(env rest.Environment, domain, resource string) error {
+	return nil
+}
+
+func (dh *domainHandler) Get(job rest.Job) (bool, error) {
+	other := "alpha"
+	if job.Domain() == "alpha" {
+		other = "beta"
+	}
+	body := fmt.Sprintf("%s: %s %s %s | %s | %s",
+		dh.id, job.Domain(), job.Resource(), job.ResourceID(),
+		job.InternalPath(job.Domain(), job.Resource(), "1"),
+		job.InternalPath(other, job.Resource(), "2"))
+	job.ResponseWriter().Write([]byte(body))
+	return true, nil
+}
+
+//--------------------
+// TENANT HANDLER
+//--------------------
+
+// tenantHandler returns data of the tenant environment.
+type tenantHandler struct {
+	id     string
+	assert audit.Assertion
+}
+
+func NewTenantHandler(id string, assert audit.Assertion) rest.ResourceHandler {
+	return &tenantHandler{id, assert}
+}
+
+func (th *tenantHandler) ID() string {
+	return th.id
+}
+
+func (th *tenantHandler) Init(env rest.Environment, domain, resource string) error {
+	if th.id != "global" {
+		th.assert.Equal(env.Tenant(), th.id)
+	}
+	return nil
+}
+
+func (th *tenantHandler) Get(job rest.Job) (bool, error) {
+	env := job.Environment()
+	greeting := "none"
+	if env.Config() != nil {
+		greeting = env.Config().ValueAsString("greeting", greeting)
+	}
+	value, ok := job.Context().Value("test").(string)
+	if !ok {
+		value = "none"
+	}
+	body := fmt.Sprintf("%s: tenant '%s' greeting '%s' value '%s'", th.id, env.Tenant(), greeting, value)
+	job.ResponseWriter().Write([]byte(body))
+	return true, nil
+}
+
+//--------------------
+// RECONFIGURABLE HANDLER
+//--------------------
+
+// reconfigurableHandler counts its reconfigurations.
+type reconfigurableHandler struct {
+	id           string
+	env          rest.Environment
+	reconfigured int32
+}
+
+func NewReconfigurableHandler(id string) rest.ResourceHandler {
+	return &reconfigurableHandler{
+		id: id,
+	}
+}
+
+func (rh *reconfigurableHandler) ID() string {
+	return rh.id
+}
+
+func (rh *reconfigurableHandler) Init(env rest.Environment, domain, resource string) error {
+	rh.env = env
+	return nil
+}
+
+func (rh *reconfigurableHandler) Reconfigure(env rest.Environment) error {
+	atomic.AddInt32(&rh.reconfigured, 1)
+	return nil
+}
+
+func (rh *reconfigurableHandler) Get(job rest.Job) (bool, error) {
+	body := fmt.Sprintf("basepath %s reconfigured %d", rh.env.Basepath(), atomic.LoadInt32(&rh.reconfigured))
+	job.ResponseWriter().Write([]byte(body))
+	return true, nil
+}
+
 //--------------------
 // HELPERS
 //--------------------
 
 // newMultiplexer creates a new multiplexer with a testing context
 // and a testing configuration.
-func newMultiplexer(assert audit.Assertion) rest.Multiplexer {
+func newMultiplexer(assert audit.Assertion, options ...rest.Option) rest.Multiplexer {
 	ctx := context.WithValue(context.Background(), "test", "foo")
 	cfgStr := "{etc {basepath /base/}{default-domain testing}{default-resource index}}"
 	cfg, err := etc.ReadString(cfgStr)
 	assert.Nil(err)
-	return rest.NewMultiplexer(ctx, cfg)
+	return rest.NewMultiplexer(ctx, cfg, options...)
 }
 
 // EOF
@@ -0,0 +1,124 @@
+// Tideland GoREST - REST - Tenants
+//
+// Copyright (C) 2009-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package rest
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/tideland/golib/errors"
+	"github.com/tideland/golib/etc"
+)
+
+//--------------------
+// TENANT
+//--------------------
+
+// TenantResolver returns the name of the tenant of a request or an
+// empty string. It is run by the server, so it may use trusted data
+// like the host, the client certificate, or an authenticated session.
+type TenantResolver func(r *http.Request) string
+
+// WithTenantResolver sets the resolver selecting the tenant of a
+// request. It is preferred to the tenant-header of the configuration.
+func WithTenantResolver(resolver TenantResolver) Option {
+	return func(mux *multiplexer) {
+		mux.tenantResolver = resolver
+	}
+}
+
+// WithTenantContext sets the context of a configured tenant. It
+// allows to pass tenant specific values to the handlers. Without
+// it the context of the multiplexer is used.
+func WithTenantContext(tenant string, ctx context.Context) Option {
+	return func(mux *multiplexer) {
+		mux.tenantContexts[strings.ToLower(tenant)] = ctx
+	}
+}
+
+// tenant contains the environment and the handlers of one tenant.
+type tenant struct {
+	environment *environment
+	mapping     *mapping
+}
+
+// tenantConfigs returns the configuration subtrees of the tenants
+// section by the lowercase tenant names.
+func tenantConfigs(cfg etc.Etc) (map[string]etc.Etc, error) {
+	tcfgs := make(map[string]etc.Etc)
+	if cfg == nil || !cfg.HasPath("tenants") {
+		return tcfgs, nil
+	}
+	err := cfg.Do("tenants", func(path string) error {
+		tcfg, err := cfg.Split(path)
+		if err != nil {
+			return err
+		}
+		if err = validateDomainResolution(tcfg); err != nil {
+			return err
+		}
+		tcfgs[strings.ToLower(strings.TrimPrefix(path, "tenants/"))] = tcfg
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Annotate(err, ErrInvalidConfiguration, errorMessages, "tenants")
+	}
+	return tcfgs, nil
+}
+
+// configureTenants creates or reconfigures the tenants based on
+// their configurations. Existing tenants keep their handlers and
+// their templates cache, tenants not configured anymore are removed.
+func (mux *multiplexer) configureTenants(tcfgs map[string]etc.Etc) {
+	tenants := make(map[string]*tenant)
+	for name, tcfg := range tcfgs {
+		if t, ok := mux.tenants[name]; ok {
+			t.environment.configureTenant(mux.tenantContexts[name], mux.environment, tcfg)
+			t.mapping.configure(tcfg)
+			tenants[name] = t
+			continue
+		}
+		tenants[name] = &tenant{
+			environment: newTenantEnvironment(mux.tenantContexts[name], mux.environment, name, tcfg),
+			mapping:     newMapping(tcfg),
+		}
+	}
+	mux.tenants = tenants
+}
+
+// tenantFor returns the tenant selected by the request or nil. The
+// resolver is preferred, the header is only used if configured.
+func (mux *multiplexer) tenantFor(r *http.Request) *tenant {
+	var name string
+	switch {
+	case mux.tenantResolver != nil:
+		name = mux.tenantResolver(r)
+	case mux.tenantHeader != "":
+		name = r.Header.Get(mux.tenantHeader)
+	}
+	if name == "" {
+		return nil
+	}
+	return mux.tenants[strings.ToLower(name)]
+}
+
+// lookupTenant returns the named tenant or an error.
+func (mux *multiplexer) lookupTenant(name string) (*tenant, error) {
+	t, ok := mux.tenants[strings.ToLower(name)]
+	if !ok {
+		return nil, errors.New(ErrUnknownTenant, errorMessages, name)
+	}
+	return t, nil
+}
+
+// EOF
@@ -12,11 +12,26 @@ package rest
 //--------------------
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 )
 
+//--------------------
+// CONSTANTS
+//--------------------
+
+// DefaultRequestIDHeader is the header used for request IDs
+// if none is configured.
+const DefaultRequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen is the maximum length of a request ID accepted
+// from a client.
+const maxRequestIDLen = 128
+
 //--------------------
 // LANGUAGE
 //--------------------
@@ -73,4 +88,46 @@ func (kvs KeyValues) String() string {
 	return strings.Join(kvss, "&")
 }
 
+//--------------------
+// REQUEST ID
+//--------------------
+
+// newRequestID generates a new random request ID.
+func newRequestID() string {
+	return randomHex(16)
+}
+
+// isValidRequestID checks if a request ID passed by a client can
+// be used. It must not be too long and must not contain characters
+// that could break log lines or headers.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("-_.:/+=", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+//--------------------
+// RANDOM
+//--------------------
+
+// randomHex returns n random bytes hex encoded.
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		// Fallback to the current time, at least unique enough.
+		ts := fmt.Sprintf("%0*x", 2*n, time.Now().UnixNano())
+		return ts[len(ts)-2*n:]
+	}
+	return hex.EncodeToString(buf)
+}
+
 // EOF
@@ -0,0 +1,280 @@
+// Tideland GoREST - REST - Tracing
+//
+// Copyright (C) 2009-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package rest
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// Header names of the W3C Trace Context.
+const (
+	HeaderTraceParent = "Traceparent"
+	HeaderTraceState  = "Tracestate"
+)
+
+// traceFlagSampled is the trace flag marking a trace as sampled.
+const traceFlagSampled byte = 0x01
+
+//--------------------
+// SPAN EXPORTER
+//--------------------
+
+// SpanExporter receives finished spans, e.g. to send them to
+// a tracing backend.
+type SpanExporter interface {
+	// ExportSpan is called for each finished and sampled span.
+	ExportSpan(span *Span)
+}
+
+// MemorySpanExporter is a span exporter storing the spans in
+// memory. It is intended for tests.
+type MemorySpanExporter interface {
+	SpanExporter
+
+	// Spans returns the exported spans in the order of
+	// their finishing.
+	Spans() []*Span
+
+	// Reset removes all exported spans.
+	Reset()
+}
+
+// memorySpanExporter implements MemorySpanExporter.
+type memorySpanExporter struct {
+	mutex sync.Mutex
+	spans []*Span
+}
+
+// NewMemorySpanExporter creates an exporter keeping all spans
+// in memory.
+func NewMemorySpanExporter() MemorySpanExporter {
+	return &memorySpanExporter{}
+}
+
+// ExportSpan implements the SpanExporter interface.
+func (e *memorySpanExporter) ExportSpan(span *Span) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans implements the MemorySpanExporter interface.
+func (e *memorySpanExporter) Spans() []*Span {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	spans := make([]*Span, len(e.spans))
+	copy(spans, e.spans)
+	return spans
+}
+
+// Reset implements the MemorySpanExporter interface.
+func (e *memorySpanExporter) Reset() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = nil
+}
+
+//--------------------
+// SPAN
+//--------------------
+
+// Span describes a timed operation inside of a trace. The IDs
+// follow the W3C Trace Context specification.
+type Span struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Flags        byte
+	TraceState   string
+	Name         string
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]string
+	Error        string
+
+	mutex    sync.Mutex
+	exporter SpanExporter
+}
+
+// StartSpan starts a new span with a new trace, e.g. for clients
+// calling servers outside of a job.
+func StartSpan(name string, exporter SpanExporter) *Span {
+	return &Span{
+		TraceID:    newTraceID(),
+		SpanID:     newSpanID(),
+		Flags:      traceFlagSampled,
+		Name:       name,
+		Start:      time.Now(),
+		Attributes: map[string]string{},
+		exporter:   exporter,
+	}
+}
+
+// newRootSpan starts a new span continuing the trace passed
+// by the traceparent and tracestate headers of the request. If
+// there's no valid one a new trace is started.
+func newRootSpan(r *http.Request, name string, exporter SpanExporter) *Span {
+	span := StartSpan(name, exporter)
+	traceID, parentID, flags, ok := parseTraceParent(r.Header.Get(HeaderTraceParent))
+	if ok {
+		span.TraceID = traceID
+		span.ParentSpanID = parentID
+		span.Flags = flags
+		span.TraceState = r.Header.Get(HeaderTraceState)
+	}
+	return span
+}
+
+// StartChild starts a new span as child of this one. It is part
+// of the same trace and is exported to the same exporter.
+func (s *Span) StartChild(name string) *Span {
+	return &Span{
+		TraceID:      s.TraceID,
+		SpanID:       newSpanID(),
+		ParentSpanID: s.SpanID,
+		Flags:        s.Flags,
+		TraceState:   s.TraceState,
+		Name:         name,
+		Start:        time.Now(),
+		Attributes:   map[string]string{},
+		exporter:     s.exporter,
+	}
+}
+
+// SetAttribute sets an attribute of the span.
+func (s *Span) SetAttribute(key, value string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Attributes[key] = value
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Error = err.Error()
+}
+
+// IsSampled returns true if the span shall be exported.
+func (s *Span) IsSampled() bool {
+	return s.Flags&traceFlagSampled != 0
+}
+
+// Duration returns the duration of a finished span.
+func (s *Span) Duration() time.Duration {
+	if s.End.IsZero() {
+		return 0
+	}
+	return s.End.Sub(s.Start)
+}
+
+// Finish ends the span and passes it to the exporter if it
+// is sampled. Only the first call has an effect.
+func (s *Span) Finish() {
+	s.mutex.Lock()
+	if !s.End.IsZero() {
+		s.mutex.Unlock()
+		return
+	}
+	s.End = time.Now()
+	s.mutex.Unlock()
+	if s.exporter != nil && s.IsSampled() {
+		s.exporter.ExportSpan(s)
+	}
+}
+
+// TraceParent returns the span in the format of the traceparent
+// header.
+func (s *Span) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", s.TraceID, s.SpanID, s.Flags)
+}
+
+// AddToRequest sets the trace context headers of the request, so
+// that the called server continues the trace with this span as
+// parent.
+func (s *Span) AddToRequest(req *http.Request) *http.Request {
+	req.Header.Set(HeaderTraceParent, s.TraceParent())
+	if s.TraceState != "" {
+		req.Header.Set(HeaderTraceState, s.TraceState)
+	}
+	return req
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// parseTraceParent parses a traceparent header value. Only
+// the fields of version 00 are used, later versions are
+// accepted as long as they start the same way.
+func parseTraceParent(tp string) (traceID, parentID string, flags byte, ok bool) {
+	parts := strings.Split(strings.TrimSpace(tp), "-")
+	if len(parts) < 4 {
+		return "", "", 0, false
+	}
+	version, traceID, parentID, flagsStr := parts[0], parts[1], parts[2], parts[3]
+	if !isLowerHex(version, 2) || version == "ff" {
+		return "", "", 0, false
+	}
+	if version == "00" && len(parts) != 4 {
+		return "", "", 0, false
+	}
+	if !isLowerHex(traceID, 32) || traceID == strings.Repeat("0", 32) {
+		return "", "", 0, false
+	}
+	if !isLowerHex(parentID, 16) || parentID == strings.Repeat("0", 16) {
+		return "", "", 0, false
+	}
+	if !isLowerHex(flagsStr, 2) {
+		return "", "", 0, false
+	}
+	fb, _ := hex.DecodeString(flagsStr)
+	return traceID, parentID, fb[0], true
+}
+
+// isLowerHex checks if the string has the given length and only
+// contains lowercase hexadecimal characters.
+func isLowerHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// newTraceID generates a new random trace ID.
+func newTraceID() string {
+	return randomHex(16)
+}
+
+// newSpanID generates a new random span ID.
+func newSpanID() string {
+	return randomHex(8)
+}
+
+// EOF
@@ -0,0 +1,127 @@
+// Tideland GoREST - REST - Versioning
+//
+// Copyright (C) 2009-2017 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package rest
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"strings"
+
+	"github.com/tideland/golib/errors"
+	"github.com/tideland/golib/version"
+)
+
+//--------------------
+// VERSION CONSTRAINT
+//--------------------
+
+// versionTerm is one comparison of a version constraint.
+type versionTerm struct {
+	operator string
+	version  version.Version
+}
+
+// matches checks if the version fulfills the term.
+func (vt versionTerm) matches(v version.Version) bool {
+	precedence, _ := v.Compare(vt.version)
+	switch vt.operator {
+	case ">=":
+		return precedence != version.Older
+	case ">":
+		return precedence == version.Newer
+	case "<=":
+		return precedence != version.Newer
+	case "<":
+		return precedence == version.Older
+	case "!=":
+		return precedence != version.Equal
+	default:
+		return precedence == version.Equal
+	}
+}
+
+// versionConstraint is a number of space separated terms like
+// ">=2.0.0 <3.0.0" which all have to match. Allowed operators
+// are =, !=, >, >=, <, and <=. A version without an operator
+// has to be equal.
+type versionConstraint struct {
+	raw   string
+	terms []versionTerm
+}
+
+// parseVersionConstraint parses the passed constraint.
+func parseVersionConstraint(raw string) (*versionConstraint, error) {
+	vc := &versionConstraint{
+		raw: strings.TrimSpace(raw),
+	}
+	fields := strings.Fields(vc.raw)
+	if len(fields) == 0 {
+		return nil, errors.New(ErrInvalidVersionConstraint, errorMessages, raw)
+	}
+	for _, field := range fields {
+		operator := "="
+		for _, op := range []string{">=", "<=", "!=", ">", "<", "="} {
+			if strings.HasPrefix(field, op) {
+				operator = op
+				field = field[len(op):]
+				break
+			}
+		}
+		v, err := version.Parse(field)
+		if err != nil {
+			return nil, errors.Annotate(err, ErrInvalidVersionConstraint, errorMessages, raw)
+		}
+		vc.terms = append(vc.terms, versionTerm{operator, v})
+	}
+	return vc, nil
+}
+
+// matches checks if the version fulfills all terms.
+func (vc *versionConstraint) matches(v version.Version) bool {
+	for _, term := range vc.terms {
+		if !term.matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// lowerBound returns the highest version of the terms defining
+// a minimum. It's used to select the highest matching constraint.
+func (vc *versionConstraint) lowerBound() version.Version {
+	lower := version.New(0, 0, 0)
+	for _, term := range vc.terms {
+		switch term.operator {
+		case ">=", ">", "=":
+			if precedence, _ := term.version.Compare(lower); precedence == version.Newer {
+				lower = term.version
+			}
+		}
+	}
+	return lower
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// requestedVersion returns the version requested by the job. It is
+// false if the Version header is set but invalid.
+func requestedVersion(job Job) (version.Version, bool) {
+	if job.Request().Header.Get("Version") == "" {
+		return job.Version(), true
+	}
+	if _, err := version.Parse(job.Request().Header.Get("Version")); err != nil {
+		return nil, false
+	}
+	return job.Version(), true
+}
+
+// EOF